@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/config"
+	"github.com/gavsidhu/notion-hooks/internal/logging"
+	"github.com/gavsidhu/notion-hooks/internal/migrations"
+	"github.com/gavsidhu/notion-hooks/internal/notion"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/gavsidhu/notion-hooks/internal/webhook"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+var errConfigPrinted = errors.New("config printed")
+
+// loadConfig parses args into fs, which the caller may have added its own
+// flags to, and returns the validated config. With -print-config it prints
+// the config and returns errConfigPrinted.
+func loadConfig(fs *flag.FlagSet, args []string) (config.Config, error) {
+	printConfig := fs.Bool("print-config", false, "print the effective config with secrets redacted and exit")
+	cfg, err := config.Load(fs, args)
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	if *printConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			return config.Config{}, err
+		}
+		return config.Config{}, errConfigPrinted
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return config.Config{}, fmt.Errorf("invalid config:\n%w", err)
+	}
+
+	return cfg, nil
+}
+
+// app holds the dependencies shared by the commands.
+type app struct {
+	cfg      config.Config
+	logger   *logrus.Logger
+	db       *pgxpool.Pool
+	stores   store.Stores
+	rabbitMQ *config.RabbitMQConnection
+}
+
+// newApp connects to Postgres and, when withBroker is set, to RabbitMQ.
+func newApp(ctx context.Context, cfg config.Config, withBroker bool) (*app, error) {
+	logger, err := logging.New(cfg.LoggingOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	if withBroker && cfg.RabbitMQURL == "" {
+		return nil, errors.New("invalid config:\nrabbitmq_url is required")
+	}
+
+	db, err := pgxpool.New(ctx, cfg.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &app{cfg: cfg, logger: logger, db: db, stores: store.PostgresStores(db, clock.Real())}
+
+	if withBroker {
+		a.rabbitMQ, err = config.NewRabbitMQConnection(cfg.RabbitMQURL, a.log("rabbitmq"))
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+func (a *app) log(component string) logrus.FieldLogger {
+	return logging.Component(a.logger, component)
+}
+
+func (a *app) processor() *webhook.Processor {
+	return webhook.NewProcessor(
+		a.log("processor"),
+		a.stores,
+		clock.Real(),
+		webhook.CommentOptions{
+			Interval:        a.cfg.Comments.Interval,
+			MaxPagesPerPoll: a.cfg.Comments.MaxPagesPerPoll,
+		},
+		a.notionOptions()...,
+	)
+}
+
+func (a *app) notionOptions() []notion.Option {
+	return []notion.Option{
+		notion.WithBaseURL(a.cfg.Notion.BaseURL),
+		notion.WithVersion(a.cfg.Notion.Version),
+		notion.WithLogger(a.log("notion")),
+	}
+}
+
+func (a *app) history() *webhook.History {
+	return webhook.NewHistory(a.stores.Snapshots, a.cfg.History.Retention, clock.Real())
+}
+
+func (a *app) Close() {
+	a.db.Close()
+	if a.rabbitMQ != nil {
+		a.rabbitMQ.Close()
+	}
+}
+
+// runWithApp loads the config for a one-shot command and runs fn with its
+// dependencies.
+func runWithApp(fs *flag.FlagSet, args []string, withBroker bool, fn func(ctx context.Context, a *app, args []string) error) error {
+	cfg, err := loadConfig(fs, args)
+	if errors.Is(err, errConfigPrinted) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	a, err := newApp(ctx, cfg, withBroker)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := migrations.Check(ctx, a.db); err != nil {
+		return err
+	}
+
+	return fn(ctx, a, fs.Args())
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/models"
+)
+
+// runEventsCommand implements "events list", which prints the stored event
+// history for auditing, and "events replay", which delivers stored events
+// again.
+func runEventsCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: webhooks events list|replay [flags]")
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("events list", flag.ExitOnError)
+		filter := eventFilterFlags(fs)
+		return runWithApp(fs, args[1:], false, func(ctx context.Context, a *app, _ []string) error {
+			f, err := filter()
+			if err != nil {
+				return err
+			}
+			return listEvents(ctx, a, f)
+		})
+	case "replay":
+		fs := flag.NewFlagSet("events replay", flag.ExitOnError)
+		filter := eventFilterFlags(fs)
+		return runWithApp(fs, args[1:], false, func(ctx context.Context, a *app, ids []string) error {
+			f, err := filter()
+			if err != nil {
+				return err
+			}
+			return replayEvents(ctx, a, ids, f)
+		})
+	default:
+		return fmt.Errorf("unknown events command %q", args[0])
+	}
+}
+
+// eventFilterFlags registers the event filter flags on fs and returns a
+// function that builds the filter once fs has been parsed.
+func eventFilterFlags(fs *flag.FlagSet) func() (models.EventFilter, error) {
+	webhookID := fs.String("webhook", "", "only events for this webhook ID")
+	eventType := fs.String("type", "", "only events of this type, e.g. page.updated")
+	objectID := fs.String("object-id", "", "only events for this Notion object ID")
+	since := fs.Duration("since", 0, "only events newer than this, e.g. 720h")
+	from := fs.String("from", "", "only events at or after this RFC 3339 time")
+	to := fs.String("to", "", "only events before this RFC 3339 time")
+	limit := fs.Int("limit", 100, "maximum number of events")
+
+	return func() (models.EventFilter, error) {
+		filter := models.EventFilter{
+			WebhookID: *webhookID,
+			Type:      *eventType,
+			ObjectID:  *objectID,
+			Limit:     *limit,
+		}
+
+		if *since > 0 {
+			t := time.Now().Add(-*since)
+			filter.From = &t
+		}
+		if *from != "" {
+			t, err := time.Parse(time.RFC3339, *from)
+			if err != nil {
+				return models.EventFilter{}, fmt.Errorf("invalid -from: %w", err)
+			}
+			filter.From = &t
+		}
+		if *to != "" {
+			t, err := time.Parse(time.RFC3339, *to)
+			if err != nil {
+				return models.EventFilter{}, fmt.Errorf("invalid -to: %w", err)
+			}
+			filter.To = &t
+		}
+
+		return filter, nil
+	}
+}
+
+func listEvents(ctx context.Context, a *app, filter models.EventFilter) error {
+	events, err := a.stores.Events.ListEvents(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CREATED\tID\tWEBHOOK\tTYPE\tOBJECT\tSTATUS\tATTEMPTS")
+	for _, event := range events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\n", event.CreatedAt.Format(time.RFC3339), event.ID, event.WebhookID, event.Type, event.ObjectID, event.Status, event.Attempts)
+	}
+
+	return w.Flush()
+}
+
+// replayEvents replays the events with the given IDs or, without IDs, the
+// events matching filter. A filter must name a webhook so a bare command
+// can't redeliver everyone's events.
+func replayEvents(ctx context.Context, a *app, ids []string, filter models.EventFilter) error {
+	if len(ids) == 0 {
+		if filter.WebhookID == "" {
+			return errors.New("usage: webhooks events replay <event-id>... or -webhook <id> [filters]")
+		}
+
+		events, err := a.stores.Events.ListEvents(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+	}
+
+	replayed, err := a.stores.Events.Replay(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range replayed {
+		fmt.Println(id)
+	}
+	fmt.Fprintf(os.Stderr, "queued %d of %d events for redelivery\n", len(replayed), len(ids))
+
+	return nil
+}
@@ -1,56 +1,78 @@
 package main
 
 import (
-	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"strings"
 
-	"github.com/gavsidhu/notion-hooks/internal/config"
-	"github.com/gavsidhu/notion-hooks/internal/logging"
-	"github.com/gavsidhu/notion-hooks/internal/webhook"
-	"github.com/gavsidhu/notion-hooks/internal/worker"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 )
 
-var maxWorkers = 1
+const usage = `Usage: webhooks <command> [flags]
+
+Long-running roles:
+  all                       run every role in one process (the default)
+  serve                     run the events API
+  scheduler                 run the scheduler and the outbox relay
+  worker -queues=a,b        run consumers for processing, events and/or initial-poll
+
+Operations:
+  migrate up|down|status    apply, roll back or list database migrations
+  webhook list              list webhooks
+  webhook pause <id>...     stop polling webhooks
+  webhook resume <id>...    resume polling paused webhooks
+  webhook diff <id>         show what changed between two points in time
+  webhook query <id>        set or clear a webhook's database filter and sorts
+  events list               show stored events
+  events replay <id>...     deliver stored events again
+  poll-once <webhook-id>    poll a webhook now, outside of the scheduler
+
+Every command accepts the config flags; run "webhooks <command> -h" to list
+them.
+`
+
+type command func(args []string) error
+
+var commands = map[string]command{
+	"all":       runAll,
+	"serve":     runServe,
+	"scheduler": runScheduler,
+	"worker":    runWorker,
+	"migrate":   runMigrateCommand,
+	"webhook":   runWebhookCommand,
+	"events":    runEventsCommand,
+	"poll-once": runPollOnce,
+}
 
 func main() {
-	logging.Logger.Info("Starting the application")
 	err := godotenv.Load()
-	if err != nil {
-		logging.Logger.Fatal("Error loading .env file")
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, "Error loading .env file:", err)
+		os.Exit(1)
 	}
 
-	ctx := context.Background()
-
-	rabbitMQ, err := config.NewRabbitMQConnection(os.Getenv("RABBITMQ_CONNECTION_URL"))
-	if err != nil {
-		logging.Logger.Fatal(err)
-	}
-
-	defer rabbitMQ.Close()
-
-	dbpool, err := pgxpool.New(ctx, os.Getenv("DATABASE_URL"))
-
-	if err != nil {
-		logging.Logger.Fatal(err)
+	// Running without a command, or with only flags, keeps the original
+	// behaviour of starting everything.
+	name, args := "all", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
 
-	go webhook.StartPollingDatabase(ctx, dbpool, rabbitMQ.Ch)
-
-	for i := 0; i < maxWorkers; i++ {
-		go worker.StartWorker(rabbitMQ, "proccessingQueue", dbpool, webhook.ProccessWebhook)
-
+	if name == "help" {
+		fmt.Print(usage)
+		return
 	}
 
-	for i := 0; i < maxWorkers; i++ {
-		go worker.StartWorker(rabbitMQ, "eventsQueue", dbpool, webhook.SendEventsToUser)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
 	}
 
-	for i := 0; i < maxWorkers; i++ {
-		go worker.StartWorker(rabbitMQ, "initalPollQueue", dbpool, webhook.HandleInitialPolling)
+	if err := cmd(args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-
-	select {}
-
 }
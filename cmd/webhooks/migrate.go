@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/migrations"
+)
+
+// runMigrateCommand implements "migrate up", "migrate down" and
+// "migrate status".
+func runMigrateCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: webhooks migrate up|down|status [flags]")
+	}
+
+	fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+
+	switch args[0] {
+	case "up":
+		return runMigrations(fs, args[1:], func(ctx context.Context, a *app) error {
+			applied, err := migrations.Up(ctx, a.db)
+			for _, m := range applied {
+				fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+			}
+			if err == nil && len(applied) == 0 {
+				fmt.Println("schema is up to date")
+			}
+			return err
+		})
+	case "down":
+		steps := fs.Int("steps", 1, "number of migrations to roll back")
+		return runMigrations(fs, args[1:], func(ctx context.Context, a *app) error {
+			rolledBack, err := migrations.Down(ctx, a.db, *steps)
+			for _, m := range rolledBack {
+				fmt.Printf("rolled back %d_%s\n", m.Version, m.Name)
+			}
+			return err
+		})
+	case "status":
+		return runMigrations(fs, args[1:], func(ctx context.Context, a *app) error {
+			statuses, err := migrations.Statuses(ctx, a.db)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+			for _, s := range statuses {
+				applied := "pending"
+				if s.AppliedAt != nil {
+					applied = s.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+			}
+			return w.Flush()
+		})
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// runMigrations is runWithApp without the schema version check.
+func runMigrations(fs *flag.FlagSet, args []string, fn func(ctx context.Context, a *app) error) error {
+	cfg, err := loadConfig(fs, args)
+	if errors.Is(err, errConfigPrinted) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	a, err := newApp(ctx, cfg, false)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	return fn(ctx, a)
+}
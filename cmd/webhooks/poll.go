@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// runPollOnce implements "poll-once", which polls a webhook immediately and
+// reports how many events it generated. The events are delivered by the
+// outbox relay of a running scheduler.
+func runPollOnce(args []string) error {
+	fs := flag.NewFlagSet("poll-once", flag.ExitOnError)
+	return runWithApp(fs, args, false, func(ctx context.Context, a *app, ids []string) error {
+		if len(ids) != 1 {
+			return errors.New("usage: webhooks poll-once <webhook-id> [flags]")
+		}
+
+		changes, err := a.processor().PollOnce(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("polled %s: %d events\n", ids[0], changes)
+
+		return nil
+	})
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/api"
+	"github.com/gavsidhu/notion-hooks/internal/migrations"
+	"github.com/gavsidhu/notion-hooks/internal/tracing"
+	"github.com/gavsidhu/notion-hooks/internal/webhook"
+	"github.com/gavsidhu/notion-hooks/internal/worker"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+)
+
+// queueNames maps the names accepted by -queues to the broker queues.
+var queueNames = map[string]string{
+	"processing":   "proccessingQueue",
+	"events":       "eventsQueue",
+	"initial-poll": "initalPollQueue",
+}
+
+// roles selects what a long-running process does. Every process also
+// serves the admin endpoints for probes and metrics.
+type roles struct {
+	api bool
+	// apiOptional lets the process start without the API when it can't
+	// authenticate callers, rather than refusing to start.
+	apiOptional bool
+	scheduler   bool
+	queues      []string
+}
+
+// allRoles is everything in one process, the default command. Deployments
+// that predate the API's token secret keep running the pipeline without it.
+var allRoles = roles{
+	api:         true,
+	apiOptional: true,
+	scheduler:   true,
+	queues:      []string{"processing", "events", "initial-poll"},
+}
+
+func runAll(args []string) error {
+	return runRoles(flag.NewFlagSet("all", flag.ExitOnError), args, allRoles)
+}
+
+func runServe(args []string) error {
+	return runRoles(flag.NewFlagSet("serve", flag.ExitOnError), args, roles{api: true})
+}
+
+func runScheduler(args []string) error {
+	return runRoles(flag.NewFlagSet("scheduler", flag.ExitOnError), args, roles{scheduler: true})
+}
+
+func runWorker(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	queues := fs.String("queues", "processing,events,initial-poll", "comma-separated queues to consume: processing, events, initial-poll")
+
+	// The queue list is only known once the flags are parsed.
+	return runRolesFunc(fs, args, func() (roles, error) {
+		var r roles
+		for _, name := range strings.Split(*queues, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := queueNames[name]; !ok {
+				return roles{}, fmt.Errorf("unknown queue %q", name)
+			}
+			r.queues = append(r.queues, name)
+		}
+		return r, nil
+	})
+}
+
+// checkAPISecret makes sure the API can authenticate its callers. Without a
+// token secret an optional API is turned off, which it reports, and a
+// required one is an error.
+func checkAPISecret(r *roles, tokenSecret string) (bool, error) {
+	if !r.api || tokenSecret != "" {
+		return false, nil
+	}
+	if !r.apiOptional {
+		return false, errors.New("invalid config:\napi.token_secret is required to serve the events API")
+	}
+	r.api = false
+	return true, nil
+}
+
+func runRoles(fs *flag.FlagSet, args []string, r roles) error {
+	return runRolesFunc(fs, args, func() (roles, error) { return r, nil })
+}
+
+// runRolesFunc starts the selected roles and blocks until SIGINT or SIGTERM,
+// then drains in-flight work.
+func runRolesFunc(fs *flag.FlagSet, args []string, selectRoles func() (roles, error)) error {
+	cfg, err := loadConfig(fs, args)
+	if errors.Is(err, errConfigPrinted) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r, err := selectRoles()
+	if err != nil {
+		return err
+	}
+	skipAPI, err := checkAPISecret(&r, cfg.API.TokenSecret)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// A server that can't listen shuts the other roles down as a signal
+	// would, and its error is returned once they have drained.
+	ctx, fail := context.WithCancelCause(ctx)
+	defer fail(nil)
+
+	needsBroker := r.scheduler || len(r.queues) > 0
+	a, err := newApp(ctx, cfg, needsBroker)
+	if err != nil {
+		return err
+	}
+
+	logger := a.logger
+	if skipAPI {
+		logger.Warn("api.token_secret is not set, so the events API is not served")
+	}
+
+	// Refuse to run against a schema the queries weren't written for.
+	if err := migrations.Check(ctx, a.db); err != nil {
+		a.Close()
+		return err
+	}
+	logger.WithFields(logrus.Fields{
+		"api":       r.api,
+		"scheduler": r.scheduler,
+		"queues":    r.queues,
+	}).Info("Starting the application")
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing.Exporter)
+	if err != nil {
+		a.Close()
+		return err
+	}
+
+	// Handlers get their own context so a shutdown signal stops new work
+	// without interrupting messages that are already being handled.
+	handlerCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+
+	var wg sync.WaitGroup
+
+	adminOpts := api.AdminOptions{
+		Pool:     a.db,
+		RabbitMQ: a.rabbitMQ,
+		Token:    cfg.Admin.Token,
+		Logger:   a.log("admin"),
+	}
+
+	if r.api {
+		serveHTTP(ctx, fail, &wg, a.log("api"), cfg.API.Addr, cfg.ShutdownTimeout, api.NewRouter(a.stores.Events, a.stores.Webhooks, a.history(), cfg.API.TokenSecret, a.log("api")))
+	}
+
+	if r.scheduler {
+		scheduler := webhook.NewScheduler(a.db, a.stores.Webhooks, a.rabbitMQ, webhook.SchedulerOptions{
+			Tick:             cfg.Scheduler.Tick,
+			PerUserLimit:     cfg.Scheduler.MaxPollsPerUser,
+			MaxClaimsPerTick: cfg.Scheduler.MaxClaimsPerTick,
+			ClaimTimeout:     cfg.Scheduler.ClaimTimeout,
+		}, a.log("scheduler"))
+		adminOpts.Scheduler = scheduler
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduler.StartPollingDatabase(ctx)
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			webhook.StartOutboxRelay(ctx, a.log("outbox"), a.stores.Outbox, a.rabbitMQ)
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			webhook.StartHistoryPruner(ctx, a.log("history"), a.history())
+		}()
+	}
+
+	if len(r.queues) > 0 {
+		workers := worker.NewRegistry()
+		adminOpts.Workers = workers
+		processor := a.processor()
+		workerLog := a.log("worker")
+
+		handlers := map[string]struct {
+			count   int
+			handler func(context.Context, amqp091.Delivery)
+		}{
+			"processing":   {cfg.Workers.Processing, processor.ProccessWebhook},
+			"events":       {cfg.Workers.Events, processor.SendEventsToUser},
+			"initial-poll": {cfg.Workers.InitialPoll, processor.HandleInitialPolling},
+		}
+
+		for _, name := range r.queues {
+			h := handlers[name]
+			for i := 0; i < h.count; i++ {
+				wg.Add(1)
+				go func(queue string) {
+					defer wg.Done()
+					worker.StartWorker(ctx, handlerCtx, workerLog, workers, a.rabbitMQ, queue, h.handler)
+				}(queueNames[name])
+			}
+		}
+	}
+
+	serveHTTP(ctx, fail, &wg, a.log("admin"), cfg.Admin.Addr, cfg.ShutdownTimeout, api.NewAdminRouter(adminOpts))
+
+	<-ctx.Done()
+	logger.Info("Shutting down, waiting for in-flight work to finish")
+
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(cfg.ShutdownTimeout):
+		logger.Warn("Timed out waiting for in-flight work, cancelling handlers")
+		cancelHandlers()
+		<-drained
+	}
+
+	a.Close()
+
+	tracingCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		logger.WithError(err).Error("Error flushing traces")
+	}
+
+	logger.Info("Shutdown complete")
+
+	if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// serveHTTP runs an HTTP server on addr until ctx is cancelled. If the
+// server fails, it cancels ctx through fail with the error.
+func serveHTTP(ctx context.Context, fail context.CancelCauseFunc, wg *sync.WaitGroup, log logrus.FieldLogger, addr string, shutdownTimeout time.Duration, handler http.Handler) {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.Info(fmt.Sprintf("Starting server on %s", addr))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("Server failed, shutting down")
+			fail(fmt.Errorf("serving on %s: %w", addr, err))
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+}
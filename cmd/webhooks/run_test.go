@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/logging"
+)
+
+func TestCheckAPISecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		roles   roles
+		secret  string
+		api     bool
+		skipped bool
+		valid   bool
+	}{
+		{"all without a secret", allRoles, "", false, true, true},
+		{"all with a secret", allRoles, "api_secret", true, false, true},
+		{"serve without a secret", roles{api: true}, "", false, false, false},
+		{"serve with a secret", roles{api: true}, "api_secret", true, false, true},
+		{"scheduler without a secret", roles{scheduler: true}, "", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.roles
+			skipped, err := checkAPISecret(&r, tt.secret)
+			if (err == nil) != tt.valid {
+				t.Fatalf("got error %v, want valid %t", err, tt.valid)
+			}
+			if err != nil {
+				return
+			}
+			if r.api != tt.api || skipped != tt.skipped {
+				t.Errorf("got api %t and skipped %t, want api %t and skipped %t", r.api, skipped, tt.api, tt.skipped)
+			}
+			if r.scheduler != tt.roles.scheduler || len(r.queues) != len(tt.roles.queues) {
+				t.Errorf("got roles %+v, want only the API changed from %+v", r, tt.roles)
+			}
+		})
+	}
+}
+
+func TestServeHTTPFailureCancelsTheRun(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer taken.Close()
+
+	ctx, fail := context.WithCancelCause(context.Background())
+	defer fail(nil)
+
+	var wg sync.WaitGroup
+	serveHTTP(ctx, fail, &wg, logging.Nop(), taken.Addr().String(), time.Second, http.NotFoundHandler())
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the run wasn't cancelled when the server couldn't listen")
+	}
+	wg.Wait()
+
+	if err := context.Cause(ctx); errors.Is(err, context.Canceled) {
+		t.Errorf("got cause %v, want the server's error", err)
+	}
+}
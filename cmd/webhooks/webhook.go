@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/notion"
+)
+
+// runWebhookCommand implements "webhook list", "webhook pause",
+// "webhook resume", "webhook diff" and "webhook query".
+func runWebhookCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: webhooks webhook list|pause|resume|diff|query [flags]")
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("webhook list", flag.ExitOnError)
+		userID := fs.String("user", "", "only webhooks owned by this user ID")
+		status := fs.String("status", "", "only webhooks in this status: idle, processing or paused")
+		return runWithApp(fs, args[1:], false, func(ctx context.Context, a *app, _ []string) error {
+			return listWebhooks(ctx, a, models.WebhookFilter{UserID: *userID, Status: *status})
+		})
+	case "pause":
+		fs := flag.NewFlagSet("webhook pause", flag.ExitOnError)
+		return runWithApp(fs, args[1:], false, func(ctx context.Context, a *app, ids []string) error {
+			return forEachWebhook(ctx, a, ids, "paused", a.stores.Webhooks.Pause)
+		})
+	case "resume":
+		fs := flag.NewFlagSet("webhook resume", flag.ExitOnError)
+		return runWithApp(fs, args[1:], false, func(ctx context.Context, a *app, ids []string) error {
+			return forEachWebhook(ctx, a, ids, "resumed", a.stores.Webhooks.Resume)
+		})
+	case "diff":
+		fs := flag.NewFlagSet("webhook diff", flag.ExitOnError)
+		from := fs.String("from", "", "RFC 3339 time to diff from (required)")
+		to := fs.String("to", "", "RFC 3339 time to diff to, default now")
+		asJSON := fs.Bool("json", false, "print the diff and events as JSON")
+		return runWithApp(fs, args[1:], false, func(ctx context.Context, a *app, ids []string) error {
+			if len(ids) != 1 || *from == "" {
+				return errors.New("usage: webhooks webhook diff -from <time> [-to <time>] <webhook-id>")
+			}
+			return diffWebhook(ctx, a, ids[0], *from, *to, *asJSON)
+		})
+	case "query":
+		fs := flag.NewFlagSet("webhook query", flag.ExitOnError)
+		filter := fs.String("filter", "", "Notion database query filter as JSON")
+		sorts := fs.String("sorts", "", "Notion database query sorts as a JSON array")
+		clearQuery := fs.Bool("clear", false, "remove the filter and sorts")
+		return runWithApp(fs, args[1:], false, func(ctx context.Context, a *app, ids []string) error {
+			if len(ids) != 1 || (*clearQuery == (*filter != "" || *sorts != "")) {
+				return errors.New("usage: webhooks webhook query -filter <json> [-sorts <json>] | -clear <webhook-id>")
+			}
+			return setWebhookQuery(ctx, a, ids[0], json.RawMessage(*filter), json.RawMessage(*sorts))
+		})
+	default:
+		return fmt.Errorf("unknown webhook command %q", args[0])
+	}
+}
+
+func listWebhooks(ctx context.Context, a *app, filter models.WebhookFilter) error {
+	webhooks, err := a.stores.Webhooks.ListWebhooks(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSER\tOBJECT\tSTATUS\tACTIVE\tLAST POLLED\tNEXT POLL")
+	for _, hook := range webhooks {
+		fmt.Fprintf(w, "%s\t%s\t%s %s\t%s\t%t\t%s\t%s\n", hook.ID, hook.UserID, hook.NotionObjectType, hook.NotionObjectID, hook.Status, hook.IsActive, formatTime(hook.LastPolled), formatTime(hook.NextPollAt))
+	}
+
+	return w.Flush()
+}
+
+// diffWebhook prints what changed in the webhook's database between two
+// points in its snapshot history.
+func diffWebhook(ctx context.Context, a *app, id string, fromArg string, toArg string, asJSON bool) error {
+	from, err := time.Parse(time.RFC3339, fromArg)
+	if err != nil {
+		return fmt.Errorf("invalid -from: %w", err)
+	}
+	to := time.Now()
+	if toArg != "" {
+		if to, err = time.Parse(time.RFC3339, toArg); err != nil {
+			return fmt.Errorf("invalid -to: %w", err)
+		}
+	}
+
+	hook, err := a.stores.Webhooks.GetWebhook(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+
+	diff, err := a.history().Diff(ctx, hook, from, to)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tOBJECT")
+	for _, event := range diff.Events {
+		fmt.Fprintf(w, "%s\t%s\n", event.Type, event.Data.ObjectID)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d added, %d deleted, %d updated between %s and %s\n", len(diff.Diff.Added), len(diff.Diff.Deleted), len(diff.Diff.Updated), from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+	return nil
+}
+
+// setWebhookQuery validates a filter and sorts against the schema of the
+// webhook's database and saves them. Pages already in the snapshot that
+// the new filter leaves out are reported as page.left_view on the next
+// poll, and pages it lets in as page.entered_view.
+func setWebhookQuery(ctx context.Context, a *app, id string, filter json.RawMessage, sorts json.RawMessage) error {
+	hook, err := a.stores.Webhooks.GetWebhook(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+
+	if len(filter) > 0 || len(sorts) > 0 {
+		if hook.NotionObjectType != "database" {
+			return fmt.Errorf("%s: only database webhooks can have a query", id)
+		}
+		for name, value := range map[string]json.RawMessage{"-filter": filter, "-sorts": sorts} {
+			if len(value) > 0 && !json.Valid(value) {
+				return fmt.Errorf("%s is not valid JSON", name)
+			}
+		}
+
+		token, err := a.stores.Integrations.GetNotionAccessToken(ctx, hook.UserID)
+		if err != nil {
+			return fmt.Errorf("getting Notion access token: %w", err)
+		}
+		database, err := notion.NewNotionClient(token, a.notionOptions()...).GetDatabase(ctx, hook.NotionObjectID)
+		if err != nil {
+			return fmt.Errorf("getting database %s: %w", hook.NotionObjectID, err)
+		}
+		if err := notion.ValidateQuery(database, filter, sorts); err != nil {
+			return fmt.Errorf("invalid query:\n%w", err)
+		}
+	}
+
+	if len(filter) == 0 {
+		filter = nil
+	}
+	if len(sorts) == 0 {
+		sorts = nil
+	}
+	if err := a.stores.Webhooks.SetQuery(ctx, id, filter, sorts); err != nil {
+		return fmt.Errorf("%s: %w", id, err)
+	}
+
+	if filter == nil && sorts == nil {
+		fmt.Printf("cleared query of %s\n", id)
+	} else {
+		fmt.Printf("set query of %s\n", id)
+	}
+
+	return nil
+}
+
+func forEachWebhook(ctx context.Context, a *app, ids []string, verb string, fn func(context.Context, string) error) error {
+	if len(ids) == 0 {
+		return errors.New("at least one webhook ID is required")
+	}
+
+	var errs []error
+	for _, id := range ids {
+		if err := fn(ctx, id); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			continue
+		}
+		fmt.Printf("%s %s\n", verb, id)
+	}
+
+	return errors.Join(errs...)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
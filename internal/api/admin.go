@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/config"
+	"github.com/gavsidhu/notion-hooks/internal/webhook"
+	"github.com/gavsidhu/notion-hooks/internal/worker"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+const readinessTimeout = 2 * time.Second
+
+var errConsumersNotRunning = errors.New("not every consumer is running")
+
+// AdminOptions wires the admin server to the components running in this
+// process. Scheduler may be nil when the process doesn't run one.
+type AdminOptions struct {
+	Pool      *pgxpool.Pool
+	RabbitMQ  *config.RabbitMQConnection
+	Workers   *worker.Registry
+	Scheduler *webhook.Scheduler
+	// Token, when set, is required as a bearer token on /admin routes. The
+	// config only allows it to be empty on a loopback address.
+	Token  string
+	Logger logrus.FieldLogger
+}
+
+type CheckResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+type QueueStatus struct {
+	Name      string `json:"name"`
+	Depth     int    `json:"depth"`
+	Consumers int    `json:"consumers"`
+	Paused    bool   `json:"paused"`
+	Error     string `json:"error,omitempty"`
+}
+
+type AdminStatusResponse struct {
+	Workers   []worker.Status          `json:"workers"`
+	Queues    []QueueStatus            `json:"queues"`
+	Scheduler *webhook.SchedulerStatus `json:"scheduler,omitempty"`
+}
+
+type adminHandler struct {
+	opts AdminOptions
+	log  logrus.FieldLogger
+}
+
+// NewAdminRouter serves liveness and readiness probes, Prometheus metrics
+// and the admin endpoints. It is meant to listen on an internal port.
+func NewAdminRouter(opts AdminOptions) http.Handler {
+	h := &adminHandler{opts: opts, log: opts.Logger}
+
+	r := chi.NewRouter()
+	r.Get("/healthz", h.healthz)
+	r.Get("/readyz", h.readyz)
+	r.Handle("/metrics", promhttp.Handler())
+
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(h.requireToken)
+		r.Get("/", h.status)
+		r.Post("/queues/{queue}/pause", h.pauseQueue)
+		r.Post("/queues/{queue}/resume", h.resumeQueue)
+	})
+
+	return r
+}
+
+func (h *adminHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(h.log, w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (h *adminHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	res := ReadinessResponse{Ready: true, Checks: map[string]CheckResult{}}
+	check := func(name string, err error) {
+		if err != nil {
+			res.Ready = false
+			res.Checks[name] = CheckResult{OK: false, Error: err.Error()}
+			return
+		}
+		res.Checks[name] = CheckResult{OK: true}
+	}
+
+	check("postgres", h.opts.Pool.Ping(ctx))
+
+	if h.opts.RabbitMQ != nil {
+		var err error
+		if !h.opts.RabbitMQ.IsConnected() {
+			err = config.ErrConnectionClosed
+		}
+		check("rabbitmq", err)
+	}
+
+	if h.opts.Workers != nil {
+		var err error
+		if !h.opts.Workers.Ready() {
+			err = errConsumersNotRunning
+		}
+		check("consumers", err)
+	}
+
+	status := http.StatusOK
+	if !res.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(h.log, w, status, res)
+}
+
+func (h *adminHandler) status(w http.ResponseWriter, r *http.Request) {
+	res := AdminStatusResponse{
+		Workers: []worker.Status{},
+		Queues:  []QueueStatus{},
+	}
+
+	if h.opts.Workers != nil {
+		res.Workers = h.opts.Workers.Statuses()
+	}
+
+	if h.opts.RabbitMQ != nil {
+		for _, name := range config.Queues {
+			queue := QueueStatus{Name: name}
+			if h.opts.Workers != nil {
+				queue.Paused = h.opts.Workers.IsPaused(name)
+			}
+			depth, consumers, err := h.opts.RabbitMQ.QueueDepth(name)
+			if err != nil {
+				queue.Error = err.Error()
+			}
+			queue.Depth = depth
+			queue.Consumers = consumers
+			res.Queues = append(res.Queues, queue)
+		}
+	}
+
+	if h.opts.Scheduler != nil {
+		status := h.opts.Scheduler.Status()
+		res.Scheduler = &status
+	}
+
+	writeJSON(h.log, w, http.StatusOK, res)
+}
+
+func (h *adminHandler) pauseQueue(w http.ResponseWriter, r *http.Request) {
+	h.setQueuePaused(w, r, true)
+}
+
+func (h *adminHandler) resumeQueue(w http.ResponseWriter, r *http.Request) {
+	h.setQueuePaused(w, r, false)
+}
+
+func (h *adminHandler) setQueuePaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if h.opts.Workers == nil {
+		writeError(h.log, w, http.StatusNotFound, "no workers run in this process")
+		return
+	}
+
+	queue := chi.URLParam(r, "queue")
+
+	var err error
+	if paused {
+		err = h.opts.Workers.Pause(queue)
+	} else {
+		err = h.opts.Workers.Resume(queue)
+	}
+	if err != nil {
+		writeError(h.log, w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeJSON(h.log, w, http.StatusOK, QueueStatus{Name: queue, Paused: paused})
+}
+
+func (h *adminHandler) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.opts.Token != "" {
+			expected := "Bearer " + h.opts.Token
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+				writeError(h.log, w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/gavsidhu/notion-hooks/internal/webhook"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+type handler struct {
+	events      store.EventStore
+	webhooks    store.WebhookStore
+	history     *webhook.History
+	tokenSecret string
+	log         logrus.FieldLogger
+}
+
+// NewRouter serves the events API. Every route requires a user token signed
+// with tokenSecret, and callers only see their own webhooks and events.
+func NewRouter(events store.EventStore, webhooks store.WebhookStore, history *webhook.History, tokenSecret string, log logrus.FieldLogger) http.Handler {
+	h := &handler{events: events, webhooks: webhooks, history: history, tokenSecret: tokenSecret, log: log}
+
+	r := chi.NewRouter()
+	r.Use(h.authenticate)
+	r.Get("/events", h.listEvents)
+	r.Get("/webhooks/{webhookID}/events", h.listEvents)
+	r.Get("/webhooks/{webhookID}/diff", h.diffHistory)
+
+	return r
+}
+
+// listEvents serves the stored event history. It accepts the type,
+// object_id, from, to (RFC 3339) and limit query parameters, plus
+// webhook_id when not scoped by the path.
+func (h *handler) listEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := models.EventFilter{
+		UserID:    callerID(r),
+		WebhookID: chi.URLParam(r, "webhookID"),
+		Type:      query.Get("type"),
+		ObjectID:  query.Get("object_id"),
+	}
+	if filter.WebhookID == "" {
+		filter.WebhookID = query.Get("webhook_id")
+	} else if _, ok := h.ownedWebhook(w, r, filter.WebhookID); !ok {
+		return
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(query.Get("from")); err != nil {
+		writeError(h.log, w, http.StatusBadRequest, "from must be an RFC 3339 timestamp")
+		return
+	}
+	if filter.To, err = parseTimeParam(query.Get("to")); err != nil {
+		writeError(h.log, w, http.StatusBadRequest, "to must be an RFC 3339 timestamp")
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 1 {
+			writeError(h.log, w, http.StatusBadRequest, "limit must be a positive integer")
+			return
+		}
+	}
+
+	events, err := h.events.ListEvents(r.Context(), filter)
+	if err != nil {
+		h.log.WithFields(logrus.Fields{
+			"error":      err,
+			"webhook_id": filter.WebhookID,
+		}).Error("Error listing events")
+		writeError(h.log, w, http.StatusInternalServerError, "failed to list events")
+		return
+	}
+
+	if events == nil {
+		events = []models.EventRecord{}
+	}
+
+	writeJSON(h.log, w, http.StatusOK, models.EventsResponse{Events: events})
+}
+
+// diffHistory serves what changed in a webhook's database between the from
+// and to query parameters (RFC 3339). to defaults to now.
+func (h *handler) diffHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	webhookID := chi.URLParam(r, "webhookID")
+
+	from, err := parseTimeParam(query.Get("from"))
+	if err != nil || from == nil {
+		writeError(h.log, w, http.StatusBadRequest, "from is required and must be an RFC 3339 timestamp")
+		return
+	}
+	to, err := parseTimeParam(query.Get("to"))
+	if err != nil {
+		writeError(h.log, w, http.StatusBadRequest, "to must be an RFC 3339 timestamp")
+		return
+	}
+	if to == nil {
+		now := time.Now()
+		to = &now
+	}
+
+	hook, ok := h.ownedWebhook(w, r, webhookID)
+	if !ok {
+		return
+	}
+
+	diff, err := h.history.Diff(r.Context(), hook, *from, *to)
+	if errors.Is(err, webhook.ErrHistoryUnavailable) || errors.Is(err, webhook.ErrInvalidHistoryRange) {
+		writeError(h.log, w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		h.log.WithFields(logrus.Fields{
+			"error":      err,
+			"webhook_id": webhookID,
+		}).Error("Error diffing snapshot history")
+		writeError(h.log, w, http.StatusInternalServerError, "failed to diff snapshot history")
+		return
+	}
+
+	writeJSON(h.log, w, http.StatusOK, diff)
+}
+
+// ownedWebhook gets a webhook belonging to the caller, writing the error
+// response when it can't. Other users' webhooks are reported as not found so
+// their IDs can't be probed.
+func (h *handler) ownedWebhook(w http.ResponseWriter, r *http.Request, webhookID string) (models.Webhook, bool) {
+	hook, err := h.webhooks.GetWebhook(r.Context(), webhookID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && hook.UserID != callerID(r)) {
+		writeError(h.log, w, http.StatusNotFound, "webhook not found")
+		return models.Webhook{}, false
+	}
+	if err != nil {
+		h.log.WithFields(logrus.Fields{
+			"error":      err,
+			"webhook_id": webhookID,
+		}).Error("Error getting webhook")
+		writeError(h.log, w, http.StatusInternalServerError, "failed to get webhook")
+		return models.Webhook{}, false
+	}
+
+	return hook, true
+}
+
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func writeJSON(log logrus.FieldLogger, w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Error writing response")
+	}
+}
+
+func writeError(log logrus.FieldLogger, w http.ResponseWriter, status int, message string) {
+	writeJSON(log, w, status, ErrorResponse{Error: message})
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/logging"
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+)
+
+const tokenSecret = "api_secret"
+
+func TestVerifyUserToken(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	token := SignUserToken(tokenSecret, "user.a", now.Add(time.Hour))
+
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+		now    time.Time
+		want   error
+	}{
+		{"valid", tokenSecret, token, now, nil},
+		{"wrong secret", "other_secret", token, now, ErrTokenMismatch},
+		{"other user", tokenSecret, "user.b" + token[len("user.a"):], now, ErrTokenMismatch},
+		{"expired", tokenSecret, token, now.Add(time.Hour), ErrTokenExpired},
+		{"missing", tokenSecret, "", now, ErrTokenMalformed},
+		{"malformed", tokenSecret, "user.abc", now, ErrTokenMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, err := VerifyUserToken(tt.secret, tt.token, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+			if err == nil && userID != "user.a" {
+				t.Errorf("got user %q, want user.a", userID)
+			}
+		})
+	}
+}
+
+func TestRoutesAreScopedToTheCaller(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory(clock.Real())
+	for _, hook := range []models.Webhook{{ID: "hook-a", UserID: "user-a"}, {ID: "hook-b", UserID: "user-b"}} {
+		mem.AddWebhook(hook)
+		generation, err := mem.BeginSnapshot(ctx, hook.ID)
+		if err != nil {
+			t.Fatalf("BeginSnapshot: %v", err)
+		}
+		event := models.EventsToSend{
+			Type:      "page.added",
+			UserID:    hook.UserID,
+			WebhookID: hook.ID,
+			Data:      models.EventData{ObjectID: "page-" + hook.ID, ObjectType: "page", CreatedAt: time.Now().Unix()},
+		}
+		if err := mem.SaveSnapshotBatch(ctx, hook.ID, generation, time.Now(), nil, []models.EventsToSend{event}); err != nil {
+			t.Fatalf("SaveSnapshotBatch: %v", err)
+		}
+	}
+
+	router := NewRouter(mem, mem, nil, tokenSecret, logging.Nop())
+	token := SignUserToken(tokenSecret, "user-a", time.Now().Add(time.Hour))
+
+	get := func(path string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, path := range []string{"/events", "/webhooks/hook-a/events", "/webhooks/hook-a/diff?from=2024-01-01T00:00:00Z"} {
+		if rec := get(path, ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s without a token returned %d, want 401", path, rec.Code)
+		}
+		if rec := get(path, SignUserToken("other_secret", "user-a", time.Now().Add(time.Hour))); rec.Code != http.StatusUnauthorized {
+			t.Errorf("GET %s with a forged token returned %d, want 401", path, rec.Code)
+		}
+	}
+
+	for _, path := range []string{"/events", "/webhooks/hook-a/events", "/events?webhook_id=hook-b"} {
+		rec := get(path, token)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s returned %d: %s", path, rec.Code, rec.Body)
+		}
+		var res models.EventsResponse
+		if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+			t.Fatalf("decoding %s: %v", path, err)
+		}
+		for _, event := range res.Events {
+			if event.UserID != "user-a" {
+				t.Errorf("GET %s returned %+v, want only user-a's events", path, event)
+			}
+		}
+	}
+
+	for _, path := range []string{"/webhooks/hook-b/events", "/webhooks/hook-b/diff?from=2024-01-01T00:00:00Z"} {
+		if rec := get(path, token); rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s for another user's webhook returned %d, want 404", path, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeTicker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFake(start)
+	ticker := clk.NewTicker(time.Second)
+
+	clk.Advance(500 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatalf("ticked before the interval passed")
+	default:
+	}
+
+	clk.Advance(3 * time.Second)
+	select {
+	case got := <-ticker.C():
+		if want := start.Add(time.Second); !got.Equal(want) {
+			t.Errorf("got tick at %s, want %s", got, want)
+		}
+	default:
+		t.Fatalf("didn't tick")
+	}
+	// Later ticks were dropped, like time.Ticker does for a slow receiver.
+	select {
+	case <-ticker.C():
+		t.Errorf("got a second buffered tick")
+	default:
+	}
+
+	if got, want := clk.Now(), start.Add(3500*time.Millisecond); !got.Equal(want) {
+		t.Errorf("got now %s, want %s", got, want)
+	}
+
+	ticker.Stop()
+	clk.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Errorf("stopped ticker ticked")
+	default:
+	}
+}
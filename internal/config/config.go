@@ -0,0 +1,252 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/logging"
+	"github.com/gavsidhu/notion-hooks/internal/notion"
+	"github.com/sirupsen/logrus"
+)
+
+type Config struct {
+	DatabaseURL string `yaml:"database_url"`
+	RabbitMQURL string `yaml:"rabbitmq_url"`
+
+	API       APIConfig       `yaml:"api"`
+	Admin     AdminConfig     `yaml:"admin"`
+	Scheduler SchedulerConfig `yaml:"scheduler"`
+	Workers   WorkersConfig   `yaml:"workers"`
+	Notion    NotionConfig    `yaml:"notion"`
+	Comments  CommentsConfig  `yaml:"comments"`
+	History   HistoryConfig   `yaml:"history"`
+	Log       LogConfig       `yaml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+
+	// ShutdownTimeout is how long in-flight handlers get to finish after a
+	// shutdown signal before their context is cancelled.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type APIConfig struct {
+	Addr string `yaml:"addr"`
+	// TokenSecret signs the user tokens the events API requires.
+	TokenSecret string `yaml:"token_secret"`
+}
+
+// AdminConfig is the server for probes, metrics and the /admin routes. It
+// listens on loopback by default; listening anywhere else requires a token.
+type AdminConfig struct {
+	Addr  string `yaml:"addr"`
+	Token string `yaml:"token"`
+}
+
+type SchedulerConfig struct {
+	Tick             time.Duration `yaml:"tick"`
+	MaxPollsPerUser  int           `yaml:"max_polls_per_user"`
+	MaxClaimsPerTick int           `yaml:"max_claims_per_tick"`
+	ClaimTimeout     time.Duration `yaml:"claim_timeout"`
+}
+
+// WorkersConfig is the number of consumers started for each queue.
+type WorkersConfig struct {
+	Processing  int `yaml:"processing"`
+	Events      int `yaml:"events"`
+	InitialPoll int `yaml:"initial_poll"`
+}
+
+type NotionConfig struct {
+	BaseURL string `yaml:"base_url"`
+	Version string `yaml:"version"`
+}
+
+// CommentsConfig bounds the comment checks of database polls, which take a
+// request per page.
+type CommentsConfig struct {
+	Interval        time.Duration `yaml:"interval"`
+	MaxPagesPerPoll int           `yaml:"max_pages_per_poll"`
+}
+
+// HistoryConfig controls the versioned snapshot history behind point in
+// time diffs.
+type HistoryConfig struct {
+	// Retention is how long a replaced snapshot version is kept. Zero keeps
+	// versions forever.
+	Retention time.Duration `yaml:"retention"`
+}
+
+type LogConfig struct {
+	Output string `yaml:"output"`
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+	File   string `yaml:"file"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter"`
+}
+
+func Default() Config {
+	return Config{
+		API: APIConfig{
+			Addr: ":8080",
+		},
+		Admin: AdminConfig{
+			Addr: "127.0.0.1:8081",
+		},
+		Scheduler: SchedulerConfig{
+			Tick:             5 * time.Second,
+			MaxPollsPerUser:  2,
+			MaxClaimsPerTick: 100,
+			ClaimTimeout:     30 * time.Minute,
+		},
+		Workers: WorkersConfig{
+			Processing:  1,
+			Events:      1,
+			InitialPoll: 1,
+		},
+		Notion: NotionConfig{
+			BaseURL: notion.DefaultBaseURL,
+			Version: notion.DefaultVersion,
+		},
+		Comments: CommentsConfig{
+			Interval:        15 * time.Minute,
+			MaxPagesPerPoll: 100,
+		},
+		History: HistoryConfig{
+			Retention: 30 * 24 * time.Hour,
+		},
+		Log: LogConfig{
+			Output: logging.OutputStdout,
+			Level:  "info",
+			Format: logging.FormatJSON,
+			File:   logging.DefaultFile,
+		},
+		Tracing: TracingConfig{
+			Exporter: "none",
+		},
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// Validate reports every invalid setting at once. The RabbitMQ URL is only
+// checked by the commands that need a broker.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.DatabaseURL == "" {
+		errs = append(errs, errors.New("database_url is required"))
+	}
+	if c.Scheduler.Tick <= 0 {
+		errs = append(errs, errors.New("scheduler.tick must be positive"))
+	}
+	if c.Scheduler.MaxPollsPerUser < 1 {
+		errs = append(errs, errors.New("scheduler.max_polls_per_user must be at least 1"))
+	}
+	if c.Scheduler.MaxClaimsPerTick < 1 {
+		errs = append(errs, errors.New("scheduler.max_claims_per_tick must be at least 1"))
+	}
+	if c.Scheduler.ClaimTimeout <= 0 {
+		errs = append(errs, errors.New("scheduler.claim_timeout must be positive"))
+	}
+	if c.Admin.Token == "" && !isLoopback(c.Admin.Addr) {
+		errs = append(errs, fmt.Errorf("admin.token is required when admin.addr %q is not a loopback address", c.Admin.Addr))
+	}
+	if c.Workers.Processing < 0 || c.Workers.Events < 0 || c.Workers.InitialPoll < 0 {
+		errs = append(errs, errors.New("workers counts must not be negative"))
+	}
+	if u, err := url.Parse(c.Notion.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("notion.base_url %q is not an absolute URL", c.Notion.BaseURL))
+	}
+	if c.Notion.Version == "" {
+		errs = append(errs, errors.New("notion.version is required"))
+	}
+	if c.Comments.Interval <= 0 {
+		errs = append(errs, errors.New("comments.interval must be positive"))
+	}
+	if c.Comments.MaxPagesPerPoll < 1 {
+		errs = append(errs, errors.New("comments.max_pages_per_poll must be at least 1"))
+	}
+	if c.History.Retention < 0 {
+		errs = append(errs, errors.New("history.retention must not be negative"))
+	}
+	switch c.Log.Output {
+	case logging.OutputStdout, logging.OutputFile, logging.OutputBoth:
+	default:
+		errs = append(errs, fmt.Errorf("log.output must be one of stdout, file or both, got %q", c.Log.Output))
+	}
+	if _, err := logrus.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %w", err))
+	}
+	switch c.Log.Format {
+	case logging.FormatJSON, logging.FormatText:
+	default:
+		errs = append(errs, fmt.Errorf("log.format must be json or text, got %q", c.Log.Format))
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter must be one of none, stdout or otlp, got %q", c.Tracing.Exporter))
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// isLoopback reports whether addr only accepts connections from this host.
+// An empty host listens on every interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+const redacted = "REDACTED"
+
+// LoggingOptions returns the options for building the application logger.
+func (c Config) LoggingOptions() logging.Options {
+	return logging.Options{
+		Output: c.Log.Output,
+		Level:  c.Log.Level,
+		Format: c.Log.Format,
+		File:   c.Log.File,
+	}
+}
+
+// Redacted returns a copy of c that is safe to print, with secrets and URL
+// credentials replaced.
+func (c Config) Redacted() Config {
+	c.DatabaseURL = redactURL(c.DatabaseURL)
+	c.RabbitMQURL = redactURL(c.RabbitMQURL)
+	if c.API.TokenSecret != "" {
+		c.API.TokenSecret = redacted
+	}
+	if c.Admin.Token != "" {
+		c.Admin.Token = redacted
+	}
+
+	return c
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redacted
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+
+	return u.String()
+}
@@ -0,0 +1,29 @@
+package config
+
+import "testing"
+
+func TestValidateAdminToken(t *testing.T) {
+	tests := []struct {
+		addr  string
+		token string
+		valid bool
+	}{
+		{"127.0.0.1:8081", "", true},
+		{"localhost:8081", "", true},
+		{"[::1]:8081", "", true},
+		{":8081", "", false},
+		{"0.0.0.0:8081", "", false},
+		{"10.0.0.5:8081", "", false},
+		{":8081", "secret", true},
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.DatabaseURL = "postgres://localhost/notion-hooks"
+		cfg.Admin.Addr = tt.addr
+		cfg.Admin.Token = tt.token
+
+		if err := cfg.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate() with admin.addr %q and token %q = %v, want valid %t", tt.addr, tt.token, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting binds a Config field to the environment variable and command line
+// flag that can set it. Secrets have no flag so they never show up in the
+// process list.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+func settings(c *Config) []setting {
+	return []setting{
+		{"DATABASE_URL", "", "Postgres connection string", (*stringValue)(&c.DatabaseURL)},
+		{"RABBITMQ_CONNECTION_URL", "", "RabbitMQ connection string", (*stringValue)(&c.RabbitMQURL)},
+		{"API_ADDR", "api-addr", "address of the events API server", (*stringValue)(&c.API.Addr)},
+		{"API_TOKEN_SECRET", "", "secret that signs the user tokens required by the events API; all runs without the API when unset", (*stringValue)(&c.API.TokenSecret)},
+		{"ADMIN_ADDR", "admin-addr", "address of the health, metrics and admin server", (*stringValue)(&c.Admin.Addr)},
+		{"ADMIN_TOKEN", "", "bearer token required on /admin routes; mandatory unless the admin server listens on loopback", (*stringValue)(&c.Admin.Token)},
+		{"SCHEDULER_TICK", "scheduler-tick", "how often the scheduler looks for due webhooks", (*durationValue)(&c.Scheduler.Tick)},
+		{"MAX_CONCURRENT_POLLS_PER_USER", "max-polls-per-user", "maximum webhooks polled at once for a single user", (*intValue)(&c.Scheduler.MaxPollsPerUser)},
+		{"MAX_CLAIMS_PER_TICK", "max-claims-per-tick", "maximum webhooks queued by a single scheduler tick", (*intValue)(&c.Scheduler.MaxClaimsPerTick)},
+		{"POLL_CLAIM_TIMEOUT", "claim-timeout", "how long a webhook may stay processing before it is polled again", (*durationValue)(&c.Scheduler.ClaimTimeout)},
+		{"PROCESSING_WORKERS", "processing-workers", "number of consumers on proccessingQueue", (*intValue)(&c.Workers.Processing)},
+		{"EVENTS_WORKERS", "events-workers", "number of consumers on eventsQueue", (*intValue)(&c.Workers.Events)},
+		{"INITIAL_POLL_WORKERS", "initial-poll-workers", "number of consumers on initalPollQueue", (*intValue)(&c.Workers.InitialPoll)},
+		{"NOTION_BASE_URL", "notion-base-url", "base URL of the Notion API", (*stringValue)(&c.Notion.BaseURL)},
+		{"NOTION_VERSION", "notion-version", "Notion-Version header sent with every request", (*stringValue)(&c.Notion.Version)},
+		{"COMMENT_CHECK_INTERVAL", "comment-check-interval", "how long a page's comments go unchecked before a poll checks them again", (*durationValue)(&c.Comments.Interval)},
+		{"MAX_COMMENT_PAGES_PER_POLL", "max-comment-pages-per-poll", "maximum pages whose comments a single poll checks", (*intValue)(&c.Comments.MaxPagesPerPoll)},
+		{"SNAPSHOT_HISTORY_RETENTION", "history-retention", "how long replaced snapshot versions are kept, 0 for forever", (*durationValue)(&c.History.Retention)},
+		{"LOG_OUTPUT", "log-output", "where logs are written: stdout, file or both", (*stringValue)(&c.Log.Output)},
+		{"LOG_LEVEL", "log-level", "minimum level logged, e.g. debug or info", (*stringValue)(&c.Log.Level)},
+		{"LOG_FORMAT", "log-format", "log format: json or text", (*stringValue)(&c.Log.Format)},
+		{"LOG_FILE", "log-file", "path of the log file when log output includes file", (*stringValue)(&c.Log.File)},
+		{"TRACING_EXPORTER", "tracing-exporter", "trace exporter: none, stdout or otlp", (*stringValue)(&c.Tracing.Exporter)},
+		{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long in-flight work gets to finish on shutdown", (*durationValue)(&c.ShutdownTimeout)},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence, the
+// defaults, the YAML file named by -config or CONFIG_FILE, environment
+// variables and the flags in args. The settings flags are registered on fs
+// so callers can add their own flags before calling Load. The result is not
+// validated.
+func Load(fs *flag.FlagSet, args []string) (Config, error) {
+	defaults := Default()
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path of a YAML config file")
+	for _, s := range settings(&defaults) {
+		if s.flag != "" {
+			fs.Var(s.value, s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	cfg := Default()
+
+	if *path != "" {
+		if err := loadFile(*path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var errs []error
+	bound := settings(&cfg)
+	for _, s := range bound {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := s.value.Set(v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+
+	// Flags were parsed into the defaults above; replay the ones that were
+	// given so they win over the file and the environment.
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range bound {
+			if s.flag == f.Name {
+				s.value.Set(f.Value.String())
+			}
+		}
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Print writes c as YAML with secrets redacted.
+func (c Config) Print(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.Redacted()); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error {
+	*v = stringValue(s)
+	return nil
+}
+
+func (v *stringValue) String() string { return string(*v) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not an integer", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+
+func (v *durationValue) String() string { return time.Duration(*v).String() }
@@ -1,61 +1,297 @@
 package config
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
 )
 
+// Queues are declared on every (re)connect.
+var Queues = []string{"proccessingQueue", "eventsQueue", "initalPollQueue"}
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+var (
+	ErrConnectionClosed = errors.New("rabbitmq connection closed")
+	ErrPublishNacked    = errors.New("rabbitmq did not confirm the publish")
+)
+
+// RabbitMQConnection owns the broker connection, a shared publisher channel
+// and a publisher channel in confirm mode. It watches all of them for
+// closure and transparently reconnects with backoff, re-declaring the queue
+// topology each time.
 type RabbitMQConnection struct {
-	Conn *amqp091.Connection
-	Ch   *amqp091.Channel
+	url string
+	log logrus.FieldLogger
+
+	mu          sync.RWMutex
+	conn        *amqp091.Connection
+	ch          *amqp091.Channel
+	confirmCh   *amqp091.Channel
+	connected   bool
+	reconnected chan struct{}
+
+	// confirmMu serialises confirmed publishes so each waits only on its
+	// own confirmation.
+	confirmMu sync.Mutex
+
+	closeOnce sync.Once
+	closing   chan struct{}
 }
 
-func NewRabbitMQConnection(url string) (*RabbitMQConnection, error) {
-	conn, err := amqp091.Dial(url)
-	if err != nil {
+func NewRabbitMQConnection(url string, log logrus.FieldLogger) (*RabbitMQConnection, error) {
+	rmq := &RabbitMQConnection{
+		url:         url,
+		log:         log,
+		reconnected: make(chan struct{}),
+		closing:     make(chan struct{}),
+	}
+
+	if err := rmq.connect(); err != nil {
 		return nil, err
 	}
 
+	go rmq.watch()
+
+	return rmq, nil
+}
+
+func (rmq *RabbitMQConnection) connect() error {
+	conn, err := amqp091.Dial(rmq.url)
+	if err != nil {
+		return err
+	}
+
 	ch, err := conn.Channel()
 	if err != nil {
-		fmt.Println("Error in channel creation")
 		conn.Close()
-		return nil, err
+		return err
+	}
+
+	for _, queue := range Queues {
+		_, err = ch.QueueDeclare(queue, true, false, false, false, nil)
+		if err != nil {
+			ch.Close()
+			conn.Close()
+			return err
+		}
 	}
 
-	_, err = ch.QueueDeclare("proccessingQueue", true, false, false, false, nil)
+	confirmCh, err := conn.Channel()
 	if err != nil {
 		ch.Close()
 		conn.Close()
-		return nil, err
+		return err
 	}
 
-	_, err = ch.QueueDeclare("eventsQueue", true, false, false, false, nil)
+	err = confirmCh.Confirm(false)
 	if err != nil {
+		confirmCh.Close()
 		ch.Close()
 		conn.Close()
-		return nil, err
+		return err
+	}
+
+	rmq.mu.Lock()
+	rmq.conn = conn
+	rmq.ch = ch
+	rmq.confirmCh = confirmCh
+	rmq.connected = true
+	close(rmq.reconnected)
+	rmq.reconnected = make(chan struct{})
+	rmq.mu.Unlock()
+
+	return nil
+}
+
+// watch blocks until the connection or one of the publisher channels closes
+// and then reconnects, until Close is called.
+func (rmq *RabbitMQConnection) watch() {
+	for {
+		rmq.mu.RLock()
+		connClosed := rmq.conn.NotifyClose(make(chan *amqp091.Error, 1))
+		chClosed := rmq.ch.NotifyClose(make(chan *amqp091.Error, 1))
+		confirmClosed := rmq.confirmCh.NotifyClose(make(chan *amqp091.Error, 1))
+		rmq.mu.RUnlock()
+
+		var reason *amqp091.Error
+		select {
+		case <-rmq.closing:
+			return
+		case reason = <-connClosed:
+		case reason = <-chClosed:
+		case reason = <-confirmClosed:
+		}
+
+		rmq.mu.Lock()
+		rmq.connected = false
+		rmq.confirmCh.Close()
+		rmq.ch.Close()
+		rmq.conn.Close()
+		rmq.mu.Unlock()
+
+		rmq.log.WithFields(logrus.Fields{
+			"error": reason,
+		}).Error("Lost connection to RabbitMQ, reconnecting")
+
+		if !rmq.reconnect() {
+			return
+		}
+
+		rmq.log.Info("Reconnected to RabbitMQ")
+	}
+}
+
+// reconnect retries connect with exponential backoff. It returns false if
+// the connection was closed before reconnecting succeeded.
+func (rmq *RabbitMQConnection) reconnect() bool {
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-rmq.closing:
+			return false
+		case <-time.After(delay):
+		}
+
+		err := rmq.connect()
+		if err == nil {
+			return true
+		}
+
+		rmq.log.WithFields(logrus.Fields{
+			"error": err,
+			"retry": delay.String(),
+		}).Warn("Failed to reconnect to RabbitMQ")
+
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
 	}
+}
+
+// IsConnected reports whether the broker connection is currently up.
+func (rmq *RabbitMQConnection) IsConnected() bool {
+	rmq.mu.RLock()
+	defer rmq.mu.RUnlock()
+
+	return rmq.connected
+}
+
+// WaitForConnection blocks until the broker connection is up, ctx is done
+// or the connection is closed.
+func (rmq *RabbitMQConnection) WaitForConnection(ctx context.Context) error {
+	for {
+		rmq.mu.RLock()
+		connected, reconnected := rmq.connected, rmq.reconnected
+		rmq.mu.RUnlock()
+
+		if connected {
+			return nil
+		}
+
+		select {
+		case <-reconnected:
+		case <-rmq.closing:
+			return ErrConnectionClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Channel opens a new channel on the current connection.
+func (rmq *RabbitMQConnection) Channel() (*amqp091.Channel, error) {
+	rmq.mu.RLock()
+	defer rmq.mu.RUnlock()
 
-	_, err = ch.QueueDeclare("initalPollQueue", true, false, false, false, nil)
+	if !rmq.connected {
+		return nil, amqp091.ErrClosed
+	}
+
+	return rmq.conn.Channel()
+}
+
+// QueueDepth returns the number of messages ready for delivery on queue and
+// the number of consumers attached to it.
+func (rmq *RabbitMQConnection) QueueDepth(queue string) (int, int, error) {
+	ch, err := rmq.Channel()
 	if err != nil {
-		ch.Close()
-		conn.Close()
-		return nil, err
+		return 0, 0, err
+	}
+	defer ch.Close()
+
+	q, err := ch.QueueDeclarePassive(queue, true, false, false, false, nil)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	return &RabbitMQConnection{
-		Conn: conn,
-		Ch:   ch,
-	}, nil
+	return q.Messages, q.Consumers, nil
 }
 
-func (rmq *RabbitMQConnection) Close() {
-	if rmq.Ch != nil {
-		rmq.Ch.Close()
+// Publish sends msg to queue through the shared publisher channel.
+func (rmq *RabbitMQConnection) Publish(ctx context.Context, queue string, msg amqp091.Publishing) error {
+	rmq.mu.RLock()
+	defer rmq.mu.RUnlock()
+
+	if !rmq.connected {
+		return amqp091.ErrClosed
+	}
+
+	return rmq.ch.PublishWithContext(ctx, "", queue, false, false, msg)
+}
+
+// PublishWithConfirm sends msg to queue and waits until the broker confirms
+// it has taken responsibility for the message.
+func (rmq *RabbitMQConnection) PublishWithConfirm(ctx context.Context, queue string, msg amqp091.Publishing) error {
+	rmq.confirmMu.Lock()
+	defer rmq.confirmMu.Unlock()
+
+	rmq.mu.RLock()
+	if !rmq.connected {
+		rmq.mu.RUnlock()
+		return amqp091.ErrClosed
 	}
-	if rmq.Conn != nil {
-		rmq.Conn.Close()
+	confirmation, err := rmq.confirmCh.PublishWithDeferredConfirmWithContext(ctx, "", queue, false, false, msg)
+	rmq.mu.RUnlock()
+	if err != nil {
+		return err
 	}
+
+	acked, err := confirmation.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return ErrPublishNacked
+	}
+
+	return nil
+}
+
+func (rmq *RabbitMQConnection) Close() {
+	rmq.closeOnce.Do(func() {
+		close(rmq.closing)
+
+		rmq.mu.Lock()
+		defer rmq.mu.Unlock()
+
+		rmq.connected = false
+		if rmq.confirmCh != nil {
+			rmq.confirmCh.Close()
+		}
+		if rmq.ch != nil {
+			rmq.ch.Close()
+		}
+		if rmq.conn != nil {
+			rmq.conn.Close()
+		}
+	})
 }
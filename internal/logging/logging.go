@@ -1,21 +1,92 @@
 package logging
 
 import (
+	"fmt"
+	"io"
+	"os"
+
 	"github.com/sirupsen/logrus"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
-var Logger *logrus.Logger
+const DefaultFile = "./logs/notion-hooks.log"
+
+const (
+	OutputStdout = "stdout"
+	OutputFile   = "file"
+	OutputBoth   = "both"
+
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type Options struct {
+	// Output is stdout, file or both.
+	Output string
+	// Level is any level logrus can parse, e.g. debug or info.
+	Level  string
+	Format string
+	// File is the path of the rotated log file when Output includes it.
+	File string
+}
+
+// New builds a logger from opts. Every logger it returns redacts secrets
+// from its fields and messages.
+func New(opts Options) (*logrus.Logger, error) {
+	logger := logrus.New()
+
+	level, err := logrus.ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	logger.SetLevel(level)
 
-func init() {
-	Logger = logrus.New()
-	Logger.Formatter = &logrus.JSONFormatter{}
+	switch opts.Format {
+	case FormatJSON:
+		logger.Formatter = &logrus.JSONFormatter{}
+	case FormatText:
+		logger.Formatter = &logrus.TextFormatter{FullTimestamp: true}
+	default:
+		return nil, fmt.Errorf("unknown log format %q", opts.Format)
+	}
+
+	file := func() io.Writer {
+		return &lumberjack.Logger{
+			Filename:   opts.File,
+			MaxSize:    10,
+			MaxBackups: 3,
+			MaxAge:     28,
+			Compress:   true,
+		}
+	}
+
+	switch opts.Output {
+	case OutputStdout:
+		logger.SetOutput(os.Stdout)
+	case OutputFile:
+		logger.SetOutput(file())
+	case OutputBoth:
+		logger.SetOutput(io.MultiWriter(os.Stdout, file()))
+	default:
+		return nil, fmt.Errorf("unknown log output %q", opts.Output)
+	}
+
+	logger.AddHook(redactHook{})
+
+	return logger, nil
+}
+
+// Nop returns a logger that discards everything, for components that were
+// not given one.
+func Nop() logrus.FieldLogger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return logger
+}
 
-	Logger.SetOutput(&lumberjack.Logger{
-		Filename:   "./logs/notion-hooks.log",
-		MaxSize:    10,
-		MaxBackups: 3,
-		MaxAge:     28,
-		Compress:   true,
-	})
+// Component returns a logger that tags every line with the component that
+// wrote it.
+func Component(logger logrus.FieldLogger, name string) logrus.FieldLogger {
+	return logger.WithField("component", name)
 }
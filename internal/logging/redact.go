@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const redacted = "REDACTED"
+
+// sensitiveKeys matches field names whose values are always secret.
+var sensitiveKeys = regexp.MustCompile(`(?i)(token|secret|password|authorization|api_?key)`)
+
+// secretPatterns match secrets embedded in otherwise harmless values, such
+// as an access token in an error message or credentials in a URL.
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)(bearer\s+)[^\s"']+`), "${1}" + redacted},
+	{regexp.MustCompile(`\b(secret|ntn)_[A-Za-z0-9]+`), "${1}_" + redacted},
+	{regexp.MustCompile(`(://[^/:@\s]+:)[^/@\s]+@`), "${1}" + redacted + "@"},
+}
+
+// redactHook scrubs secrets from every entry before it is formatted.
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = redactString(entry.Message)
+
+	// Entries share their Data map with the logger they were derived from,
+	// so build a new one rather than editing it in place.
+	data := make(logrus.Fields, len(entry.Data))
+	for key, value := range entry.Data {
+		data[key] = redactValue(key, value)
+	}
+	entry.Data = data
+
+	return nil
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	if sensitiveKeys.MatchString(key) {
+		return redacted
+	}
+
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	case fmt.Stringer:
+		s = v.String()
+	default:
+		return value
+	}
+
+	if clean := redactString(s); clean != s {
+		return clean
+	}
+
+	return value
+}
+
+func redactString(s string) string {
+	if !strings.ContainsAny(s, "_:") && !strings.Contains(strings.ToLower(s), "bearer") {
+		return s
+	}
+	for _, p := range secretPatterns {
+		s = p.pattern.ReplaceAllString(s, p.replacement)
+	}
+
+	return s
+}
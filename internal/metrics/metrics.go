@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Label values are restricted to small fixed sets (object types, outcomes,
+// endpoint names, status codes and classes, event types and queue names) so
+// cardinality stays bounded. Webhook, user and page IDs are never labels.
+
+var (
+	PollsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "notion_hooks",
+		Name:      "polls_total",
+		Help:      "Webhook polls by Notion object type and outcome.",
+	}, []string{"object_type", "outcome"})
+
+	PagesScanned = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "notion_hooks",
+		Name:      "poll_pages_scanned",
+		Help:      "Number of Notion pages scanned per database poll.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 10),
+	})
+
+	EventsGenerated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "notion_hooks",
+		Name:      "events_generated_total",
+		Help:      "Events generated by diffing snapshots, by event type.",
+	}, []string{"type"})
+
+	NotionRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "notion_hooks",
+		Name:      "notion_request_duration_seconds",
+		Help:      "Latency of Notion API requests by endpoint and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "status"})
+
+	DeliveryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "notion_hooks",
+		Name:      "delivery_duration_seconds",
+		Help:      "Latency of event deliveries to webhook endpoints by status class.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"status_class"})
+
+	ConsumerLag = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "notion_hooks",
+		Name:      "consumer_lag_seconds",
+		Help:      "Time between a message being published and consumed, by queue.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 4, 10),
+	}, []string{"queue"})
+
+	SchedulerBacklog = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "notion_hooks",
+		Name:      "scheduler_backlog",
+		Help:      "Active webhooks past their next poll time that are not yet claimed.",
+	})
+)
+
+// StatusClass maps an HTTP status code to 2xx, 3xx, 4xx or 5xx, and a
+// missing response to "error".
+func StatusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "error"
+	}
+
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// StatusCode formats an HTTP status code as a label, using "error" when no
+// response was received.
+func StatusCode(code int) string {
+	if code == 0 {
+		return "error"
+	}
+
+	return strconv.Itoa(code)
+}
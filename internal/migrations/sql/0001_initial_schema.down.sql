@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS notion_database_details;
+DROP TABLE IF EXISTS notion_database_page_ids;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS notion_integrations;
+DROP FUNCTION IF EXISTS set_updated_at();
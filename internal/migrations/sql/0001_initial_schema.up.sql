@@ -0,0 +1,64 @@
+-- Tables that predate versioned migrations. IF NOT EXISTS lets databases
+-- that were created by hand adopt the migrations without losing data.
+
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TABLE IF NOT EXISTS notion_integrations (
+    id           SERIAL PRIMARY KEY,
+    user_id      TEXT NOT NULL UNIQUE,
+    access_token TEXT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name               TEXT NOT NULL DEFAULT '',
+    description        TEXT NOT NULL DEFAULT '',
+    user_id            TEXT NOT NULL,
+    url                TEXT NOT NULL,
+    secret             TEXT NOT NULL DEFAULT '',
+    events             TEXT[] NOT NULL DEFAULT '{}',
+    is_active          BOOLEAN NOT NULL DEFAULT true,
+    polling_interval   INTEGER NOT NULL DEFAULT 5,
+    last_polled        TIMESTAMPTZ,
+    status             TEXT NOT NULL DEFAULT 'idle',
+    notion_object_id   TEXT NOT NULL,
+    notion_object_type TEXT NOT NULL,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
+
+CREATE TABLE IF NOT EXISTS notion_database_page_ids (
+    id         SERIAL PRIMARY KEY,
+    webhook_id UUID NOT NULL UNIQUE REFERENCES webhooks (id) ON DELETE CASCADE,
+    user_id    TEXT NOT NULL,
+    page_ids   TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS notion_database_details (
+    id                    SERIAL PRIMARY KEY,
+    webhook_id            UUID NOT NULL UNIQUE REFERENCES webhooks (id) ON DELETE CASCADE,
+    user_id               TEXT NOT NULL,
+    database_page_details JSONB NOT NULL,
+    created_at            TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE TRIGGER notion_integrations_updated_at BEFORE UPDATE ON notion_integrations
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE OR REPLACE TRIGGER webhooks_updated_at BEFORE UPDATE ON webhooks
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE OR REPLACE TRIGGER notion_database_page_ids_updated_at BEFORE UPDATE ON notion_database_page_ids
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE OR REPLACE TRIGGER notion_database_details_updated_at BEFORE UPDATE ON notion_database_details
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS webhooks_due_idx;
+
+ALTER TABLE webhooks
+    DROP COLUMN IF EXISTS schedule,
+    DROP COLUMN IF EXISTS timezone,
+    DROP COLUMN IF EXISTS poll_window,
+    DROP COLUMN IF EXISTS plan,
+    DROP COLUMN IF EXISTS next_poll_at,
+    DROP COLUMN IF EXISTS adaptive_polling,
+    DROP COLUMN IF EXISTS adaptive_max_interval,
+    DROP COLUMN IF EXISTS effective_interval_seconds;
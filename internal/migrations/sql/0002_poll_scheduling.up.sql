@@ -0,0 +1,14 @@
+-- Cron schedules, poll windows, plans and adaptive polling.
+
+ALTER TABLE webhooks
+    ADD COLUMN IF NOT EXISTS schedule                   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS timezone                   TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS poll_window                TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS plan                       TEXT NOT NULL DEFAULT 'free',
+    ADD COLUMN IF NOT EXISTS next_poll_at               TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS adaptive_polling           BOOLEAN NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS adaptive_max_interval      INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS effective_interval_seconds INTEGER NOT NULL DEFAULT 0;
+
+-- The scheduler only looks at idle webhooks that are due.
+CREATE INDEX IF NOT EXISTS webhooks_due_idx ON webhooks (next_poll_at) WHERE status = 'idle' AND is_active;
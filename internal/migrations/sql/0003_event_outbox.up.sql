@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS event_outbox (
+    id         UUID PRIMARY KEY,
+    webhook_id UUID NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    queue      TEXT NOT NULL,
+    payload    JSONB NOT NULL,
+    headers    JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS event_outbox_unsent_idx ON event_outbox (created_at) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS event_outbox_sent_at_idx ON event_outbox (sent_at) WHERE sent_at IS NOT NULL;
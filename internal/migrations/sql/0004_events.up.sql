@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS events (
+    id                 UUID PRIMARY KEY,
+    webhook_id         UUID NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    user_id            TEXT NOT NULL,
+    type               TEXT NOT NULL,
+    object_id          TEXT NOT NULL,
+    data               JSONB NOT NULL,
+    status             TEXT NOT NULL DEFAULT 'pending',
+    attempts           INTEGER NOT NULL DEFAULT 0,
+    last_response_code INTEGER,
+    last_error         TEXT,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at       TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS events_webhook_id_created_at_idx ON events (webhook_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS events_created_at_idx ON events (created_at DESC);
+CREATE INDEX IF NOT EXISTS events_object_id_idx ON events (object_id);
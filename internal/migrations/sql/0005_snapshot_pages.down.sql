@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS notion_database_page_ids (
+    id         SERIAL PRIMARY KEY,
+    webhook_id UUID NOT NULL UNIQUE REFERENCES webhooks (id) ON DELETE CASCADE,
+    user_id    TEXT NOT NULL,
+    page_ids   TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS notion_database_details (
+    id                    SERIAL PRIMARY KEY,
+    webhook_id            UUID NOT NULL UNIQUE REFERENCES webhooks (id) ON DELETE CASCADE,
+    user_id               TEXT NOT NULL,
+    database_page_details JSONB NOT NULL,
+    created_at            TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE OR REPLACE TRIGGER notion_database_page_ids_updated_at BEFORE UPDATE ON notion_database_page_ids
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE OR REPLACE TRIGGER notion_database_details_updated_at BEFORE UPDATE ON notion_database_details
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+-- Only the fields the old diff reads can be restored.
+INSERT INTO notion_database_page_ids (webhook_id, user_id, page_ids)
+SELECT w.id, w.user_id, array_agg(s.page_id)
+FROM webhooks w
+JOIN snapshot_pages s ON s.webhook_id = w.id
+GROUP BY w.id, w.user_id;
+
+INSERT INTO notion_database_details (webhook_id, user_id, database_page_details)
+SELECT w.id, w.user_id, jsonb_build_object(
+    'object', 'list',
+    'has_more', false,
+    'results', jsonb_agg(jsonb_build_object(
+        'object', 'page',
+        'id', s.page_id,
+        'last_edited_time', to_char(s.last_edited_time AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')
+    ))
+)
+FROM webhooks w
+JOIN snapshot_pages s ON s.webhook_id = w.id
+GROUP BY w.id, w.user_id;
+
+DROP TABLE IF EXISTS snapshot_pages;
@@ -0,0 +1,27 @@
+-- One row per page replaces the whole-database snapshot, so a poll only
+-- writes the pages that changed.
+CREATE TABLE IF NOT EXISTS snapshot_pages (
+    webhook_id       UUID NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    page_id          TEXT NOT NULL,
+    last_edited_time TIMESTAMPTZ NOT NULL,
+    -- SHA-256 of the page's properties. Empty for rows carried over from
+    -- the old snapshot, which get their hash on the next poll.
+    property_hash    TEXT NOT NULL DEFAULT '',
+    -- The page's properties, only kept when a feature needs them.
+    properties       JSONB,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (webhook_id, page_id)
+);
+
+CREATE OR REPLACE TRIGGER snapshot_pages_updated_at BEFORE UPDATE ON snapshot_pages
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+INSERT INTO snapshot_pages (webhook_id, page_id, last_edited_time)
+SELECT d.webhook_id, page->>'id', (page->>'last_edited_time')::timestamptz
+FROM notion_database_details d,
+    jsonb_array_elements(d.database_page_details->'results') AS page
+ON CONFLICT DO NOTHING;
+
+DROP TABLE notion_database_page_ids;
+DROP TABLE notion_database_details;
@@ -0,0 +1,24 @@
+-- Every state a page's snapshot row has been in, so a webhook's snapshot can
+-- be rebuilt as of any time within the retention period. A version is
+-- current from valid_from until valid_to, which is NULL for the row that
+-- matches snapshot_pages.
+CREATE TABLE IF NOT EXISTS snapshot_page_versions (
+    webhook_id       UUID NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    page_id          TEXT NOT NULL,
+    last_edited_time TIMESTAMPTZ NOT NULL,
+    property_hash    TEXT NOT NULL DEFAULT '',
+    valid_from       TIMESTAMPTZ NOT NULL,
+    valid_to         TIMESTAMPTZ,
+    PRIMARY KEY (webhook_id, page_id, valid_from)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS snapshot_page_versions_current_idx
+    ON snapshot_page_versions (webhook_id, page_id) WHERE valid_to IS NULL;
+CREATE INDEX IF NOT EXISTS snapshot_page_versions_valid_to_idx
+    ON snapshot_page_versions (valid_to) WHERE valid_to IS NOT NULL;
+
+-- History starts now for existing snapshots.
+INSERT INTO snapshot_page_versions (webhook_id, page_id, last_edited_time, property_hash, valid_from)
+SELECT webhook_id, page_id, last_edited_time, property_hash, NOW()
+FROM snapshot_pages
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+ALTER TABLE webhooks
+    DROP COLUMN IF EXISTS query_filter,
+    DROP COLUMN IF EXISTS query_sorts;
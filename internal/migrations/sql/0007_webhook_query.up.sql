@@ -0,0 +1,5 @@
+-- Optional Notion filter and sorts applied to a webhook's database query.
+
+ALTER TABLE webhooks
+    ADD COLUMN IF NOT EXISTS query_filter JSONB,
+    ADD COLUMN IF NOT EXISTS query_sorts  JSONB;
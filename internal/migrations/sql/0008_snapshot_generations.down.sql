@@ -0,0 +1,8 @@
+CREATE OR REPLACE TRIGGER snapshot_pages_updated_at BEFORE UPDATE ON snapshot_pages
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+ALTER TABLE snapshot_pages
+    DROP COLUMN IF EXISTS seen_generation;
+
+ALTER TABLE webhooks
+    DROP COLUMN IF EXISTS snapshot_generation;
@@ -0,0 +1,16 @@
+-- Polls write the snapshot in batches as they page through a database. Each
+-- poll starts a new generation and marks the rows it sees with it, so the
+-- rows left unmarked at the end are the pages that were deleted.
+ALTER TABLE webhooks
+    ADD COLUMN IF NOT EXISTS snapshot_generation BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE snapshot_pages
+    ADD COLUMN IF NOT EXISTS seen_generation BIGINT NOT NULL DEFAULT 0;
+
+-- Marking a row as seen isn't a change to the page.
+CREATE OR REPLACE TRIGGER snapshot_pages_updated_at BEFORE UPDATE ON snapshot_pages
+    FOR EACH ROW
+    WHEN (OLD.last_edited_time IS DISTINCT FROM NEW.last_edited_time
+        OR OLD.property_hash IS DISTINCT FROM NEW.property_hash
+        OR OLD.properties IS DISTINCT FROM NEW.properties)
+    EXECUTE FUNCTION set_updated_at();
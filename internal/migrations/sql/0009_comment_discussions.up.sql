@@ -0,0 +1,17 @@
+-- The open comment discussions a webhook has seen on each page, so a poll
+-- can tell new comments and resolved discussions apart.
+CREATE TABLE IF NOT EXISTS comment_discussions (
+    webhook_id    UUID NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+    discussion_id TEXT NOT NULL,
+    page_id       TEXT NOT NULL,
+    comment_ids   TEXT[] NOT NULL DEFAULT '{}',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (webhook_id, discussion_id)
+);
+
+CREATE INDEX IF NOT EXISTS comment_discussions_page_idx
+    ON comment_discussions (webhook_id, page_id);
+
+CREATE OR REPLACE TRIGGER comment_discussions_updated_at BEFORE UPDATE ON comment_discussions
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
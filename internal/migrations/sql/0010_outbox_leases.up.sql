@@ -0,0 +1,4 @@
+-- Relays lease the messages they claim instead of holding row locks while
+-- they publish, so an unpublished message is retried once its lease ends.
+ALTER TABLE event_outbox
+    ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMPTZ;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS webhooks_claimed_at_idx;
+
+ALTER TABLE webhooks
+    DROP COLUMN IF EXISTS claimed_at;
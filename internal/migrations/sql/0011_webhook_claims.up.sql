@@ -0,0 +1,9 @@
+-- When a webhook was claimed, so the scheduler can hand back webhooks left
+-- processing by a worker that died. Webhooks already processing get the
+-- full timeout from now.
+ALTER TABLE webhooks
+    ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ;
+
+UPDATE webhooks SET claimed_at = NOW() WHERE status = 'processing' AND claimed_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS webhooks_claimed_at_idx ON webhooks (claimed_at) WHERE status = 'processing';
@@ -0,0 +1,2 @@
+ALTER TABLE snapshot_pages
+    DROP COLUMN IF EXISTS comments_checked_at;
@@ -0,0 +1,5 @@
+-- When each page's comments were last checked, so a poll can check a
+-- bounded number of pages, those never checked first and then the least
+-- recently checked.
+ALTER TABLE snapshot_pages
+    ADD COLUMN IF NOT EXISTS comments_checked_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+ALTER TABLE webhooks
+    DROP CONSTRAINT IF EXISTS webhooks_adaptive_schedule_check;
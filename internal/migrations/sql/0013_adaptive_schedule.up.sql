@@ -0,0 +1,6 @@
+-- Adaptive webhooks pick their own interval, so a cron schedule on one was
+-- ignored. Clear those schedules and reject new ones.
+UPDATE webhooks SET schedule = '' WHERE adaptive_polling AND schedule <> '';
+
+ALTER TABLE webhooks
+    ADD CONSTRAINT webhooks_adaptive_schedule_check CHECK (NOT adaptive_polling OR schedule = '');
@@ -1,29 +1,47 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
-
-	"github.com/gavsidhu/notion-hooks/internal/notion"
 )
 
 type Webhook struct {
-	ID               string     `json:"id"`
-	Name             string     `json:"name"`
-	Description      string     `json:"description"`
-	UserID           string     `json:"user_id"`
-	URL              string     `json:"url"`
-	Secret           string     `json:"secret"`
-	Events           []string   `json:"events"`
-	IsActive         bool       `json:"is_active"`
-	Status           string     `json:"status"`
-	PollingInterval  int        `json:"polling_interval"`
-	LastPolled       *time.Time `json:"last_polled"`
-	NotionObjectID   string     `json:"notion_object_id"`
-	NotionObjectType string     `json:"notion_object_type"`
-	CreatedAt        time.Time  `json:"created_at"`
-	UpdatedAt        time.Time  `json:"updated_at"`
+	ID                       string     `json:"id"`
+	Name                     string     `json:"name"`
+	Description              string     `json:"description"`
+	UserID                   string     `json:"user_id"`
+	URL                      string     `json:"url"`
+	Secret                   string     `json:"secret"`
+	Events                   []string   `json:"events"`
+	IsActive                 bool       `json:"is_active"`
+	Status                   string     `json:"status"`
+	PollingInterval          int        `json:"polling_interval"`
+	Schedule                 string     `json:"schedule"`
+	Timezone                 string     `json:"timezone"`
+	PollWindow               string     `json:"poll_window"`
+	Plan                     string     `json:"plan"`
+	AdaptivePolling          bool       `json:"adaptive_polling"`
+	AdaptiveMaxInterval      int        `json:"adaptive_max_interval"`
+	EffectiveIntervalSeconds int        `json:"effective_interval_seconds"`
+	LastPolled               *time.Time `json:"last_polled"`
+	NextPollAt               *time.Time `json:"next_poll_at"`
+	NotionObjectID           string     `json:"notion_object_id"`
+	NotionObjectType         string     `json:"notion_object_type"`
+	// QueryFilter and QuerySorts narrow the database query the webhook
+	// polls, in Notion's filter and sort syntax. Pages entering or leaving
+	// the filter are reported as page.entered_view and page.left_view.
+	QueryFilter json.RawMessage `json:"query_filter,omitempty"`
+	QuerySorts  json.RawMessage `json:"query_sorts,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
 }
 
+const (
+	WebhookStatusIdle       = "idle"
+	WebhookStatusProcessing = "processing"
+	WebhookStatusPaused     = "paused"
+)
+
 type WebhookResponse struct {
 	Webhook Webhook `json:"webhook"`
 }
@@ -33,41 +51,87 @@ type WebhooksResponse struct {
 }
 
 type WebhookCreateRequest struct {
-	URL             string `json:"url"`
-	ContentType     string `json:"content_type"`
-	Secret          string `json:"secret"`
-	Events          string `json:"events"`
-	IsActive        bool   `json:"is_active"`
-	PollingInterval int    `json:"polling_interval"`
-	NotionDataID    string `json:"notion_data_id"`
+	URL                 string `json:"url"`
+	ContentType         string `json:"content_type"`
+	Secret              string `json:"secret"`
+	Events              string `json:"events"`
+	IsActive            bool   `json:"is_active"`
+	PollingInterval     int    `json:"polling_interval"`
+	Schedule            string `json:"schedule"`
+	Timezone            string `json:"timezone"`
+	PollWindow          string `json:"poll_window"`
+	AdaptivePolling     bool   `json:"adaptive_polling"`
+	AdaptiveMaxInterval int    `json:"adaptive_max_interval"`
+	NotionDataID        string `json:"notion_data_id"`
 }
 
 type WebhookUpdateRequest struct {
-	URL             string `json:"url"`
-	ContentType     string `json:"content_type"`
-	Secret          string `json:"secret"`
-	Events          string `json:"events"`
-	IsActive        bool   `json:"is_active"`
-	PollingInterval int    `json:"polling_interval"`
-	NotionDataID    string `json:"notion_data_id"`
+	URL                 string `json:"url"`
+	ContentType         string `json:"content_type"`
+	Secret              string `json:"secret"`
+	Events              string `json:"events"`
+	IsActive            bool   `json:"is_active"`
+	PollingInterval     int    `json:"polling_interval"`
+	Schedule            string `json:"schedule"`
+	Timezone            string `json:"timezone"`
+	PollWindow          string `json:"poll_window"`
+	AdaptivePolling     bool   `json:"adaptive_polling"`
+	AdaptiveMaxInterval int    `json:"adaptive_max_interval"`
+	NotionDataID        string `json:"notion_data_id"`
 }
 
-type NotionDatabasePageIDRow struct {
-	ID        int       `json:"id"`
-	WebhookID string    `json:"webhook_id"`
-	UserID    string    `json:"user_id"`
-	PageIDs   []string  `json:"page_ids"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+// SnapshotPage is what a webhook's snapshot remembers about one page.
+type SnapshotPage struct {
+	PageID         string          `json:"page_id"`
+	LastEditedTime time.Time       `json:"last_edited_time"`
+	PropertyHash   string          `json:"property_hash"`
+	Properties     json.RawMessage `json:"properties,omitempty"`
+}
+
+// SnapshotVersion is a page's state during the period it was current.
+// ValidTo is nil while it still is.
+type SnapshotVersion struct {
+	SnapshotPage
+	ValidFrom time.Time  `json:"valid_from"`
+	ValidTo   *time.Time `json:"valid_to,omitempty"`
+}
+
+// SnapshotDiff lists the pages that changed between a snapshot and a poll.
+type SnapshotDiff struct {
+	Added   []string `json:"added"`
+	Deleted []string `json:"deleted"`
+	// Updated holds pages in both whose last_edited_time changed.
+	Updated []string `json:"updated"`
+	// EnteredView and LeftView hold pages that started or stopped matching
+	// the webhook's query filter. They are taken out of Added and Deleted.
+	EnteredView []string `json:"entered_view,omitempty"`
+	LeftView    []string `json:"left_view,omitempty"`
+}
+
+// HistoryDiff is what changed in a webhook's database between two points in
+// its snapshot history, with the events a live poll would have generated.
+type HistoryDiff struct {
+	WebhookID string         `json:"webhook_id"`
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Diff      SnapshotDiff   `json:"diff"`
+	Events    []EventsToSend `json:"events"`
+}
+
+// Discussion is a comment thread on a page as a webhook last saw it. Notion
+// only lists the comments of open discussions.
+type Discussion struct {
+	DiscussionID string   `json:"discussion_id"`
+	PageID       string   `json:"page_id"`
+	CommentIDs   []string `json:"comment_ids"`
 }
 
-type NotionDatabaseDetailRow struct {
-	ID                  int                          `json:"id"`
-	WebhooksID          string                       `json:"webhooks_id"`
-	UserID              string                       `json:"user_id"`
-	DatabasePageDetails notion.DatabaseQueryResponse `json:"database_page_details"`
-	CreatedAt           time.Time                    `json:"created_at"`
-	UpdatedAt           time.Time                    `json:"updated_at"`
+// CommentCheck is a page whose comments are due to be checked. Comments
+// created before Since predate what the webhook has seen of the page, so
+// they aren't reported as added.
+type CommentCheck struct {
+	PageID string
+	Since  time.Time
 }
 
 type WebhookLog struct {
@@ -86,15 +150,32 @@ type EventData struct {
 	ObjectID   string `json:"object_id"`
 	ObjectType string `json:"object_type"`
 	CreatedAt  int64  `json:"created_at"`
+	// Comment events also say which page and discussion the comment is
+	// in. comment.added carries its author and the comment's rich text, as
+	// Notion returns it.
+	PageID       string          `json:"page_id,omitempty"`
+	DiscussionID string          `json:"discussion_id,omitempty"`
+	AuthorID     string          `json:"author_id,omitempty"`
+	Content      json.RawMessage `json:"content,omitempty"`
 }
 
 type EventsToSend struct {
+	ID        string    `json:"id,omitempty"`
 	Type      string    `json:"type"`
 	UserID    string    `json:"user_id"`
 	WebhookID string    `json:"webhook_id"`
 	Data      EventData `json:"data"`
 }
 
+// OutboxMessage is a message committed for publishing to Queue. Headers
+// carry the trace context of the poll that queued it.
+type OutboxMessage struct {
+	ID      string
+	Queue   string
+	Payload []byte
+	Headers map[string]string
+}
+
 type Event struct {
 	ID        string    `json:"id"`
 	WebhookID string    `json:"webhook_id"`
@@ -103,6 +184,46 @@ type Event struct {
 	CreatedAt int64     `json:"created_at"`
 }
 
+type EventRecord struct {
+	ID               string     `json:"id"`
+	WebhookID        string     `json:"webhook_id"`
+	UserID           string     `json:"user_id"`
+	Type             string     `json:"type"`
+	ObjectID         string     `json:"object_id"`
+	Data             EventData  `json:"data"`
+	Status           string     `json:"status"`
+	Attempts         int        `json:"attempts"`
+	LastResponseCode *int       `json:"last_response_code,omitempty"`
+	LastError        *string    `json:"last_error,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	DeliveredAt      *time.Time `json:"delivered_at,omitempty"`
+}
+
+const (
+	EventStatusPending   = "pending"
+	EventStatusDelivered = "delivered"
+	EventStatusFailed    = "failed"
+)
+
+type EventsResponse struct {
+	Events []EventRecord `json:"events"`
+}
+
+type EventFilter struct {
+	UserID    string
+	WebhookID string
+	Type      string
+	ObjectID  string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+}
+
+type WebhookFilter struct {
+	UserID string
+	Status string
+}
+
 type InitialPollMessage struct {
 	WebhookID        string `json:"webhook_id"`
 	UserID           string `json:"user_id"`
@@ -0,0 +1,122 @@
+package notion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+)
+
+const commentPageSize = 100
+
+// Comment is a comment on a page or in a discussion on one of its blocks.
+// Comments with the same DiscussionID form a thread.
+type Comment struct {
+	Object         string      `json:"object"`
+	ID             string      `json:"id"`
+	Parent         Parent      `json:"parent"`
+	DiscussionID   string      `json:"discussion_id"`
+	CreatedTime    string      `json:"created_time"`
+	LastEditedTime string      `json:"last_edited_time"`
+	CreatedBy      PartialUser `json:"created_by"`
+	RichText       []RichText  `json:"rich_text"`
+}
+
+// CommentList is a page of a block's comments.
+type CommentList struct {
+	Object     string    `json:"object"`
+	Results    []Comment `json:"results"`
+	NextCursor *string   `json:"next_cursor"`
+	HasMore    bool      `json:"has_more"`
+	Type       string    `json:"type"`
+}
+
+// ListComments returns the comments on a page or block, oldest first,
+// following pagination. Notion leaves out comments in discussions that
+// were resolved. The integration needs the read comments capability.
+func (c *NotionClient) ListComments(ctx context.Context, blockID string) ([]Comment, error) {
+	var comments []Comment
+	nextCursor := ""
+
+	for {
+		list, err := c.listCommentsPage(ctx, blockID, nextCursor)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, list.Results...)
+
+		if !list.HasMore || list.NextCursor == nil {
+			return comments, nil
+		}
+		nextCursor = *list.NextCursor
+	}
+}
+
+func (c *NotionClient) listCommentsPage(ctx context.Context, blockID string, cursor string) (CommentList, error) {
+	if err := tokenLimiter.Wait(ctx, c.token); err != nil {
+		return CommentList{}, err
+	}
+
+	query := url.Values{
+		"block_id":  {blockID},
+		"page_size": {strconv.Itoa(commentPageSize)},
+	}
+	if cursor != "" {
+		query.Set("start_cursor", cursor)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%scomments?%s", c.baseURL, query.Encode()), nil)
+	if err != nil {
+		return CommentList{}, err
+	}
+
+	req.Header.Set("Notion-Version", c.version)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+
+	res, err := c.do(req, "comments.list")
+	if err != nil {
+		c.log.WithFields(logrus.Fields{
+			"error":    err,
+			"block_id": blockID,
+		}).Error("Error with Notion request")
+		return CommentList{}, err
+	}
+
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.log.WithFields(logrus.Fields{
+			"error":    err,
+			"block_id": blockID,
+		}).Error("Error reading Notion response body")
+		return CommentList{}, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return CommentList{}, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		c.log.WithFields(logrus.Fields{
+			"status":   res.StatusCode,
+			"block_id": blockID,
+		}).Error("Received non-OK HTTP status code from Notion")
+		return CommentList{}, fmt.Errorf("received non-OK HTTP status code: %d", res.StatusCode)
+	}
+
+	var list CommentList
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		c.log.WithFields(logrus.Fields{
+			"error":    err,
+			"block_id": blockID,
+		}).Error("Error unmarshalling Notion response")
+		return CommentList{}, err
+	}
+
+	return list, nil
+}
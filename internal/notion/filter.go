@@ -0,0 +1,442 @@
+package notion
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Filter is a database query filter. Build one from a property's
+// conditions, such as Where("Team").Select().Equals("Platform"), combine
+// filters with And and Or, or wrap stored JSON with RawFilter.
+type Filter struct {
+	value any
+}
+
+func (f Filter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f.value)
+}
+
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	f.value = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// RawFilter wraps a filter already in Notion's JSON syntax.
+func RawFilter(filter json.RawMessage) Filter {
+	return Filter{value: filter}
+}
+
+// And matches pages that match every filter.
+func And(filters ...Filter) Filter {
+	return Filter{value: map[string]any{"and": compound(filters)}}
+}
+
+// Or matches pages that match any of the filters.
+func Or(filters ...Filter) Filter {
+	return Filter{value: map[string]any{"or": compound(filters)}}
+}
+
+func compound(filters []Filter) []Filter {
+	if filters == nil {
+		return []Filter{}
+	}
+
+	return filters
+}
+
+// PropertyFilter picks the conditions of a property by its type.
+type PropertyFilter struct {
+	property string
+}
+
+// Where starts a filter on the property with the given name or ID.
+func Where(property string) PropertyFilter {
+	return PropertyFilter{property: property}
+}
+
+func (p PropertyFilter) condition(key string) condition {
+	return condition{nameKey: "property", name: p.property, keys: []string{key}}
+}
+
+func (p PropertyFilter) Title() TextCondition {
+	return TextCondition{p.condition(PropertyTitle)}
+}
+
+func (p PropertyFilter) RichText() TextCondition {
+	return TextCondition{p.condition(PropertyRichText)}
+}
+
+func (p PropertyFilter) URL() TextCondition {
+	return TextCondition{p.condition(PropertyURL)}
+}
+
+func (p PropertyFilter) Email() TextCondition {
+	return TextCondition{p.condition(PropertyEmail)}
+}
+
+func (p PropertyFilter) PhoneNumber() TextCondition {
+	return TextCondition{p.condition(PropertyPhoneNumber)}
+}
+
+func (p PropertyFilter) Number() NumberCondition {
+	return NumberCondition{p.condition(PropertyNumber)}
+}
+
+func (p PropertyFilter) UniqueID() NumberCondition {
+	return NumberCondition{p.condition(PropertyUniqueID)}
+}
+
+func (p PropertyFilter) Checkbox() CheckboxCondition {
+	return CheckboxCondition{p.condition(PropertyCheckbox)}
+}
+
+func (p PropertyFilter) Select() SelectCondition {
+	return SelectCondition{p.condition(PropertySelect)}
+}
+
+func (p PropertyFilter) Status() SelectCondition {
+	return SelectCondition{p.condition(PropertyStatus)}
+}
+
+func (p PropertyFilter) MultiSelect() MultiSelectCondition {
+	return MultiSelectCondition{p.condition(PropertyMultiSelect)}
+}
+
+// Date filters date, created time and last edited time properties.
+func (p PropertyFilter) Date() DateCondition {
+	return DateCondition{p.condition(PropertyDate)}
+}
+
+// People filters people, created by and last edited by properties.
+func (p PropertyFilter) People() ReferenceCondition {
+	return ReferenceCondition{p.condition(PropertyPeople)}
+}
+
+func (p PropertyFilter) Relation() ReferenceCondition {
+	return ReferenceCondition{p.condition(PropertyRelation)}
+}
+
+func (p PropertyFilter) Files() FilesCondition {
+	return FilesCondition{p.condition(PropertyFiles)}
+}
+
+func (p PropertyFilter) Formula() FormulaCondition {
+	return FormulaCondition{p.condition(PropertyFormula)}
+}
+
+func (p PropertyFilter) Rollup() RollupCondition {
+	return RollupCondition{p.condition(PropertyRollup)}
+}
+
+// WhereCreatedTime starts a filter on when pages were created, which needs
+// no created time property.
+func WhereCreatedTime() DateCondition {
+	return DateCondition{timestampCondition(PropertyCreatedTime)}
+}
+
+// WhereLastEditedTime starts a filter on when pages were last edited, which
+// needs no last edited time property.
+func WhereLastEditedTime() DateCondition {
+	return DateCondition{timestampCondition(PropertyLastEditedTime)}
+}
+
+func timestampCondition(timestamp string) condition {
+	return condition{nameKey: "timestamp", name: timestamp, keys: []string{timestamp}}
+}
+
+// condition builds the filters of one property or timestamp. keys is the
+// path to the operator, e.g. ["formula", "string"] for a formula's text.
+type condition struct {
+	nameKey string
+	name    string
+	keys    []string
+}
+
+func (c condition) filter(operator string, operand any) Filter {
+	var value any = map[string]any{operator: operand}
+	for i := len(c.keys) - 1; i >= 0; i-- {
+		value = map[string]any{c.keys[i]: value}
+	}
+
+	filter := value.(map[string]any)
+	filter[c.nameKey] = c.name
+
+	return Filter{value: filter}
+}
+
+func (c condition) nested(key string) condition {
+	c.keys = append(append([]string(nil), c.keys...), key)
+	return c
+}
+
+// TextCondition holds the conditions of title, rich text, URL, email and
+// phone number properties.
+type TextCondition struct{ c condition }
+
+func (t TextCondition) Equals(s string) Filter {
+	return t.c.filter("equals", s)
+}
+
+func (t TextCondition) DoesNotEqual(s string) Filter {
+	return t.c.filter("does_not_equal", s)
+}
+
+func (t TextCondition) Contains(s string) Filter {
+	return t.c.filter("contains", s)
+}
+
+func (t TextCondition) DoesNotContain(s string) Filter {
+	return t.c.filter("does_not_contain", s)
+}
+
+func (t TextCondition) StartsWith(s string) Filter {
+	return t.c.filter("starts_with", s)
+}
+
+func (t TextCondition) EndsWith(s string) Filter {
+	return t.c.filter("ends_with", s)
+}
+
+func (t TextCondition) IsEmpty() Filter {
+	return t.c.filter("is_empty", true)
+}
+
+func (t TextCondition) IsNotEmpty() Filter {
+	return t.c.filter("is_not_empty", true)
+}
+
+// NumberCondition holds the conditions of number and unique ID properties.
+type NumberCondition struct{ c condition }
+
+func (n NumberCondition) Equals(v float64) Filter {
+	return n.c.filter("equals", v)
+}
+
+func (n NumberCondition) DoesNotEqual(v float64) Filter {
+	return n.c.filter("does_not_equal", v)
+}
+
+func (n NumberCondition) GreaterThan(v float64) Filter {
+	return n.c.filter("greater_than", v)
+}
+
+func (n NumberCondition) LessThan(v float64) Filter {
+	return n.c.filter("less_than", v)
+}
+
+func (n NumberCondition) GreaterThanOrEqualTo(v float64) Filter {
+	return n.c.filter("greater_than_or_equal_to", v)
+}
+
+func (n NumberCondition) LessThanOrEqualTo(v float64) Filter {
+	return n.c.filter("less_than_or_equal_to", v)
+}
+
+func (n NumberCondition) IsEmpty() Filter {
+	return n.c.filter("is_empty", true)
+}
+
+func (n NumberCondition) IsNotEmpty() Filter {
+	return n.c.filter("is_not_empty", true)
+}
+
+// CheckboxCondition holds the conditions of checkbox properties.
+type CheckboxCondition struct{ c condition }
+
+func (b CheckboxCondition) Equals(v bool) Filter {
+	return b.c.filter("equals", v)
+}
+
+func (b CheckboxCondition) DoesNotEqual(v bool) Filter {
+	return b.c.filter("does_not_equal", v)
+}
+
+// SelectCondition holds the conditions of select and status properties,
+// which compare option names.
+type SelectCondition struct{ c condition }
+
+func (s SelectCondition) Equals(option string) Filter {
+	return s.c.filter("equals", option)
+}
+
+func (s SelectCondition) DoesNotEqual(option string) Filter {
+	return s.c.filter("does_not_equal", option)
+}
+
+func (s SelectCondition) IsEmpty() Filter {
+	return s.c.filter("is_empty", true)
+}
+
+func (s SelectCondition) IsNotEmpty() Filter {
+	return s.c.filter("is_not_empty", true)
+}
+
+// MultiSelectCondition holds the conditions of multi-select properties,
+// which compare option names.
+type MultiSelectCondition struct{ c condition }
+
+func (m MultiSelectCondition) Contains(option string) Filter {
+	return m.c.filter("contains", option)
+}
+
+func (m MultiSelectCondition) DoesNotContain(option string) Filter {
+	return m.c.filter("does_not_contain", option)
+}
+
+func (m MultiSelectCondition) IsEmpty() Filter {
+	return m.c.filter("is_empty", true)
+}
+
+func (m MultiSelectCondition) IsNotEmpty() Filter {
+	return m.c.filter("is_not_empty", true)
+}
+
+// DateCondition holds the conditions of dates and timestamps. Times are
+// sent in RFC 3339 with their offset.
+type DateCondition struct{ c condition }
+
+func (d DateCondition) Equals(t time.Time) Filter {
+	return d.c.filter("equals", t.Format(time.RFC3339))
+}
+
+func (d DateCondition) Before(t time.Time) Filter {
+	return d.c.filter("before", t.Format(time.RFC3339))
+}
+
+func (d DateCondition) After(t time.Time) Filter {
+	return d.c.filter("after", t.Format(time.RFC3339))
+}
+
+func (d DateCondition) OnOrBefore(t time.Time) Filter {
+	return d.c.filter("on_or_before", t.Format(time.RFC3339))
+}
+
+func (d DateCondition) OnOrAfter(t time.Time) Filter {
+	return d.c.filter("on_or_after", t.Format(time.RFC3339))
+}
+
+func (d DateCondition) PastWeek() Filter {
+	return d.c.filter("past_week", struct{}{})
+}
+
+func (d DateCondition) PastMonth() Filter {
+	return d.c.filter("past_month", struct{}{})
+}
+
+func (d DateCondition) PastYear() Filter {
+	return d.c.filter("past_year", struct{}{})
+}
+
+func (d DateCondition) ThisWeek() Filter {
+	return d.c.filter("this_week", struct{}{})
+}
+
+func (d DateCondition) NextWeek() Filter {
+	return d.c.filter("next_week", struct{}{})
+}
+
+func (d DateCondition) NextMonth() Filter {
+	return d.c.filter("next_month", struct{}{})
+}
+
+func (d DateCondition) NextYear() Filter {
+	return d.c.filter("next_year", struct{}{})
+}
+
+func (d DateCondition) IsEmpty() Filter {
+	return d.c.filter("is_empty", true)
+}
+
+func (d DateCondition) IsNotEmpty() Filter {
+	return d.c.filter("is_not_empty", true)
+}
+
+// ReferenceCondition holds the conditions of people and relation
+// properties, which compare user and page IDs.
+type ReferenceCondition struct{ c condition }
+
+func (r ReferenceCondition) Contains(id string) Filter {
+	return r.c.filter("contains", id)
+}
+
+func (r ReferenceCondition) DoesNotContain(id string) Filter {
+	return r.c.filter("does_not_contain", id)
+}
+
+func (r ReferenceCondition) IsEmpty() Filter {
+	return r.c.filter("is_empty", true)
+}
+
+func (r ReferenceCondition) IsNotEmpty() Filter {
+	return r.c.filter("is_not_empty", true)
+}
+
+// FilesCondition holds the conditions of files properties.
+type FilesCondition struct{ c condition }
+
+func (f FilesCondition) IsEmpty() Filter {
+	return f.c.filter("is_empty", true)
+}
+
+func (f FilesCondition) IsNotEmpty() Filter {
+	return f.c.filter("is_not_empty", true)
+}
+
+// FormulaCondition picks the conditions of a formula by the type of its
+// result.
+type FormulaCondition struct{ c condition }
+
+func (f FormulaCondition) Text() TextCondition {
+	return TextCondition{f.c.nested("string")}
+}
+
+func (f FormulaCondition) Number() NumberCondition {
+	return NumberCondition{f.c.nested("number")}
+}
+
+func (f FormulaCondition) Checkbox() CheckboxCondition {
+	return CheckboxCondition{f.c.nested("checkbox")}
+}
+
+func (f FormulaCondition) Date() DateCondition {
+	return DateCondition{f.c.nested("date")}
+}
+
+// RollupCondition picks the conditions of a rollup that calculates a
+// number or a date. Filters on the items of array rollups can be written
+// with RawFilter.
+type RollupCondition struct{ c condition }
+
+func (r RollupCondition) Number() NumberCondition {
+	return NumberCondition{r.c.nested("number")}
+}
+
+func (r RollupCondition) Date() DateCondition {
+	return DateCondition{r.c.nested("date")}
+}
+
+// SortDirection orders a sort.
+type SortDirection string
+
+const (
+	SortAscending  SortDirection = "ascending"
+	SortDescending SortDirection = "descending"
+)
+
+// Sort orders query results by a property or a timestamp.
+type Sort struct {
+	Property  string        `json:"property,omitempty"`
+	Timestamp string        `json:"timestamp,omitempty"`
+	Direction SortDirection `json:"direction"`
+}
+
+// SortByProperty orders results by the value of the property with the
+// given name or ID.
+func SortByProperty(property string, direction SortDirection) Sort {
+	return Sort{Property: property, Direction: direction}
+}
+
+// SortByTimestamp orders results by "created_time" or "last_edited_time".
+func SortByTimestamp(timestamp string, direction SortDirection) Sort {
+	return Sort{Timestamp: timestamp, Direction: direction}
+}
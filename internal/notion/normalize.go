@@ -0,0 +1,156 @@
+package notion
+
+// NormalizedDate is the canonical form of a date value.
+type NormalizedDate struct {
+	Start    string  `json:"start"`
+	End      *string `json:"end,omitempty"`
+	TimeZone *string `json:"time_zone,omitempty"`
+}
+
+// Normalize returns the property's value in a canonical form for diffing
+// and payloads. It keeps what a person would see as the value: plain text
+// without formatting, option names without colours, user and page IDs, and
+// file names instead of the signed URLs of uploaded files, which change on
+// every request. The result is nil for an empty property, a string, a
+// float64, a bool, a NormalizedDate, a []string or, for rollup arrays, a
+// []any of normalized values.
+func (p PageProperty) Normalize() any {
+	switch p.Type {
+	case PropertyTitle, PropertyRichText:
+		if text, ok := p.AsText(); ok {
+			return text
+		}
+		return ""
+	case PropertyNumber:
+		if number, ok := p.AsNumber(); ok {
+			return number
+		}
+	case PropertyCheckbox:
+		checked, _ := p.AsCheckbox()
+		return checked
+	case PropertySelect, PropertyStatus:
+		option, ok := p.AsSelect()
+		if !ok {
+			option, ok = p.AsStatus()
+		}
+		if ok {
+			return option.Name
+		}
+	case PropertyMultiSelect:
+		options, _ := p.AsMultiSelect()
+		names := make([]string, len(options))
+		for i, option := range options {
+			names[i] = option.Name
+		}
+		return names
+	case PropertyDate:
+		if date, ok := p.AsDate(); ok {
+			return normalizeDate(date)
+		}
+	case PropertyPeople:
+		people, _ := p.AsPeople()
+		ids := make([]string, len(people))
+		for i, person := range people {
+			ids[i] = person.ID
+		}
+		return ids
+	case PropertyFiles:
+		files, _ := p.AsFiles()
+		names := make([]string, len(files))
+		for i, file := range files {
+			names[i] = file.Name
+			if file.External != nil {
+				names[i] = file.External.URL
+			}
+		}
+		return names
+	case PropertyRelation:
+		ids, _ := p.AsRelation()
+		if ids == nil {
+			ids = []string{}
+		}
+		return ids
+	case PropertyURL, PropertyEmail, PropertyPhoneNumber:
+		for _, value := range []*string{p.URL, p.Email, p.PhoneNumber} {
+			if value != nil {
+				return *value
+			}
+		}
+	case PropertyFormula:
+		if formula, ok := p.AsFormula(); ok {
+			return normalizeFormula(formula)
+		}
+	case PropertyRollup:
+		if rollup, ok := p.AsRollup(); ok {
+			return normalizeRollup(rollup)
+		}
+	case PropertyCreatedTime, PropertyLastEditedTime:
+		if p.CreatedTime != nil {
+			return *p.CreatedTime
+		}
+		if p.LastEditedTime != nil {
+			return *p.LastEditedTime
+		}
+	case PropertyCreatedBy, PropertyLastEditedBy:
+		if user, ok := p.AsUser(); ok {
+			return user.ID
+		}
+	case PropertyUniqueID:
+		if id, ok := p.AsUniqueID(); ok {
+			return id.String()
+		}
+	case PropertyVerification:
+		if verification, ok := p.AsVerification(); ok {
+			return verification.State
+		}
+	}
+
+	return nil
+}
+
+// NormalizeProperties normalizes every property of a page, keyed by
+// property name.
+func NormalizeProperties(properties map[string]PageProperty) map[string]any {
+	normalized := make(map[string]any, len(properties))
+	for name, property := range properties {
+		normalized[name] = property.Normalize()
+	}
+
+	return normalized
+}
+
+func normalizeDate(date PageDateProperty) NormalizedDate {
+	return NormalizedDate{Start: date.Start, End: date.End, TimeZone: date.TimeZone}
+}
+
+func normalizeFormula(formula PageFormulaProperty) any {
+	switch {
+	case formula.Type == "string" && formula.String != nil:
+		return *formula.String
+	case formula.Type == "number" && formula.Number != nil:
+		return *formula.Number
+	case formula.Type == "boolean" && formula.Boolean != nil:
+		return *formula.Boolean
+	case formula.Type == "date" && formula.Date != nil:
+		return normalizeDate(*formula.Date)
+	}
+
+	return nil
+}
+
+func normalizeRollup(rollup PageRollupProperty) any {
+	switch {
+	case rollup.Type == "number" && rollup.Number != nil:
+		return *rollup.Number
+	case rollup.Type == "date" && rollup.Date != nil:
+		return normalizeDate(*rollup.Date)
+	case rollup.Type == "array" && rollup.Array != nil:
+		values := make([]any, len(*rollup.Array))
+		for i, value := range *rollup.Array {
+			values[i] = value.Normalize()
+		}
+		return values
+	}
+
+	return nil
+}
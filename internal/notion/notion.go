@@ -4,28 +4,102 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gavsidhu/notion-hooks/internal/logging"
+	"github.com/gavsidhu/notion-hooks/internal/metrics"
+	"github.com/gavsidhu/notion-hooks/internal/tracing"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const (
+	DefaultBaseURL = "https://api.notion.com/v1/"
+	DefaultVersion = "2022-06-28"
+
+	propertyItemPageSize = 100
+
+	// maxRateLimitRetries is how many times a request answered with 429 Too
+	// Many Requests is sent again before the response is returned.
+	maxRateLimitRetries = 3
+	// maxRetryAfter caps how long a request waits out the rate limit. When
+	// Notion asks for longer, the 429 is returned rather than stalling the
+	// poll.
+	maxRetryAfter = 30 * time.Second
+	// defaultRetryAfter is the wait when a 429 has no usable Retry-After.
+	defaultRetryAfter = time.Second
+)
+
+// ErrNotFound is returned when Notion has no object with the ID, or it isn't
+// shared with the integration.
+var ErrNotFound = errors.New("notion: object not found")
+
 type NotionClient struct {
 	httpClient *http.Client
 	baseURL    string
+	version    string
 	token      string
+	log        logrus.FieldLogger
+}
+
+type Option func(*NotionClient)
+
+// WithBaseURL points the client at a different API root, e.g. a proxy or a
+// fake server in tests.
+func WithBaseURL(baseURL string) Option {
+	return func(c *NotionClient) {
+		if !strings.HasSuffix(baseURL, "/") {
+			baseURL += "/"
+		}
+		c.baseURL = baseURL
+	}
+}
+
+// WithVersion sets the Notion-Version header sent with every request.
+func WithVersion(version string) Option {
+	return func(c *NotionClient) {
+		c.version = version
+	}
+}
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *NotionClient) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithLogger sets the logger used for failed requests.
+func WithLogger(log logrus.FieldLogger) Option {
+	return func(c *NotionClient) {
+		c.log = log
+	}
 }
 
-func NewNotionClient(token string) *NotionClient {
-	return &NotionClient{
+func NewNotionClient(token string, opts ...Option) *NotionClient {
+	c := &NotionClient{
 		httpClient: &http.Client{
 			Timeout: time.Second * 30,
 		},
-		baseURL: "https://api.notion.com/v1/",
+		baseURL: DefaultBaseURL,
+		version: DefaultVersion,
 		token:   token,
+		log:     logging.Nop(),
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
 }
 
 type ErrorResponse struct {
@@ -37,18 +111,117 @@ func (c *NotionClient) SetToken(token string) {
 	c.token = token
 }
 
+// do sends req, which has waited for the token's rate limiter, and sends it
+// again when Notion answers 429 Too Many Requests, after the Retry-After
+// delay, up to maxRateLimitRetries times.
+func (c *NotionClient) do(req *http.Request, endpoint string) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		res, err := c.send(req, endpoint)
+		if err != nil || res.StatusCode != http.StatusTooManyRequests || attempt == maxRateLimitRetries {
+			return res, err
+		}
+
+		wait := retryAfter(res.Header.Get("Retry-After"), time.Now())
+		if wait > maxRetryAfter {
+			return res, nil
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+
+		c.log.WithFields(logrus.Fields{
+			"endpoint":    endpoint,
+			"retry_after": wait.String(),
+			"attempt":     attempt + 1,
+		}).Warn("Rate limited by Notion, retrying")
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		if err := tokenLimiter.Wait(ctx, c.token); err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header, which holds either seconds or an
+// HTTP date.
+func retryAfter(header string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if wait := at.Sub(now); wait > 0 {
+			return wait
+		}
+		return 0
+	}
+
+	return defaultRetryAfter
+}
+
+// send sends req in a client span and records its latency and status under
+// the given endpoint name.
+func (c *NotionClient) send(req *http.Request, endpoint string) (*http.Response, error) {
+	ctx, span := tracing.Tracer.Start(req.Context(), "notion."+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	res, err := c.httpClient.Do(req.WithContext(ctx))
+
+	status := 0
+	if err == nil {
+		status = res.StatusCode
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	} else {
+		tracing.RecordError(span, err)
+	}
+	metrics.NotionRequestDuration.WithLabelValues(endpoint, metrics.StatusCode(status)).Observe(time.Since(start).Seconds())
+
+	return res, err
+}
+
 func (c *NotionClient) GetDatabase(ctx context.Context, databaseID string) (Database, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%sdatabases/%s", c.baseURL, databaseID), nil)
 	if err != nil {
 		return Database{}, err
 	}
 
-	req.Header.Set("Notion-Version", "2022-06-28")
+	req.Header.Set("Notion-Version", c.version)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
 
-	res, err := c.httpClient.Do(req)
+	if err := tokenLimiter.Wait(ctx, c.token); err != nil {
+		return Database{}, err
+	}
+
+	res, err := c.do(req, "databases.retrieve")
 	if err != nil {
-		fmt.Println("error with request", err)
+		c.log.WithFields(logrus.Fields{
+			"error":       err,
+			"database_id": databaseID,
+		}).Error("Error with Notion request")
 		return Database{}, err
 	}
 
@@ -56,14 +229,31 @@ func (c *NotionClient) GetDatabase(ctx context.Context, databaseID string) (Data
 
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		fmt.Println("error reading body", err)
+		c.log.WithFields(logrus.Fields{
+			"error":       err,
+			"database_id": databaseID,
+		}).Error("Error reading Notion response body")
 		return Database{}, err
 	}
 
+	if res.StatusCode == http.StatusNotFound {
+		return Database{}, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		c.log.WithFields(logrus.Fields{
+			"status":      res.StatusCode,
+			"database_id": databaseID,
+		}).Error("Received non-OK HTTP status code from Notion")
+		return Database{}, fmt.Errorf("received non-OK HTTP status code: %d", res.StatusCode)
+	}
+
 	var database Database
 	err = json.Unmarshal(body, &database)
 	if err != nil {
-		fmt.Println("error unmarshalling", err)
+		c.log.WithFields(logrus.Fields{
+			"error":       err,
+			"database_id": databaseID,
+		}).Error("Error unmarshalling Notion response")
 		return Database{}, err
 	}
 
@@ -76,12 +266,19 @@ func (c *NotionClient) GetPage(ctx context.Context, pageID string) (Page, error)
 		return Page{}, err
 	}
 
-	req.Header.Set("Notion-Version", "2022-06-28")
+	req.Header.Set("Notion-Version", c.version)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
 
-	res, err := c.httpClient.Do(req)
+	if err := tokenLimiter.Wait(ctx, c.token); err != nil {
+		return Page{}, err
+	}
+
+	res, err := c.do(req, "pages.retrieve")
 	if err != nil {
-		fmt.Println("error with request", err)
+		c.log.WithFields(logrus.Fields{
+			"error":   err,
+			"page_id": pageID,
+		}).Error("Error with Notion request")
 		return Page{}, err
 	}
 
@@ -89,14 +286,35 @@ func (c *NotionClient) GetPage(ctx context.Context, pageID string) (Page, error)
 
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		fmt.Println("error reading body", err)
+		c.log.WithFields(logrus.Fields{
+			"error":   err,
+			"page_id": pageID,
+		}).Error("Error reading Notion response body")
 		return Page{}, err
 	}
 
+	if res.StatusCode == http.StatusNotFound {
+		return Page{}, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		c.log.WithFields(logrus.Fields{
+			"status":  res.StatusCode,
+			"page_id": pageID,
+		}).Error("Received non-OK HTTP status code from Notion")
+		return Page{}, fmt.Errorf("received non-OK HTTP status code: %d", res.StatusCode)
+	}
+
 	var page Page
 	err = json.Unmarshal(body, &page)
 	if err != nil {
-		fmt.Println("error unmarshalling", err)
+		c.log.WithFields(logrus.Fields{
+			"error":   err,
+			"page_id": pageID,
+		}).Error("Error unmarshalling Notion response")
+		return Page{}, err
+	}
+
+	if err := c.completeProperties(ctx, []Page{page}); err != nil {
 		return Page{}, err
 	}
 
@@ -104,74 +322,291 @@ func (c *NotionClient) GetPage(ctx context.Context, pageID string) (Page, error)
 }
 
 func (c *NotionClient) GetAllDatabasePages(ctx context.Context, databaseID string) (*DatabaseQueryResponse, error) {
+	return c.QueryDatabase(ctx, databaseID, Query{})
+}
+
+// QueryDatabase returns every page of the database matching query, in the
+// order of its sorts. Use IterateDatabase to avoid holding every page in
+// memory.
+func (c *NotionClient) QueryDatabase(ctx context.Context, databaseID string, query Query) (*DatabaseQueryResponse, error) {
 	var allPagesResults []Page
-	hasMore := true
-	nextCursor := ""
 
-	// Rate limit to 3 requests per second. Notion API has a rate limit of 3 requests per second.
-	rateLimiter := time.NewTicker(334 * time.Millisecond)
-	defer rateLimiter.Stop()
+	pages := c.IterateDatabase(ctx, databaseID, query)
+	for pages.Next() {
+		allPagesResults = append(allPagesResults, pages.Page())
+	}
+	if err := pages.Err(); err != nil {
+		return nil, err
+	}
+
+	finalResponse := DatabaseQueryResponse{
+		Object:  "list",
+		Results: allPagesResults,
+		HasMore: false,
+	}
+
+	return &finalResponse, nil
+}
+
+// IterateDatabase returns an iterator over the pages of the database
+// matching query. Results are requested one page_size at a time, as the
+// iterator reaches them, so stopping early saves the remaining requests.
+func (c *NotionClient) IterateDatabase(ctx context.Context, databaseID string, query Query) *PageIterator {
+	return &PageIterator{ctx: ctx, client: c, databaseID: databaseID, query: query}
+}
+
+// PageIterator steps through the results of a database query:
+//
+//	pages := client.IterateDatabase(ctx, databaseID, query)
+//	for pages.Next() {
+//		page := pages.Page()
+//		...
+//	}
+//	if err := pages.Err(); err != nil {
+//		...
+//	}
+type PageIterator struct {
+	ctx        context.Context
+	client     *NotionClient
+	databaseID string
+	query      Query
+
+	buffered   []Page
+	current    Page
+	nextCursor string
+	started    bool
+	err        error
+}
+
+// Next advances to the next page, requesting more results when needed. It
+// returns false when there are no more pages or a request failed.
+func (it *PageIterator) Next() bool {
+	for len(it.buffered) == 0 {
+		if it.err != nil || (it.started && it.nextCursor == "") {
+			return false
+		}
+
+		results, err := it.client.queryDatabasePage(it.ctx, it.databaseID, it.query, it.nextCursor)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if err := it.client.completeProperties(it.ctx, results.Results); err != nil {
+			it.err = err
+			return false
+		}
+
+		it.started = true
+		it.buffered = results.Results
+		it.nextCursor = ""
+		if results.HasMore {
+			it.nextCursor = results.NextCursor
+		}
+	}
+
+	it.current, it.buffered = it.buffered[0], it.buffered[1:]
+	return true
+}
+
+// Page returns the page Next advanced to.
+func (it *PageIterator) Page() Page {
+	return it.current
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *PageIterator) Err() error {
+	return it.err
+}
+
+// queryDatabasePage makes a single query request for the results starting
+// at cursor.
+func (c *NotionClient) queryDatabasePage(ctx context.Context, databaseID string, query Query, cursor string) (DatabaseQueryResponse, error) {
+	// Wait for the token's shared rate limit budget before making the request
+	if err := tokenLimiter.Wait(ctx, c.token); err != nil {
+		return DatabaseQueryResponse{}, err
+	}
+
+	jsonBody, err := query.body(cursor)
+	if err != nil {
+		return DatabaseQueryResponse{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%sdatabases/%s/query%s", c.baseURL, databaseID, query.params()), bytes.NewReader(jsonBody))
+	if err != nil {
+		c.log.WithFields(logrus.Fields{
+			"error":       err,
+			"database_id": databaseID,
+		}).Error("Error creating Notion request")
+		return DatabaseQueryResponse{}, err
+	}
+
+	req.Header.Set("Notion-Version", c.version)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := c.do(req, "databases.query")
+	if err != nil {
+		c.log.WithFields(logrus.Fields{
+			"error":       err,
+			"database_id": databaseID,
+		}).Error("Error querying Notion database")
+		return DatabaseQueryResponse{}, err
+	}
+
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.log.WithFields(logrus.Fields{
+			"error":       err,
+			"database_id": databaseID,
+		}).Error("Error reading Notion response body")
+		return DatabaseQueryResponse{}, err
+	}
 
-	for hasMore {
-		<-rateLimiter.C // Wait for the next tick before making the request
+	if res.StatusCode != http.StatusOK {
+		c.log.WithFields(logrus.Fields{
+			"status":      res.StatusCode,
+			"database_id": databaseID,
+		}).Error("Received non-OK HTTP status code from Notion")
+		return DatabaseQueryResponse{}, fmt.Errorf("received non-OK HTTP status code: %d", res.StatusCode)
+	}
 
-		var requestBody io.Reader
-		var req *http.Request
-		var err error
+	var pages DatabaseQueryResponse
+	err = json.Unmarshal(body, &pages)
+	if err != nil {
+		c.log.WithFields(logrus.Fields{
+			"error":       err,
+			"database_id": databaseID,
+		}).Error("Error unmarshalling Notion response")
+		return DatabaseQueryResponse{}, err
+	}
 
+	return pages, nil
+}
+
+// GetPageProperty reads a property's complete value from the property item
+// endpoint, following pagination, for values that a page object cuts off.
+func (c *NotionClient) GetPageProperty(ctx context.Context, pageID string, propertyID string) (PageProperty, error) {
+	var property PageProperty
+	started := false
+	nextCursor := ""
+
+	for {
+		if err := tokenLimiter.Wait(ctx, c.token); err != nil {
+			return PageProperty{}, err
+		}
+
+		query := url.Values{"page_size": {strconv.Itoa(propertyItemPageSize)}}
 		if nextCursor != "" {
-			jsonBody := []byte(fmt.Sprintf(`{"start_cursor": "%s"}`, nextCursor))
-			requestBody = bytes.NewBuffer(jsonBody)
-			req, err = http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%sdatabases/%s/query", c.baseURL, databaseID), requestBody)
-		} else {
-			req, err = http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%sdatabases/%s/query", c.baseURL, databaseID), nil)
+			query.Set("start_cursor", nextCursor)
 		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%spages/%s/properties/%s?%s", c.baseURL, pageID, propertyID, query.Encode()), nil)
 		if err != nil {
-			logging.Logger.Error(fmt.Sprintf("Error creating request: %s", err))
-			return nil, err
+			return PageProperty{}, err
 		}
 
-		req.Header.Set("Notion-Version", "2022-06-28")
+		req.Header.Set("Notion-Version", c.version)
 		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token))
-		req.Header.Set("Content-Type", "application/json")
 
-		res, err := c.httpClient.Do(req)
+		res, err := c.do(req, "pages.properties.retrieve")
 		if err != nil {
-			logging.Logger.Error(fmt.Sprintf("Error with request: %s", err))
-			return nil, err
+			c.log.WithFields(logrus.Fields{
+				"error":       err,
+				"page_id":     pageID,
+				"property_id": propertyID,
+			}).Error("Error with Notion request")
+			return PageProperty{}, err
 		}
 
-		defer res.Body.Close()
 		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
 		if err != nil {
-			fmt.Println("error reading body", err)
-			return nil, err
+			c.log.WithFields(logrus.Fields{
+				"error":       err,
+				"page_id":     pageID,
+				"property_id": propertyID,
+			}).Error("Error reading Notion response body")
+			return PageProperty{}, err
 		}
 
 		if res.StatusCode != http.StatusOK {
-			logging.Logger.Error(fmt.Sprintf("Received non-OK HTTP status code: %d", res.StatusCode))
-			return nil, fmt.Errorf("received non-OK HTTP status code: %d", res.StatusCode)
+			c.log.WithFields(logrus.Fields{
+				"status":      res.StatusCode,
+				"page_id":     pageID,
+				"property_id": propertyID,
+			}).Error("Received non-OK HTTP status code from Notion")
+			return PageProperty{}, fmt.Errorf("received non-OK HTTP status code: %d", res.StatusCode)
 		}
 
-		var pages DatabaseQueryResponse
-		err = json.Unmarshal(body, &pages)
+		// Properties that aren't paginated come back as a single item.
+		var list PropertyItemList
+		err = json.Unmarshal(body, &list)
+		if err == nil && list.Object == "property_item" {
+			var item PropertyItem
+			err = json.Unmarshal(body, &item)
+			return item.property(), err
+		}
 		if err != nil {
-			logging.Logger.Error(fmt.Sprintf("Error unmarshalling response: %s", err))
-			return nil, err
+			c.log.WithFields(logrus.Fields{
+				"error":       err,
+				"page_id":     pageID,
+				"property_id": propertyID,
+			}).Error("Error unmarshalling Notion response")
+			return PageProperty{}, err
+		}
+
+		if !started {
+			property = emptyPageProperty(list.PropertyItem.ID, list.PropertyItem.Type)
+			started = true
 		}
+		property.merge(list)
 
-		allPagesResults = append(allPagesResults, pages.Results...)
-		hasMore = pages.HasMore
-		nextCursor = pages.NextCursor
+		if !list.HasMore || list.NextCursor == nil {
+			return property, nil
+		}
+		nextCursor = *list.NextCursor
 	}
+}
 
-	finalResponse := DatabaseQueryResponse{
-		Object:  "list",
-		Results: allPagesResults,
-		HasMore: false,
+// emptyPageProperty returns a paginated property with no items yet.
+func emptyPageProperty(id string, propertyType string) PageProperty {
+	property := PageProperty{ID: id, Type: propertyType}
+	switch propertyType {
+	case PropertyTitle:
+		property.Title = &[]RichText{}
+	case PropertyRichText:
+		property.RichText = &[]RichText{}
+	case PropertyPeople:
+		property.People = &[]User{}
+	case PropertyRelation:
+		hasMore := false
+		property.Relation = &[]PageRelationProperty{}
+		property.HasMore = &hasMore
+	case PropertyRollup:
+		property.Rollup = &PageRollupProperty{Type: "array", Array: &[]PageProperty{}}
 	}
 
-	return &finalResponse, nil
+	return property
+}
+
+// completeProperties replaces every truncated property of the pages with
+// its complete value, so diffs see the whole value.
+func (c *NotionClient) completeProperties(ctx context.Context, pages []Page) error {
+	for _, page := range pages {
+		for name, property := range page.Properties {
+			if !property.Truncated() {
+				continue
+			}
+
+			complete, err := c.GetPageProperty(ctx, page.ID, property.ID)
+			if err != nil {
+				return fmt.Errorf("reading property %q of page %s: %w", name, page.ID, err)
+			}
+			page.Properties[name] = complete
+		}
+	}
+
+	return nil
 }
 
 func (c *NotionClient) GetAllDatabasePageIDs(ctx context.Context, pages *DatabaseQueryResponse) ([]string, error) {
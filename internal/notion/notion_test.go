@@ -0,0 +1,28 @@
+package notion
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"2", 2 * time.Second},
+		{"0", 0},
+		{"Mon, 01 Jan 2024 12:00:05 GMT", 5 * time.Second},
+		{"Mon, 01 Jan 2024 11:59:00 GMT", 0},
+		{"", defaultRetryAfter},
+		{"soon", defaultRetryAfter},
+		{"-1", defaultRetryAfter},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.header, now); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
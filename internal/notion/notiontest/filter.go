@@ -0,0 +1,118 @@
+package notiontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gavsidhu/notion-hooks/internal/notion"
+)
+
+// matches reports whether page matches a database query filter. It
+// understands "and", "or" and property filters with the equals,
+// does_not_equal, contains, does_not_contain, is_empty, is_not_empty and
+// number comparison conditions, evaluated against the normalized value.
+// Anything else is an error, so a test can't pass with a filter the fake
+// ignores.
+func matches(page notion.Page, raw json.RawMessage) (bool, error) {
+	var filter map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &filter); err != nil {
+		return false, err
+	}
+
+	if nested, ok := filter["and"]; ok {
+		return matchesAll(page, nested, true)
+	}
+	if nested, ok := filter["or"]; ok {
+		return matchesAll(page, nested, false)
+	}
+
+	var name string
+	if err := json.Unmarshal(filter["property"], &name); err != nil {
+		return false, fmt.Errorf("unsupported filter %s", raw)
+	}
+	property, ok := page.Properties[name]
+	if !ok {
+		return false, fmt.Errorf("no property %q", name)
+	}
+
+	for key, rawCondition := range filter {
+		if key == "property" {
+			continue
+		}
+		var condition map[string]any
+		if err := json.Unmarshal(rawCondition, &condition); err != nil || len(condition) != 1 {
+			return false, fmt.Errorf("invalid condition %s", rawCondition)
+		}
+		for operator, operand := range condition {
+			return evaluate(property.Normalize(), operator, operand)
+		}
+	}
+
+	return false, fmt.Errorf("filter on %q has no condition", name)
+}
+
+func matchesAll(page notion.Page, raw json.RawMessage, all bool) (bool, error) {
+	var filters []json.RawMessage
+	if err := json.Unmarshal(raw, &filters); err != nil {
+		return false, err
+	}
+
+	for _, filter := range filters {
+		ok, err := matches(page, filter)
+		if err != nil {
+			return false, err
+		}
+		if ok != all {
+			return ok, nil
+		}
+	}
+
+	return all, nil
+}
+
+func evaluate(value any, operator string, operand any) (bool, error) {
+	switch operator {
+	case "equals":
+		return reflect.DeepEqual(value, operand), nil
+	case "does_not_equal":
+		return !reflect.DeepEqual(value, operand), nil
+	case "contains", "does_not_contain":
+		var contains bool
+		switch value := value.(type) {
+		case string:
+			s, _ := operand.(string)
+			contains = strings.Contains(value, s)
+		case []string:
+			for _, v := range value {
+				contains = contains || v == operand
+			}
+		}
+		return contains == (operator == "contains"), nil
+	case "is_empty", "is_not_empty":
+		empty := value == nil || value == ""
+		if values, ok := value.([]string); ok {
+			empty = len(values) == 0
+		}
+		return empty == (operator == "is_empty"), nil
+	case "greater_than", "less_than", "greater_than_or_equal_to", "less_than_or_equal_to":
+		number, ok := value.(float64)
+		than, isNumber := operand.(float64)
+		if !ok || !isNumber {
+			return false, nil
+		}
+		switch operator {
+		case "greater_than":
+			return number > than, nil
+		case "less_than":
+			return number < than, nil
+		case "greater_than_or_equal_to":
+			return number >= than, nil
+		default:
+			return number <= than, nil
+		}
+	}
+
+	return false, fmt.Errorf("unsupported condition %q", operator)
+}
@@ -0,0 +1,731 @@
+// Package notiontest provides a fake Notion API server for tests.
+package notiontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/notion"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 100
+)
+
+// Block is a block object as returned by the block children endpoint.
+type Block map[string]any
+
+// Server is a fake Notion API. It serves database queries with cursor
+// pagination and simple filters, but not sorts, and databases, pages,
+// property items, block children and comments from state that tests script
+// through AddPage, EditPage, ArchivePage, AddComment and ResolveDiscussion,
+// and can inject rate limiting, latency, failing comment listings and
+// repeated query results. Page objects cut long property values off at
+// notion.PropertyValueLimit, as Notion's do.
+//
+// Every change advances the server's clock by a minute, since Notion only
+// reports last_edited_time to the minute.
+type Server struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	now       time.Time
+	databases map[string]notion.Database
+	// pageOrder keeps pages in creation order, which is the order queries
+	// return them in.
+	pageOrder []string
+	pages     map[string]notion.Page
+	blocks    map[string][]Block
+	// comments are kept in creation order. Those in resolved discussions
+	// are left out of listings, as Notion does.
+	comments []notion.Comment
+	resolved map[string]bool
+	// failingComments are the pages whose comment listings fail.
+	failingComments map[string]bool
+	// repeatedPages are returned twice by database queries.
+	repeatedPages map[string]bool
+	pageSize      int
+	latency       time.Duration
+	limited       int
+	requests      map[string]int
+}
+
+// NewServer starts a fake Notion server. Close it when the test is done.
+func NewServer() *Server {
+	s := &Server{
+		now:             time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		databases:       make(map[string]notion.Database),
+		pages:           make(map[string]notion.Page),
+		blocks:          make(map[string][]Block),
+		resolved:        make(map[string]bool),
+		failingComments: make(map[string]bool),
+		repeatedPages:   make(map[string]bool),
+		pageSize:        defaultPageSize,
+		requests:        make(map[string]int),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// URL returns the API root to pass to notion.WithBaseURL.
+func (s *Server) URL() string {
+	return s.server.URL + "/v1/"
+}
+
+// Options returns the client options that point a NotionClient at s.
+func (s *Server) Options() []notion.Option {
+	return []notion.Option{
+		notion.WithBaseURL(s.URL()),
+		notion.WithHTTPClient(s.server.Client()),
+	}
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetPageSize caps how many results a list response holds when the request
+// doesn't ask for fewer.
+func (s *Server) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pageSize = n
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = d
+}
+
+// RateLimitNext answers the next n requests with 429 Too Many Requests and
+// a Retry-After of one second.
+func (s *Server) RateLimitNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limited = n
+}
+
+// SetCommentsFailing makes listing the comments on pageID fail with 500
+// Internal Server Error, or succeed again when failing is false.
+func (s *Server) SetCommentsFailing(pageID string, failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failingComments[pageID] = failing
+}
+
+// SetPageRepeated makes database queries return pageID twice in a row, as
+// Notion can when a page is edited while a query pages through it, or only
+// once again when repeated is false.
+func (s *Server) SetPageRepeated(pageID string, repeated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.repeatedPages[pageID] = repeated
+}
+
+// Requests returns how many requests were made to endpoint, which is one
+// of "databases.query", "databases.retrieve", "pages.retrieve",
+// "pages.properties.retrieve", "blocks.children" and "comments.list",
+// including rate limited ones.
+func (s *Server) Requests(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[endpoint]
+}
+
+// AddDatabase creates a database and returns it. A missing ID is generated.
+func (s *Server) AddDatabase(database notion.Database) notion.Database {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if database.ID == "" {
+		database.ID = uuid.New().String()
+	}
+	database.Object = "database"
+	database.CreatedTime = s.tick()
+	database.LastEditedTime = database.CreatedTime
+	s.databases[database.ID] = database
+
+	return database
+}
+
+// AddPage creates a page in the database and returns it. A missing ID is
+// generated, and a preset created_time is kept so tests can add pages that
+// existed before a webhook was created.
+func (s *Server) AddPage(databaseID string, page notion.Page) notion.Page {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if page.ID == "" {
+		page.ID = uuid.New().String()
+	}
+	page.Object = "page"
+	page.Parent = notion.Parent{Type: "database_id", DatabaseID: &databaseID}
+	page.LastEditedTime = s.tick()
+	if page.CreatedTime == "" {
+		page.CreatedTime = page.LastEditedTime
+	}
+	page.Archived = false
+
+	if _, ok := s.pages[page.ID]; !ok {
+		s.pageOrder = append(s.pageOrder, page.ID)
+	}
+	s.pages[page.ID] = page
+
+	return page
+}
+
+// EditPage applies edit to a page and bumps its last_edited_time. It
+// panics if the page doesn't exist.
+func (s *Server) EditPage(pageID string, edit func(page *notion.Page)) notion.Page {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.pages[pageID]
+	if !ok {
+		panic(fmt.Sprintf("notiontest: no page %s", pageID))
+	}
+
+	edit(&page)
+	page.LastEditedTime = s.tick()
+	s.pages[pageID] = page
+
+	return page
+}
+
+// ArchivePage moves a page to the trash, which removes it from database
+// queries.
+func (s *Server) ArchivePage(pageID string) {
+	s.EditPage(pageID, func(page *notion.Page) {
+		page.Archived = true
+	})
+}
+
+// AddBlockChildren appends blocks to the children of a page or block. A
+// block without an id is given one.
+func (s *Server) AddBlockChildren(parentID string, blocks ...Block) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, block := range blocks {
+		if _, ok := block["id"]; !ok {
+			block["id"] = uuid.New().String()
+		}
+		block["object"] = "block"
+		s.blocks[parentID] = append(s.blocks[parentID], block)
+	}
+}
+
+// AddComment adds a comment to a page and returns it. A comment without a
+// discussion ID starts a new discussion. It panics if the page doesn't
+// exist.
+func (s *Server) AddComment(pageID string, comment notion.Comment) notion.Comment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pages[pageID]; !ok {
+		panic(fmt.Sprintf("notiontest: no page %s", pageID))
+	}
+
+	if comment.ID == "" {
+		comment.ID = uuid.New().String()
+	}
+	if comment.DiscussionID == "" {
+		comment.DiscussionID = uuid.New().String()
+	}
+	comment.Object = "comment"
+	comment.Parent = notion.Parent{Type: "page_id", PageID: &pageID}
+	comment.CreatedTime = s.tick()
+	comment.LastEditedTime = comment.CreatedTime
+	s.comments = append(s.comments, comment)
+
+	return comment
+}
+
+// ResolveDiscussion resolves a discussion, which hides its comments.
+func (s *Server) ResolveDiscussion(discussionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resolved[discussionID] = true
+}
+
+// tick advances the clock and returns the new time as Notion formats it.
+// s.mu must be held.
+func (s *Server) tick() string {
+	s.now = s.now.Add(time.Minute)
+	return s.now.Format("2006-01-02T15:04:05.000Z")
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// Property IDs are URL encoded and may contain an encoded slash, so the
+	// path is split before it is decoded.
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/v1/"), "/"), "/")
+	for i, part := range path {
+		if unescaped, err := url.PathUnescape(part); err == nil {
+			path[i] = unescaped
+		}
+	}
+
+	var endpoint string
+	var handle func(w http.ResponseWriter, r *http.Request, id string)
+	switch {
+	case len(path) == 3 && path[0] == "databases" && path[2] == "query" && r.Method == http.MethodPost:
+		endpoint, handle = "databases.query", s.queryDatabase
+	case len(path) == 2 && path[0] == "databases" && r.Method == http.MethodGet:
+		endpoint, handle = "databases.retrieve", s.getDatabase
+	case len(path) == 2 && path[0] == "pages" && r.Method == http.MethodGet:
+		endpoint, handle = "pages.retrieve", s.getPage
+	case len(path) == 4 && path[0] == "pages" && path[2] == "properties" && r.Method == http.MethodGet:
+		endpoint, handle = "pages.properties.retrieve", func(w http.ResponseWriter, r *http.Request, pageID string) {
+			s.getPageProperty(w, r, pageID, path[3])
+		}
+	case len(path) == 3 && path[0] == "blocks" && path[2] == "children" && r.Method == http.MethodGet:
+		endpoint, handle = "blocks.children", s.getBlockChildren
+	case len(path) == 1 && path[0] == "comments" && r.Method == http.MethodGet:
+		// The block is given in the query rather than the path.
+		path = append(path, r.URL.Query().Get("block_id"))
+		endpoint, handle = "comments.list", s.listComments
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_request_url", "Invalid request URL.")
+		return
+	}
+
+	s.mu.Lock()
+	s.requests[endpoint]++
+	latency := s.latency
+	limited := s.limited > 0
+	if limited {
+		s.limited--
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if limited {
+		w.Header().Set("Retry-After", "1")
+		writeError(w, http.StatusTooManyRequests, "rate_limited", "You have been rate limited. Please try again in a few minutes.")
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "API token is invalid.")
+		return
+	}
+	if r.Header.Get("Notion-Version") == "" {
+		writeError(w, http.StatusBadRequest, "missing_version", "Notion-Version header failed validation.")
+		return
+	}
+
+	handle(w, r, path[1])
+}
+
+type listResponse struct {
+	Object     string  `json:"object"`
+	Results    any     `json:"results"`
+	NextCursor *string `json:"next_cursor"`
+	HasMore    bool    `json:"has_more"`
+	Type       string  `json:"type"`
+}
+
+func (s *Server) queryDatabase(w http.ResponseWriter, r *http.Request, databaseID string) {
+	var body struct {
+		StartCursor string          `json:"start_cursor"`
+		PageSize    int             `json:"page_size"`
+		Filter      json.RawMessage `json:"filter"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_json", "Error parsing JSON body.")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.databases[databaseID]; !ok {
+		writeError(w, http.StatusNotFound, "object_not_found", fmt.Sprintf("Could not find database with ID: %s.", databaseID))
+		return
+	}
+
+	var ids []string
+	for _, id := range s.pageOrder {
+		page := s.pages[id]
+		if page.Archived || page.Parent.DatabaseID == nil || *page.Parent.DatabaseID != databaseID {
+			continue
+		}
+		if len(body.Filter) > 0 {
+			ok, err := matches(page, body.Filter)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "validation_error", fmt.Sprintf("body failed validation: %s.", err))
+				return
+			}
+			if !ok {
+				continue
+			}
+		}
+		ids = append(ids, id)
+		if s.repeatedPages[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	start, end, next, ok := s.window(ids, body.StartCursor, body.PageSize)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "validation_error", "start_cursor is invalid.")
+		return
+	}
+
+	properties := r.URL.Query()["filter_properties"]
+	results := make([]notion.Page, 0, end-start)
+	for _, id := range ids[start:end] {
+		results = append(results, truncated(onlyProperties(s.pages[id], properties)))
+	}
+
+	writeJSON(w, http.StatusOK, listResponse{Object: "list", Results: results, NextCursor: next, HasMore: next != nil, Type: "page_or_database"})
+}
+
+func (s *Server) getDatabase(w http.ResponseWriter, r *http.Request, databaseID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	database, ok := s.databases[databaseID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "object_not_found", fmt.Sprintf("Could not find database with ID: %s.", databaseID))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, database)
+}
+
+func (s *Server) getPage(w http.ResponseWriter, r *http.Request, pageID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.pages[pageID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "object_not_found", fmt.Sprintf("Could not find page with ID: %s.", pageID))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, truncated(page))
+}
+
+// getPageProperty serves the property item endpoint. Like Notion, title,
+// rich_text, people, relation and rollup values are paginated one
+// reference per item, and other types come back as a single item.
+func (s *Server) getPageProperty(w http.ResponseWriter, r *http.Request, pageID string, propertyID string) {
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.pages[pageID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "object_not_found", fmt.Sprintf("Could not find page with ID: %s.", pageID))
+		return
+	}
+
+	var property notion.PageProperty
+	found := false
+	for name, p := range page.Properties {
+		if id, err := url.PathUnescape(p.ID); p.ID == propertyID || (err == nil && id == propertyID) || name == propertyID {
+			property, found = p, true
+			break
+		}
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "object_not_found", fmt.Sprintf("Could not find property with ID: %s.", propertyID))
+		return
+	}
+
+	items, paginated := propertyItems(property)
+	if !paginated {
+		writeJSON(w, http.StatusOK, notion.PropertyItem{Object: "property_item", PageProperty: property})
+		return
+	}
+
+	ids := make([]string, len(items))
+	for i := range items {
+		ids[i] = strconv.Itoa(i)
+	}
+	start, end, next, ok := s.window(ids, r.URL.Query().Get("start_cursor"), pageSize)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "validation_error", "start_cursor is invalid.")
+		return
+	}
+
+	list := notion.PropertyItemList{
+		Object:     "list",
+		Results:    items[start:end],
+		NextCursor: next,
+		HasMore:    next != nil,
+		Type:       "property_item",
+	}
+	list.PropertyItem.ID = property.ID
+	list.PropertyItem.Type = property.Type
+	if property.Rollup != nil {
+		rollup := *property.Rollup
+		rollup.Array = &[]notion.PageProperty{}
+		list.PropertyItem.Rollup = &rollup
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// propertyItems splits a paginated property's value into one item per
+// reference and reports whether the property's type is paginated.
+func propertyItems(property notion.PageProperty) ([]notion.PropertyItem, bool) {
+	items := []notion.PropertyItem{}
+	item := func() notion.PropertyItem {
+		return notion.PropertyItem{Object: "property_item", PageProperty: notion.PageProperty{ID: property.ID, Type: property.Type}}
+	}
+
+	switch property.Type {
+	case notion.PropertyTitle, notion.PropertyRichText:
+		text := property.Title
+		if property.Type == notion.PropertyRichText {
+			text = property.RichText
+		}
+		if text != nil {
+			for i := range *text {
+				it := item()
+				if property.Type == notion.PropertyTitle {
+					it.Title = &(*text)[i]
+				} else {
+					it.RichText = &(*text)[i]
+				}
+				items = append(items, it)
+			}
+		}
+	case notion.PropertyPeople:
+		if property.People != nil {
+			for i := range *property.People {
+				it := item()
+				it.People = &(*property.People)[i]
+				items = append(items, it)
+			}
+		}
+	case notion.PropertyRelation:
+		if property.Relation != nil {
+			for i := range *property.Relation {
+				it := item()
+				it.Relation = &(*property.Relation)[i]
+				items = append(items, it)
+			}
+		}
+	case notion.PropertyRollup:
+		if property.Rollup != nil && property.Rollup.Array != nil {
+			for _, value := range *property.Rollup.Array {
+				values, paginated := propertyItems(value)
+				if !paginated {
+					values = []notion.PropertyItem{{Object: "property_item", PageProperty: value}}
+				}
+				items = append(items, values...)
+			}
+		}
+	default:
+		return nil, false
+	}
+
+	return items, true
+}
+
+// onlyProperties returns page with only the properties whose IDs are in
+// ids, as filter_properties does, or page unchanged if ids is empty.
+func onlyProperties(page notion.Page, ids []string) notion.Page {
+	if len(ids) == 0 || page.Properties == nil {
+		return page
+	}
+
+	properties := make(map[string]notion.PageProperty)
+	for name, property := range page.Properties {
+		unescaped, _ := url.PathUnescape(property.ID)
+		for _, id := range ids {
+			if id == property.ID || id == unescaped {
+				properties[name] = property
+			}
+		}
+	}
+	page.Properties = properties
+
+	return page
+}
+
+// truncated returns page with its property values cut off at
+// notion.PropertyValueLimit, as page objects are, and has_more set on
+// relations that were cut.
+func truncated(page notion.Page) notion.Page {
+	if page.Properties == nil {
+		return page
+	}
+
+	properties := make(map[string]notion.PageProperty, len(page.Properties))
+	for name, property := range page.Properties {
+		if property.Title != nil {
+			property.Title = truncate(*property.Title)
+		}
+		if property.RichText != nil {
+			property.RichText = truncate(*property.RichText)
+		}
+		if property.People != nil {
+			property.People = truncate(*property.People)
+		}
+		if property.Relation != nil {
+			hasMore := len(*property.Relation) > notion.PropertyValueLimit
+			property.Relation = truncate(*property.Relation)
+			property.HasMore = &hasMore
+		}
+		if property.Rollup != nil && property.Rollup.Array != nil {
+			rollup := *property.Rollup
+			rollup.Array = truncate(*rollup.Array)
+			property.Rollup = &rollup
+		}
+		properties[name] = property
+	}
+	page.Properties = properties
+
+	return page
+}
+
+func truncate[T any](values []T) *[]T {
+	if len(values) > notion.PropertyValueLimit {
+		values = values[:notion.PropertyValueLimit]
+	}
+	values = append([]T{}, values...)
+
+	return &values
+}
+
+func (s *Server) getBlockChildren(w http.ResponseWriter, r *http.Request, blockID string) {
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	blocks := s.blocks[blockID]
+	if _, ok := s.pages[blockID]; !ok && blocks == nil {
+		writeError(w, http.StatusNotFound, "object_not_found", fmt.Sprintf("Could not find block with ID: %s.", blockID))
+		return
+	}
+
+	ids := make([]string, len(blocks))
+	for i, block := range blocks {
+		ids[i], _ = block["id"].(string)
+	}
+
+	start, end, next, ok := s.window(ids, r.URL.Query().Get("start_cursor"), pageSize)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "validation_error", "start_cursor is invalid.")
+		return
+	}
+
+	results := append([]Block{}, blocks[start:end]...)
+	writeJSON(w, http.StatusOK, listResponse{Object: "list", Results: results, NextCursor: next, HasMore: next != nil, Type: "block"})
+}
+
+func (s *Server) listComments(w http.ResponseWriter, r *http.Request, blockID string) {
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pages[blockID]; !ok && s.blocks[blockID] == nil {
+		writeError(w, http.StatusNotFound, "object_not_found", fmt.Sprintf("Could not find block with ID: %s.", blockID))
+		return
+	}
+	if s.failingComments[blockID] {
+		writeError(w, http.StatusInternalServerError, "internal_server_error", "Unexpected error occurred.")
+		return
+	}
+
+	var comments []notion.Comment
+	var ids []string
+	for _, comment := range s.comments {
+		if s.resolved[comment.DiscussionID] || comment.Parent.PageID == nil || *comment.Parent.PageID != blockID {
+			continue
+		}
+		comments = append(comments, comment)
+		ids = append(ids, comment.ID)
+	}
+
+	start, end, next, ok := s.window(ids, r.URL.Query().Get("start_cursor"), pageSize)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "validation_error", "start_cursor is invalid.")
+		return
+	}
+
+	results := append([]notion.Comment{}, comments[start:end]...)
+	writeJSON(w, http.StatusOK, listResponse{Object: "list", Results: results, NextCursor: next, HasMore: next != nil, Type: "comment"})
+}
+
+// window returns the slice of ids for one page of results. Like Notion, the
+// cursor is the ID of the first result on the page. A cursor on a
+// repeated page resolves to its last occurrence, so paging can't loop back
+// to it. s.mu must be held.
+func (s *Server) window(ids []string, cursor string, pageSize int) (start int, end int, next *string, ok bool) {
+	if pageSize <= 0 || pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	if pageSize > s.pageSize {
+		pageSize = s.pageSize
+	}
+
+	if cursor != "" {
+		start = -1
+		for i := len(ids) - 1; i >= 0; i-- {
+			if ids[i] == cursor {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			return 0, 0, nil, false
+		}
+	}
+
+	end = start + pageSize
+	if end >= len(ids) {
+		return start, len(ids), nil, true
+	}
+
+	return start, end, &ids[end], true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeJSON(w, status, map[string]any{
+		"object":  "error",
+		"status":  status,
+		"code":    code,
+		"message": message,
+	})
+}
@@ -0,0 +1,354 @@
+package notiontest_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/notion"
+	"github.com/gavsidhu/notion-hooks/internal/notion/notiontest"
+)
+
+func newClient(t *testing.T, server *notiontest.Server) *notion.NotionClient {
+	t.Helper()
+
+	// The client rate limits per token, so each test gets its own.
+	return notion.NewNotionClient("secret_"+t.Name(), server.Options()...)
+}
+
+func TestQueryPaginatesWithCursors(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+	server.SetPageSize(2)
+
+	database := server.AddDatabase(notion.Database{})
+	var want []string
+	for i := 0; i < 5; i++ {
+		want = append(want, server.AddPage(database.ID, notion.Page{}).ID)
+	}
+
+	pages, err := newClient(t, server).GetAllDatabasePages(context.Background(), database.ID)
+	if err != nil {
+		t.Fatalf("GetAllDatabasePages: %v", err)
+	}
+
+	if len(pages.Results) != len(want) {
+		t.Fatalf("got %d pages, want %d", len(pages.Results), len(want))
+	}
+	for i, page := range pages.Results {
+		if page.ID != want[i] {
+			t.Errorf("page %d: got %s, want %s", i, page.ID, want[i])
+		}
+	}
+	if got := server.Requests("databases.query"); got != 3 {
+		t.Errorf("got %d query requests, want 3", got)
+	}
+}
+
+func TestScriptedChanges(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+
+	database := server.AddDatabase(notion.Database{})
+	kept := server.AddPage(database.ID, notion.Page{})
+	archived := server.AddPage(database.ID, notion.Page{})
+
+	edited := server.EditPage(kept.ID, func(page *notion.Page) {
+		page.URL = "https://www.notion.so/edited"
+	})
+	if edited.LastEditedTime == kept.LastEditedTime {
+		t.Errorf("editing a page didn't change its last_edited_time")
+	}
+	server.ArchivePage(archived.ID)
+
+	client := newClient(t, server)
+	pages, err := client.GetAllDatabasePages(context.Background(), database.ID)
+	if err != nil {
+		t.Fatalf("GetAllDatabasePages: %v", err)
+	}
+	if len(pages.Results) != 1 || pages.Results[0].ID != kept.ID {
+		t.Fatalf("got %+v, want only %s", pages.Results, kept.ID)
+	}
+	if pages.Results[0].URL != "https://www.notion.so/edited" {
+		t.Errorf("got URL %q, want the edited one", pages.Results[0].URL)
+	}
+
+	page, err := client.GetPage(context.Background(), archived.ID)
+	if err != nil {
+		t.Fatalf("GetPage: %v", err)
+	}
+	if !page.Archived {
+		t.Errorf("archived page is not archived")
+	}
+}
+
+func TestRateLimitedRequestsAreRetried(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+
+	database := server.AddDatabase(notion.Database{})
+	server.AddPage(database.ID, notion.Page{})
+	server.RateLimitNext(2)
+
+	// A query has a body, which has to be sent again with each retry.
+	client := newClient(t, server)
+	start := time.Now()
+	res, err := client.GetAllDatabasePages(context.Background(), database.ID)
+	if err != nil {
+		t.Fatalf("query after the rate limit: %v", err)
+	}
+	if len(res.Results) != 1 {
+		t.Errorf("got %d pages, want 1", len(res.Results))
+	}
+	if got := server.Requests("databases.query"); got != 3 {
+		t.Errorf("got %d requests, want the query retried twice", got)
+	}
+	// The fake server asks for a one second wait.
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Errorf("retried after %s, want Retry-After honoured", elapsed)
+	}
+}
+
+func TestRateLimitRetriesAreBounded(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+
+	database := server.AddDatabase(notion.Database{})
+	server.RateLimitNext(4)
+
+	client := newClient(t, server)
+	if _, err := client.GetDatabase(context.Background(), database.ID); err == nil {
+		t.Fatalf("expected a request rate limited past the retries to fail")
+	}
+	if got := server.Requests("databases.retrieve"); got != 4 {
+		t.Errorf("got %d requests, want one and three retries", got)
+	}
+	if _, err := client.GetDatabase(context.Background(), database.ID); err != nil {
+		t.Fatalf("request after the rate limit: %v", err)
+	}
+}
+
+func TestLatencyInjection(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+
+	database := server.AddDatabase(notion.Database{})
+	server.SetLatency(time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := newClient(t, server).GetAllDatabasePages(ctx, database.ID); err == nil {
+		t.Fatalf("expected the query to time out")
+	}
+}
+
+func TestBlockChildren(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+
+	database := server.AddDatabase(notion.Database{})
+	page := server.AddPage(database.ID, notion.Page{})
+	server.AddBlockChildren(page.ID, notiontest.Block{"type": "paragraph"}, notiontest.Block{"type": "to_do"})
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL()+"blocks/"+page.ID+"/children?page_size=1", nil)
+	req.Header.Set("Authorization", "Bearer secret_test")
+	req.Header.Set("Notion-Version", notion.DefaultVersion)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET block children: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", res.StatusCode)
+	}
+
+	var children struct {
+		Results []notiontest.Block `json:"results"`
+		HasMore bool               `json:"has_more"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&children); err != nil {
+		t.Fatalf("decoding block children: %v", err)
+	}
+	if len(children.Results) != 1 || children.Results[0]["type"] != "paragraph" || !children.HasMore {
+		t.Errorf("got %+v, want the first block and more to come", children)
+	}
+}
+
+func TestTruncatedPropertiesAreCompleted(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+	server.SetPageSize(20)
+
+	var relation []notion.PageRelationProperty
+	var people []notion.User
+	var title []notion.RichText
+	var owners []notion.PageProperty
+	for i := 0; i < 30; i++ {
+		relation = append(relation, notion.PageRelationProperty{ID: fmt.Sprintf("related-%d", i)})
+		people = append(people, notion.User{Object: "user", ID: fmt.Sprintf("user-%d", i)})
+		title = append(title, notion.RichText{Type: "text", PlainText: fmt.Sprintf("part %d ", i)})
+		owners = append(owners, notion.PageProperty{Type: notion.PropertyPeople, People: &[]notion.User{{Object: "user", ID: fmt.Sprintf("owner-%d", i)}}})
+	}
+	hasMore := true
+
+	database := server.AddDatabase(notion.Database{})
+	server.AddPage(database.ID, notion.Page{Properties: map[string]notion.PageProperty{
+		"Name":      {ID: "title", Type: notion.PropertyTitle, Title: &title},
+		"Tasks":     {ID: "%3B%5C%5B%3F", Type: notion.PropertyRelation, Relation: &relation, HasMore: &hasMore},
+		"Assignees": {ID: "FlgQ", Type: notion.PropertyPeople, People: &people},
+		"Owners":    {ID: "Ko%60X", Type: notion.PropertyRollup, Rollup: &notion.PageRollupProperty{Type: "array", Array: &owners, Function: "show_original"}},
+		"Estimate":  {ID: "WPj%5E", Type: notion.PropertyNumber},
+	}})
+
+	pages, err := newClient(t, server).GetAllDatabasePages(context.Background(), database.ID)
+	if err != nil {
+		t.Fatalf("GetAllDatabasePages: %v", err)
+	}
+
+	props := pages.Results[0].Properties
+	if ids, _ := props["Tasks"].AsRelation(); len(ids) != 30 || ids[29] != "related-29" {
+		t.Errorf("got %d related pages, want 30", len(ids))
+	}
+	if props["Tasks"].HasMore == nil || *props["Tasks"].HasMore {
+		t.Errorf("completed relation still has more")
+	}
+	if users, _ := props["Assignees"].AsPeople(); len(users) != 30 {
+		t.Errorf("got %d people, want 30", len(users))
+	}
+	if text, _ := props["Name"].AsText(); text != notion.PlainText(title) {
+		t.Errorf("got title %q, want %q", text, notion.PlainText(title))
+	}
+	if rollup, _ := props["Owners"].AsRollup(); rollup.Array == nil || len(*rollup.Array) != 30 || rollup.Function != "show_original" {
+		t.Errorf("got rollup %+v, want 30 values", rollup)
+	}
+
+	// Four truncated properties, each read in two pages of twenty. The
+	// number isn't truncated, so it isn't read.
+	if got := server.Requests("pages.properties.retrieve"); got != 8 {
+		t.Errorf("got %d property item requests, want 8", got)
+	}
+}
+
+func TestIteratorStopsEarly(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+
+	database := server.AddDatabase(notion.Database{})
+	for i := 0; i < 5; i++ {
+		server.AddPage(database.ID, notion.Page{})
+	}
+
+	pages := newClient(t, server).IterateDatabase(context.Background(), database.ID, notion.Query{}.PageSize(2))
+	seen := 0
+	for seen < 3 && pages.Next() {
+		seen++
+	}
+	if err := pages.Err(); err != nil {
+		t.Fatalf("Err: %v", err)
+	}
+	if seen != 3 {
+		t.Fatalf("got %d pages, want 3", seen)
+	}
+	if got := server.Requests("databases.query"); got != 2 {
+		t.Errorf("got %d query requests for 3 pages of 2, want 2", got)
+	}
+}
+
+func TestQueryFilterAndProperties(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+
+	team := func(name string) map[string]notion.PageProperty {
+		return map[string]notion.PageProperty{
+			"Team":     {ID: "t%3Dm", Type: notion.PropertySelect, Select: &notion.PageSelectProperty{Name: name}},
+			"Estimate": {ID: "est", Type: notion.PropertyNumber},
+		}
+	}
+	database := server.AddDatabase(notion.Database{})
+	platform := server.AddPage(database.ID, notion.Page{Properties: team("Platform")})
+	server.AddPage(database.ID, notion.Page{Properties: team("Growth")})
+
+	query := notion.Query{}.
+		Filter(notion.Where("Team").Select().Equals("Platform")).
+		FilterProperties("t=m")
+	pages, err := newClient(t, server).QueryDatabase(context.Background(), database.ID, query)
+	if err != nil {
+		t.Fatalf("QueryDatabase: %v", err)
+	}
+
+	if len(pages.Results) != 1 || pages.Results[0].ID != platform.ID {
+		t.Fatalf("got %+v, want only %s", pages.Results, platform.ID)
+	}
+	if _, ok := pages.Results[0].Properties["Estimate"]; ok || len(pages.Results[0].Properties) != 1 {
+		t.Errorf("got properties %v, want only Team", pages.Results[0].Properties)
+	}
+}
+
+func TestListComments(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+	server.SetPageSize(2)
+
+	database := server.AddDatabase(notion.Database{})
+	page := server.AddPage(database.ID, notion.Page{})
+	other := server.AddPage(database.ID, notion.Page{})
+
+	first := server.AddComment(page.ID, notion.Comment{})
+	reply := server.AddComment(page.ID, notion.Comment{DiscussionID: first.DiscussionID})
+	resolved := server.AddComment(page.ID, notion.Comment{})
+	last := server.AddComment(page.ID, notion.Comment{})
+	server.AddComment(other.ID, notion.Comment{})
+	server.ResolveDiscussion(resolved.DiscussionID)
+
+	comments, err := newClient(t, server).ListComments(context.Background(), page.ID)
+	if err != nil {
+		t.Fatalf("ListComments: %v", err)
+	}
+
+	var got []string
+	for _, comment := range comments {
+		got = append(got, comment.ID)
+	}
+	want := []string{first.ID, reply.ID, last.ID}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got comments %v, want %v", got, want)
+	}
+	if got := server.Requests("comments.list"); got != 2 {
+		t.Errorf("got %d list requests for 3 comments in pages of 2, want 2", got)
+	}
+}
+
+func TestRepeatedPage(t *testing.T) {
+	server := notiontest.NewServer()
+	defer server.Close()
+	server.SetPageSize(2)
+
+	database := server.AddDatabase(notion.Database{})
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, server.AddPage(database.ID, notion.Page{}).ID)
+	}
+	server.SetPageRepeated(ids[1], true)
+
+	pages, err := newClient(t, server).GetAllDatabasePages(context.Background(), database.ID)
+	if err != nil {
+		t.Fatalf("GetAllDatabasePages: %v", err)
+	}
+
+	var got []string
+	for _, page := range pages.Results {
+		got = append(got, page.ID)
+	}
+	// The cursor lands on the repeat, so the second page of results starts
+	// with it.
+	want := []string{ids[0], ids[1], ids[1], ids[2]}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got pages %v, want %v", got, want)
+	}
+}
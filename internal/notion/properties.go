@@ -0,0 +1,438 @@
+package notion
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page property value types, as in PageProperty.Type.
+const (
+	PropertyButton         = "button"
+	PropertyCheckbox       = "checkbox"
+	PropertyCreatedBy      = "created_by"
+	PropertyCreatedTime    = "created_time"
+	PropertyDate           = "date"
+	PropertyEmail          = "email"
+	PropertyFiles          = "files"
+	PropertyFormula        = "formula"
+	PropertyLastEditedBy   = "last_edited_by"
+	PropertyLastEditedTime = "last_edited_time"
+	PropertyMultiSelect    = "multi_select"
+	PropertyNumber         = "number"
+	PropertyPeople         = "people"
+	PropertyPhoneNumber    = "phone_number"
+	PropertyRelation       = "relation"
+	PropertyRichText       = "rich_text"
+	PropertyRollup         = "rollup"
+	PropertySelect         = "select"
+	PropertyStatus         = "status"
+	PropertyTitle          = "title"
+	PropertyUniqueID       = "unique_id"
+	PropertyURL            = "url"
+	PropertyVerification   = "verification"
+)
+
+type PageDateProperty struct {
+	Start    string  `json:"start"`
+	End      *string `json:"end"`
+	TimeZone *string `json:"time_zone"`
+}
+
+type PageFormulaProperty struct {
+	Type    string            `json:"type"`
+	Boolean *bool             `json:"boolean,omitempty"`
+	Date    *PageDateProperty `json:"date,omitempty"`
+	String  *string           `json:"string,omitempty"`
+	Number  *float64          `json:"number,omitempty"`
+}
+
+type PageRelationProperty struct {
+	ID string `json:"id"`
+}
+
+// PageRollupProperty is a rollup's result. Array holds the rolled up
+// property values, which have a type but no ID.
+type PageRollupProperty struct {
+	Type        string            `json:"type"`
+	Number      *float64          `json:"number,omitempty"`
+	Date        *PageDateProperty `json:"date,omitempty"`
+	Array       *[]PageProperty   `json:"array,omitempty"`
+	Incomplete  *struct{}         `json:"incomplete,omitempty"`
+	Unsupported *struct{}         `json:"unsupported,omitempty"`
+	Function    string            `json:"function"`
+}
+
+// PageSelectProperty is a chosen option of a select, multi_select or status
+// property.
+type PageSelectProperty struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// PageFile is a file attached to a files property, either uploaded to
+// Notion or linked externally.
+type PageFile struct {
+	Name     string        `json:"name"`
+	Type     string        `json:"type"`
+	File     *File         `json:"file,omitempty"`
+	External *ExternalFile `json:"external,omitempty"`
+}
+
+type UniqueID struct {
+	Number int     `json:"number"`
+	Prefix *string `json:"prefix"`
+}
+
+type PageVerificationProperty struct {
+	State      string            `json:"state"`
+	VerifiedBy *PartialUser      `json:"verified_by"`
+	Date       *PageDateProperty `json:"date"`
+}
+
+type PageButtonProperty struct{}
+
+// PageProperty is the value of one property of a page. Type names the
+// field that holds the value; the field is nil when the property is empty.
+type PageProperty struct {
+	ID             string                    `json:"id,omitempty"`
+	Type           string                    `json:"type"`
+	Button         *PageButtonProperty       `json:"button,omitempty"`
+	Checkbox       *bool                     `json:"checkbox,omitempty"`
+	CreatedBy      *PartialUser              `json:"created_by,omitempty"`
+	CreatedTime    *string                   `json:"created_time,omitempty"`
+	Date           *PageDateProperty         `json:"date,omitempty"`
+	Email          *string                   `json:"email,omitempty"`
+	Files          *[]PageFile               `json:"files,omitempty"`
+	Formula        *PageFormulaProperty      `json:"formula,omitempty"`
+	LastEditedBy   *PartialUser              `json:"last_edited_by,omitempty"`
+	LastEditedTime *string                   `json:"last_edited_time,omitempty"`
+	MultiSelect    *[]PageSelectProperty     `json:"multi_select,omitempty"`
+	Number         *float64                  `json:"number,omitempty"`
+	People         *[]User                   `json:"people,omitempty"`
+	PhoneNumber    *string                   `json:"phone_number,omitempty"`
+	Relation       *[]PageRelationProperty   `json:"relation,omitempty"`
+	HasMore        *bool                     `json:"has_more,omitempty"`
+	Rollup         *PageRollupProperty       `json:"rollup,omitempty"`
+	RichText       *[]RichText               `json:"rich_text,omitempty"`
+	Select         *PageSelectProperty       `json:"select,omitempty"`
+	Status         *PageSelectProperty       `json:"status,omitempty"`
+	Title          *[]RichText               `json:"title,omitempty"`
+	UniqueID       *UniqueID                 `json:"unique_id,omitempty"`
+	URL            *string                   `json:"url,omitempty"`
+	Verification   *PageVerificationProperty `json:"verification,omitempty"`
+}
+
+// The As accessors return the property's value and whether the property is
+// of that type and not empty.
+
+// AsText returns the plain text of a title or rich_text property.
+func (p PageProperty) AsText() (string, bool) {
+	var text *[]RichText
+	switch p.Type {
+	case PropertyTitle:
+		text = p.Title
+	case PropertyRichText:
+		text = p.RichText
+	}
+	if text == nil {
+		return "", false
+	}
+
+	return PlainText(*text), true
+}
+
+func (p PageProperty) AsNumber() (float64, bool) {
+	if p.Type != PropertyNumber || p.Number == nil {
+		return 0, false
+	}
+
+	return *p.Number, true
+}
+
+func (p PageProperty) AsCheckbox() (bool, bool) {
+	if p.Type != PropertyCheckbox || p.Checkbox == nil {
+		return false, false
+	}
+
+	return *p.Checkbox, true
+}
+
+func (p PageProperty) AsSelect() (PageSelectProperty, bool) {
+	if p.Type != PropertySelect || p.Select == nil {
+		return PageSelectProperty{}, false
+	}
+
+	return *p.Select, true
+}
+
+func (p PageProperty) AsStatus() (PageSelectProperty, bool) {
+	if p.Type != PropertyStatus || p.Status == nil {
+		return PageSelectProperty{}, false
+	}
+
+	return *p.Status, true
+}
+
+func (p PageProperty) AsMultiSelect() ([]PageSelectProperty, bool) {
+	if p.Type != PropertyMultiSelect || p.MultiSelect == nil {
+		return nil, false
+	}
+
+	return *p.MultiSelect, true
+}
+
+func (p PageProperty) AsDate() (PageDateProperty, bool) {
+	if p.Type != PropertyDate || p.Date == nil {
+		return PageDateProperty{}, false
+	}
+
+	return *p.Date, true
+}
+
+func (p PageProperty) AsPeople() ([]User, bool) {
+	if p.Type != PropertyPeople || p.People == nil {
+		return nil, false
+	}
+
+	return *p.People, true
+}
+
+func (p PageProperty) AsFiles() ([]PageFile, bool) {
+	if p.Type != PropertyFiles || p.Files == nil {
+		return nil, false
+	}
+
+	return *p.Files, true
+}
+
+// AsRelation returns the IDs of the related pages. Only the first 25 are
+// included when HasMore is set.
+func (p PageProperty) AsRelation() ([]string, bool) {
+	if p.Type != PropertyRelation || p.Relation == nil {
+		return nil, false
+	}
+
+	ids := make([]string, len(*p.Relation))
+	for i, relation := range *p.Relation {
+		ids[i] = relation.ID
+	}
+
+	return ids, true
+}
+
+func (p PageProperty) AsURL() (string, bool) {
+	return stringValue(p, PropertyURL, p.URL)
+}
+
+func (p PageProperty) AsEmail() (string, bool) {
+	return stringValue(p, PropertyEmail, p.Email)
+}
+
+func (p PageProperty) AsPhoneNumber() (string, bool) {
+	return stringValue(p, PropertyPhoneNumber, p.PhoneNumber)
+}
+
+func (p PageProperty) AsFormula() (PageFormulaProperty, bool) {
+	if p.Type != PropertyFormula || p.Formula == nil {
+		return PageFormulaProperty{}, false
+	}
+
+	return *p.Formula, true
+}
+
+func (p PageProperty) AsRollup() (PageRollupProperty, bool) {
+	if p.Type != PropertyRollup || p.Rollup == nil {
+		return PageRollupProperty{}, false
+	}
+
+	return *p.Rollup, true
+}
+
+func (p PageProperty) AsUniqueID() (UniqueID, bool) {
+	if p.Type != PropertyUniqueID || p.UniqueID == nil {
+		return UniqueID{}, false
+	}
+
+	return *p.UniqueID, true
+}
+
+func (p PageProperty) AsVerification() (PageVerificationProperty, bool) {
+	if p.Type != PropertyVerification || p.Verification == nil {
+		return PageVerificationProperty{}, false
+	}
+
+	return *p.Verification, true
+}
+
+// AsTime returns the value of a created_time or last_edited_time property.
+func (p PageProperty) AsTime() (time.Time, bool) {
+	var value *string
+	switch p.Type {
+	case PropertyCreatedTime:
+		value = p.CreatedTime
+	case PropertyLastEditedTime:
+		value = p.LastEditedTime
+	}
+	if value == nil {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// AsUser returns the user of a created_by or last_edited_by property.
+func (p PageProperty) AsUser() (PartialUser, bool) {
+	var user *PartialUser
+	switch p.Type {
+	case PropertyCreatedBy:
+		user = p.CreatedBy
+	case PropertyLastEditedBy:
+		user = p.LastEditedBy
+	}
+	if user == nil {
+		return PartialUser{}, false
+	}
+
+	return *user, true
+}
+
+func stringValue(p PageProperty, propertyType string, value *string) (string, bool) {
+	if p.Type != propertyType || value == nil {
+		return "", false
+	}
+
+	return *value, true
+}
+
+// String formats the ID as Notion shows it, e.g. "TASK-12".
+func (u UniqueID) String() string {
+	if u.Prefix == nil || *u.Prefix == "" {
+		return strconv.Itoa(u.Number)
+	}
+
+	return *u.Prefix + "-" + strconv.Itoa(u.Number)
+}
+
+// PlainText joins the plain text of each part of a rich text value.
+func PlainText(text []RichText) string {
+	var b strings.Builder
+	for _, part := range text {
+		b.WriteString(part.PlainText)
+	}
+
+	return b.String()
+}
+
+// PropertyValueLimit is how many references a title, rich_text, people,
+// relation or rollup value holds in a page object. Longer values are cut
+// off and have to be read from the property item endpoint.
+const PropertyValueLimit = 25
+
+// Truncated reports whether the value may have been cut off at
+// PropertyValueLimit. Notion only flags this for relations, so other
+// values that hit the limit exactly are assumed to be truncated.
+func (p PageProperty) Truncated() bool {
+	switch p.Type {
+	case PropertyRelation:
+		return p.HasMore != nil && *p.HasMore
+	case PropertyTitle:
+		return p.Title != nil && len(*p.Title) >= PropertyValueLimit
+	case PropertyRichText:
+		return p.RichText != nil && len(*p.RichText) >= PropertyValueLimit
+	case PropertyPeople:
+		return p.People != nil && len(*p.People) >= PropertyValueLimit
+	case PropertyRollup:
+		return p.Rollup != nil && p.Rollup.Array != nil && len(*p.Rollup.Array) >= PropertyValueLimit
+	}
+
+	return false
+}
+
+// PropertyItem is one result of the property item endpoint. Items of the
+// paginated types hold a single reference, which shadows the list field of
+// the same name in PageProperty.
+type PropertyItem struct {
+	Object string `json:"object"`
+	PageProperty
+	Title    *RichText             `json:"title,omitempty"`
+	RichText *RichText             `json:"rich_text,omitempty"`
+	People   *User                 `json:"people,omitempty"`
+	Relation *PageRelationProperty `json:"relation,omitempty"`
+}
+
+// PropertyItemList is a page of a paginated property's items.
+type PropertyItemList struct {
+	Object       string         `json:"object"`
+	Results      []PropertyItem `json:"results"`
+	NextCursor   *string        `json:"next_cursor"`
+	HasMore      bool           `json:"has_more"`
+	Type         string         `json:"type"`
+	PropertyItem struct {
+		ID      string              `json:"id"`
+		NextURL *string             `json:"next_url"`
+		Type    string              `json:"type"`
+		Rollup  *PageRollupProperty `json:"rollup,omitempty"`
+	} `json:"property_item"`
+}
+
+// property returns the item as a page property value, turning a single
+// reference into a one element list.
+func (item PropertyItem) property() PageProperty {
+	p := item.PageProperty
+	switch {
+	case item.Title != nil:
+		p.Title = &[]RichText{*item.Title}
+	case item.RichText != nil:
+		p.RichText = &[]RichText{*item.RichText}
+	case item.People != nil:
+		p.People = &[]User{*item.People}
+	case item.Relation != nil:
+		p.Relation = &[]PageRelationProperty{*item.Relation}
+	}
+
+	return p
+}
+
+// merge appends the items of a page of results to p, which has the
+// property's ID and type.
+func (p *PageProperty) merge(list PropertyItemList) {
+	for _, item := range list.Results {
+		switch p.Type {
+		case PropertyTitle:
+			if item.Title != nil {
+				*p.Title = append(*p.Title, *item.Title)
+			}
+		case PropertyRichText:
+			if item.RichText != nil {
+				*p.RichText = append(*p.RichText, *item.RichText)
+			}
+		case PropertyPeople:
+			if item.People != nil {
+				*p.People = append(*p.People, *item.People)
+			}
+		case PropertyRelation:
+			if item.Relation != nil {
+				*p.Relation = append(*p.Relation, *item.Relation)
+			}
+		case PropertyRollup:
+			*p.Rollup.Array = append(*p.Rollup.Array, item.property())
+		}
+	}
+
+	// The rollup's type, function and, for number and date rollups, its
+	// value come with every page; the last one is the complete result.
+	if p.Type == PropertyRollup && list.PropertyItem.Rollup != nil {
+		array := p.Rollup.Array
+		*p.Rollup = *list.PropertyItem.Rollup
+		if p.Rollup.Type == "array" {
+			p.Rollup.Array = array
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package notion
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func loadPageFixture(t *testing.T) Page {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/page.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var page Page
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&page); err != nil {
+		t.Fatalf("decoding fixture: %v", err)
+	}
+
+	return page
+}
+
+func TestPagePropertyAccessors(t *testing.T) {
+	props := loadPageFixture(t).Properties
+
+	if got, ok := props["Name"].AsText(); !ok || got != "Crash on login" {
+		t.Errorf("Name: got %q, %t", got, ok)
+	}
+	if got, ok := props["Status"].AsStatus(); !ok || got.Name != "In progress" {
+		t.Errorf("Status: got %+v, %t", got, ok)
+	}
+	if _, ok := props["Status"].AsSelect(); ok {
+		t.Errorf("Status: AsSelect succeeded on a status property")
+	}
+	if _, ok := props["Component"].AsSelect(); ok {
+		t.Errorf("Component: AsSelect succeeded on an empty select")
+	}
+	if got, ok := props["Tags"].AsMultiSelect(); !ok || len(got) != 2 || got[1].Name != "regression" {
+		t.Errorf("Tags: got %+v, %t", got, ok)
+	}
+	if got, ok := props["Estimate"].AsNumber(); !ok || got != 3.5 {
+		t.Errorf("Estimate: got %v, %t", got, ok)
+	}
+	if _, ok := props["Story points"].AsNumber(); ok {
+		t.Errorf("Story points: AsNumber succeeded on an empty number")
+	}
+	if got, ok := props["Window"].AsDate(); !ok || got.End == nil || *got.End != "2024-03-05T17:00:00.000-05:00" {
+		t.Errorf("Window: got %+v, %t", got, ok)
+	}
+	if got, ok := props["Blocked"].AsCheckbox(); !ok || got {
+		t.Errorf("Blocked: got %t, %t", got, ok)
+	}
+	if got, ok := props["Assignees"].AsPeople(); !ok || len(got) != 1 || got[0].Person == nil || got[0].Person.Email != "ada@example.com" {
+		t.Errorf("Assignees: got %+v, %t", got, ok)
+	}
+	if got, ok := props["Attachments"].AsFiles(); !ok || len(got) != 2 || got[0].File == nil || got[1].External == nil {
+		t.Errorf("Attachments: got %+v, %t", got, ok)
+	}
+	if got, ok := props["Epic"].AsRelation(); !ok || !reflect.DeepEqual(got, []string{"dd456007-6c66-4bba-957e-ea501dcda3a6"}) {
+		t.Errorf("Epic: got %v, %t", got, ok)
+	}
+	if got, ok := props["Reporter email"].AsEmail(); !ok || got != "grace@example.com" {
+		t.Errorf("Reporter email: got %q, %t", got, ok)
+	}
+	if got, ok := props["Epic owners"].AsRollup(); !ok || got.Array == nil || (*got.Array)[0].Type != PropertyPeople {
+		t.Errorf("Epic owners: got %+v, %t", got, ok)
+	}
+	if got, ok := props["Overdue"].AsFormula(); !ok || got.Boolean == nil || !*got.Boolean {
+		t.Errorf("Overdue: got %+v, %t", got, ok)
+	}
+	if got, ok := props["Updated"].AsTime(); !ok || !got.Equal(time.Date(2024, 3, 4, 17, 42, 0, 0, time.UTC)) {
+		t.Errorf("Updated: got %s, %t", got, ok)
+	}
+	if got, ok := props["Created by"].AsUser(); !ok || got.ID != "ee5f0f84-409a-440f-983a-a5315961c6e4" {
+		t.Errorf("Created by: got %+v, %t", got, ok)
+	}
+	if got, ok := props["ID"].AsUniqueID(); !ok || got.String() != "BUG-42" {
+		t.Errorf("ID: got %+v, %t", got, ok)
+	}
+	if got, ok := props["Verification"].AsVerification(); !ok || got.State != "verified" || got.VerifiedBy == nil {
+		t.Errorf("Verification: got %+v, %t", got, ok)
+	}
+}
+
+func TestNormalizeProperties(t *testing.T) {
+	page := loadPageFixture(t)
+
+	got, err := json.MarshalIndent(NormalizeProperties(page.Properties), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/page.normalized.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(bytes.TrimSpace(got), bytes.TrimSpace(want)) {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+// Signed file URLs change on every request, so they must not change the
+// normalized value.
+func TestNormalizeIgnoresFileURLSignature(t *testing.T) {
+	attachments := loadPageFixture(t).Properties["Attachments"]
+	before := attachments.Normalize()
+
+	files := append([]PageFile(nil), *attachments.Files...)
+	files[0].File = &File{URL: "https://prod-files-secure.s3.us-west-2.amazonaws.com/crash.log?X-Amz-Signature=def456", ExpiryTime: "2024-03-04T19:42:00.000Z"}
+	attachments.Files = &files
+
+	if after := attachments.Normalize(); !reflect.DeepEqual(before, after) {
+		t.Errorf("got %v after the URL was re-signed, want %v", after, before)
+	}
+}
+
+func TestPagePropertyRoundTrip(t *testing.T) {
+	page := loadPageFixture(t)
+
+	data, err := json.Marshal(page.Properties)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]PageProperty
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(NormalizeProperties(decoded), NormalizeProperties(page.Properties)) {
+		t.Errorf("properties changed after a round trip through JSON")
+	}
+}
@@ -0,0 +1,507 @@
+package notion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// maxFilterDepth is how deeply Notion lets compound filters nest inside a
+// top-level "and" or "or".
+const maxFilterDepth = 2
+
+// Query narrows and orders a database query. The zero value returns every
+// page with every property, in Notion's default order. Its methods return a
+// changed copy, so queries can be built up from a shared base:
+//
+//	query := notion.Query{}.
+//		Filter(notion.And(
+//			notion.Where("Team").Select().Equals("Platform"),
+//			notion.WhereLastEditedTime().PastWeek(),
+//		)).
+//		Sort(notion.SortByProperty("Priority", notion.SortDescending)).
+//		PageSize(50)
+type Query struct {
+	filter           *Filter
+	sorts            []Sort
+	pageSize         int
+	filterProperties []string
+}
+
+// Filter replaces the query's filter.
+func (q Query) Filter(filter Filter) Query {
+	q.filter = &filter
+	return q
+}
+
+// Sort adds sorts after the query's existing ones.
+func (q Query) Sort(sorts ...Sort) Query {
+	q.sorts = append(append([]Sort(nil), q.sorts...), sorts...)
+	return q
+}
+
+// PageSize sets how many pages each request returns, at most 100.
+func (q Query) PageSize(n int) Query {
+	q.pageSize = n
+	return q
+}
+
+// FilterProperties limits the properties returned to the ones with the
+// given IDs.
+func (q Query) FilterProperties(propertyIDs ...string) Query {
+	q.filterProperties = append(append([]string(nil), q.filterProperties...), propertyIDs...)
+	return q
+}
+
+// body returns the request body for the page of results starting at
+// cursor.
+func (q Query) body(cursor string) ([]byte, error) {
+	return json.Marshal(struct {
+		Filter      *Filter `json:"filter,omitempty"`
+		Sorts       []Sort  `json:"sorts,omitempty"`
+		StartCursor string  `json:"start_cursor,omitempty"`
+		PageSize    int     `json:"page_size,omitempty"`
+	}{q.filter, q.sorts, cursor, q.pageSize})
+}
+
+// params returns the URL query string of the request, which is where
+// Notion takes filter_properties.
+func (q Query) params() string {
+	if len(q.filterProperties) == 0 {
+		return ""
+	}
+
+	params := url.Values{"filter_properties": q.filterProperties}
+	return "?" + params.Encode()
+}
+
+// conditionValue is the kind of value a filter condition takes.
+type conditionValue int
+
+const (
+	textCondition conditionValue = iota
+	numberCondition
+	boolCondition
+	trueCondition
+	dateCondition
+	emptyCondition
+	optionCondition
+)
+
+var dateConditions = map[string]conditionValue{
+	"equals": dateCondition, "before": dateCondition, "after": dateCondition, "on_or_before": dateCondition, "on_or_after": dateCondition,
+	"past_week": emptyCondition, "past_month": emptyCondition, "past_year": emptyCondition, "this_week": emptyCondition,
+	"next_week": emptyCondition, "next_month": emptyCondition, "next_year": emptyCondition,
+	"is_empty": trueCondition, "is_not_empty": trueCondition,
+}
+
+var numberConditions = map[string]conditionValue{
+	"equals": numberCondition, "does_not_equal": numberCondition,
+	"greater_than": numberCondition, "less_than": numberCondition,
+	"greater_than_or_equal_to": numberCondition, "less_than_or_equal_to": numberCondition,
+	"is_empty": trueCondition, "is_not_empty": trueCondition,
+}
+
+var textConditions = map[string]conditionValue{
+	"equals": textCondition, "does_not_equal": textCondition,
+	"contains": textCondition, "does_not_contain": textCondition,
+	"starts_with": textCondition, "ends_with": textCondition,
+	"is_empty": trueCondition, "is_not_empty": trueCondition,
+}
+
+var checkboxConditions = map[string]conditionValue{
+	"equals": boolCondition, "does_not_equal": boolCondition,
+}
+
+var referenceConditions = map[string]conditionValue{
+	"contains": textCondition, "does_not_contain": textCondition,
+	"is_empty": trueCondition, "is_not_empty": trueCondition,
+}
+
+// filterConditions lists the conditions of each filter key.
+var filterConditions = map[string]map[string]conditionValue{
+	"rich_text":    textConditions,
+	"title":        textConditions,
+	"url":          textConditions,
+	"email":        textConditions,
+	"phone_number": textConditions,
+	"number":       numberConditions,
+	"unique_id":    numberConditions,
+	"checkbox":     checkboxConditions,
+	"select": {
+		"equals": optionCondition, "does_not_equal": optionCondition,
+		"is_empty": trueCondition, "is_not_empty": trueCondition,
+	},
+	"status": {
+		"equals": optionCondition, "does_not_equal": optionCondition,
+		"is_empty": trueCondition, "is_not_empty": trueCondition,
+	},
+	"multi_select": {
+		"contains": optionCondition, "does_not_contain": optionCondition,
+		"is_empty": trueCondition, "is_not_empty": trueCondition,
+	},
+	"date":             dateConditions,
+	"created_time":     dateConditions,
+	"last_edited_time": dateConditions,
+	"people":           referenceConditions,
+	"created_by":       referenceConditions,
+	"last_edited_by":   referenceConditions,
+	"relation":         referenceConditions,
+	"files": {
+		"is_empty": trueCondition, "is_not_empty": trueCondition,
+	},
+}
+
+// filterKeys lists the filter keys each property type accepts besides its
+// own type.
+var filterKeys = map[string][]string{
+	PropertyTitle:          {"rich_text"},
+	PropertyURL:            {"rich_text"},
+	PropertyEmail:          {"rich_text"},
+	PropertyPhoneNumber:    {"rich_text"},
+	PropertyCreatedTime:    {"date"},
+	PropertyLastEditedTime: {"date"},
+	PropertyCreatedBy:      {"people"},
+	PropertyLastEditedBy:   {"people"},
+}
+
+// formulaFilterKeys maps the result types of a formula filter to the
+// conditions they take.
+var formulaFilterKeys = map[string]string{
+	"string":   "rich_text",
+	"number":   "number",
+	"checkbox": "checkbox",
+	"date":     "date",
+}
+
+// ValidateQuery checks a filter and sorts against the database's schema,
+// so a webhook can't be saved with a query Notion would reject. Either may
+// be empty. Every problem found is returned, joined.
+func ValidateQuery(database Database, filter json.RawMessage, sorts json.RawMessage) error {
+	v := queryValidator{database: database}
+	if !isEmptyJSON(filter) {
+		v.filter("filter", filter, 0)
+	}
+	if !isEmptyJSON(sorts) {
+		v.sorts("sorts", sorts)
+	}
+
+	return errors.Join(v.errs...)
+}
+
+type queryValidator struct {
+	database Database
+	errs     []error
+}
+
+func (v *queryValidator) errorf(path string, format string, args ...any) {
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+func (v *queryValidator) filter(path string, raw json.RawMessage, depth int) {
+	var filter map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &filter); err != nil || filter == nil {
+		v.errorf(path, "must be an object")
+		return
+	}
+
+	for _, compound := range []string{"and", "or"} {
+		if _, ok := filter[compound]; !ok {
+			continue
+		}
+		if len(filter) != 1 {
+			v.errorf(path, "%q can't be combined with other keys", compound)
+			return
+		}
+		if depth > maxFilterDepth {
+			v.errorf(path, "compound filters can only be nested %d levels deep", maxFilterDepth)
+			return
+		}
+
+		var filters []json.RawMessage
+		if err := json.Unmarshal(filter[compound], &filters); err != nil {
+			v.errorf(path+"."+compound, "must be an array of filters")
+			return
+		}
+		for i, nested := range filters {
+			v.filter(fmt.Sprintf("%s.%s[%d]", path, compound, i), nested, depth+1)
+		}
+		return
+	}
+
+	if timestamp, ok := filter["timestamp"]; ok {
+		v.timestampFilter(path, timestamp, filter)
+		return
+	}
+
+	rawName, ok := filter["property"]
+	if !ok {
+		v.errorf(path, `must have "and", "or", "property" or "timestamp"`)
+		return
+	}
+	var name string
+	if err := json.Unmarshal(rawName, &name); err != nil {
+		v.errorf(path+".property", "must be a string")
+		return
+	}
+	property, ok := v.property(name)
+	if !ok {
+		v.errorf(path+".property", "database has no property %q", name)
+		return
+	}
+
+	key, condition, ok := v.singleKey(path, filter, "property")
+	if !ok {
+		return
+	}
+	if !acceptsFilterKey(property.Type, key) {
+		v.errorf(path, "a %s property can't be filtered with %q", property.Type, key)
+		return
+	}
+	v.propertyCondition(path+"."+key, property, key, condition)
+}
+
+func (v *queryValidator) timestampFilter(path string, rawTimestamp json.RawMessage, filter map[string]json.RawMessage) {
+	var timestamp string
+	if err := json.Unmarshal(rawTimestamp, &timestamp); err != nil || (timestamp != "created_time" && timestamp != "last_edited_time") {
+		v.errorf(path+".timestamp", `must be "created_time" or "last_edited_time"`)
+		return
+	}
+
+	key, condition, ok := v.singleKey(path, filter, "timestamp")
+	if !ok {
+		return
+	}
+	if key != timestamp {
+		v.errorf(path, "a %s filter needs a %q condition, not %q", timestamp, timestamp, key)
+		return
+	}
+	v.condition(path+"."+key, dateConditions, condition, nil)
+}
+
+// singleKey returns the only key of filter besides the one naming what it
+// filters.
+func (v *queryValidator) singleKey(path string, filter map[string]json.RawMessage, nameKey string) (string, json.RawMessage, bool) {
+	var keys []string
+	for key := range filter {
+		if key != nameKey {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) != 1 {
+		sort.Strings(keys)
+		v.errorf(path, "must have exactly one condition besides %q, got %v", nameKey, keys)
+		return "", nil, false
+	}
+
+	return keys[0], filter[keys[0]], true
+}
+
+func (v *queryValidator) propertyCondition(path string, property DatabaseProperty, key string, raw json.RawMessage) {
+	switch key {
+	case PropertyFormula:
+		v.formulaCondition(path, raw)
+	case PropertyRollup:
+		v.rollupCondition(path, raw)
+	default:
+		v.condition(path, filterConditions[key], raw, propertyOptions(property))
+	}
+}
+
+func (v *queryValidator) formulaCondition(path string, raw json.RawMessage) {
+	var formula map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &formula); err != nil || len(formula) != 1 {
+		v.errorf(path, `must have exactly one of "string", "number", "checkbox" or "date"`)
+		return
+	}
+
+	for resultType, condition := range formula {
+		key, ok := formulaFilterKeys[resultType]
+		if !ok {
+			v.errorf(path, "unknown formula result type %q", resultType)
+			return
+		}
+		v.condition(path+"."+resultType, filterConditions[key], condition, nil)
+	}
+}
+
+// rollupCondition checks a rollup filter. The rolled up property lives in
+// another database, so option names in it can't be checked.
+func (v *queryValidator) rollupCondition(path string, raw json.RawMessage) {
+	var rollup map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &rollup); err != nil || len(rollup) != 1 {
+		v.errorf(path, `must have exactly one of "any", "every", "none", "number" or "date"`)
+		return
+	}
+
+	for kind, condition := range rollup {
+		switch kind {
+		case "number", "date":
+			v.condition(path+"."+kind, filterConditions[kind], condition, nil)
+		case "any", "every", "none":
+			var nested map[string]json.RawMessage
+			if err := json.Unmarshal(condition, &nested); err != nil || len(nested) != 1 {
+				v.errorf(path+"."+kind, "must have exactly one property condition")
+				return
+			}
+			for key, nestedCondition := range nested {
+				conditions, ok := filterConditions[key]
+				if !ok {
+					v.errorf(path+"."+kind, "unknown property condition %q", key)
+					return
+				}
+				v.condition(path+"."+kind+"."+key, conditions, nestedCondition, nil)
+			}
+		default:
+			v.errorf(path, "unknown rollup condition %q", kind)
+		}
+	}
+}
+
+// condition checks that raw is a single condition from conditions with a
+// value of the right kind. When options isn't nil, option values must be
+// one of its names.
+func (v *queryValidator) condition(path string, conditions map[string]conditionValue, raw json.RawMessage, options []string) {
+	var condition map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &condition); err != nil || len(condition) != 1 {
+		v.errorf(path, "must have exactly one condition")
+		return
+	}
+
+	for name, value := range condition {
+		kind, ok := conditions[name]
+		if !ok {
+			v.errorf(path, "unknown condition %q", name)
+			return
+		}
+		path += "." + name
+
+		switch kind {
+		case textCondition, dateCondition, optionCondition:
+			var s string
+			if err := json.Unmarshal(value, &s); err != nil {
+				v.errorf(path, "must be a string")
+				return
+			}
+			if kind == optionCondition && options != nil && !contains(options, s) {
+				v.errorf(path, "no option named %q", s)
+			}
+		case numberCondition:
+			var n float64
+			if err := json.Unmarshal(value, &n); err != nil {
+				v.errorf(path, "must be a number")
+			}
+		case boolCondition:
+			var b bool
+			if err := json.Unmarshal(value, &b); err != nil {
+				v.errorf(path, "must be true or false")
+			}
+		case trueCondition:
+			var b bool
+			if err := json.Unmarshal(value, &b); err != nil || !b {
+				v.errorf(path, "must be true")
+			}
+		case emptyCondition:
+			var o map[string]json.RawMessage
+			if err := json.Unmarshal(value, &o); err != nil || o == nil || len(o) != 0 {
+				v.errorf(path, "must be an empty object")
+			}
+		}
+	}
+}
+
+func (v *queryValidator) sorts(path string, raw json.RawMessage) {
+	var sorts []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &sorts); err != nil {
+		v.errorf(path, "must be an array of sorts")
+		return
+	}
+
+	for i, sort := range sorts {
+		sortPath := fmt.Sprintf("%s[%d]", path, i)
+
+		var direction string
+		if err := json.Unmarshal(sort["direction"], &direction); err != nil || (direction != "ascending" && direction != "descending") {
+			v.errorf(sortPath+".direction", `must be "ascending" or "descending"`)
+		}
+
+		rawName, byProperty := sort["property"]
+		rawTimestamp, byTimestamp := sort["timestamp"]
+		switch {
+		case byProperty == byTimestamp:
+			v.errorf(sortPath, `must have exactly one of "property" or "timestamp"`)
+		case byProperty:
+			var name string
+			if err := json.Unmarshal(rawName, &name); err != nil {
+				v.errorf(sortPath+".property", "must be a string")
+			} else if _, ok := v.property(name); !ok {
+				v.errorf(sortPath+".property", "database has no property %q", name)
+			}
+		default:
+			var timestamp string
+			if err := json.Unmarshal(rawTimestamp, &timestamp); err != nil || (timestamp != "created_time" && timestamp != "last_edited_time") {
+				v.errorf(sortPath+".timestamp", `must be "created_time" or "last_edited_time"`)
+			}
+		}
+	}
+}
+
+// property looks a property up by name or, as Notion allows, by ID.
+func (v *queryValidator) property(name string) (DatabaseProperty, bool) {
+	if property, ok := v.database.Properties[name]; ok {
+		return property, true
+	}
+	for _, property := range v.database.Properties {
+		if property.ID == name {
+			return property, true
+		}
+	}
+
+	return DatabaseProperty{}, false
+}
+
+func acceptsFilterKey(propertyType string, key string) bool {
+	return key == propertyType || contains(filterKeys[propertyType], key)
+}
+
+// propertyOptions returns the option names of a select, status or
+// multi-select property, or nil for any other property.
+func propertyOptions(property DatabaseProperty) []string {
+	names := []string{}
+	switch {
+	case property.Type == PropertySelect && property.Select != nil:
+		for _, option := range property.Select.Options {
+			names = append(names, option.Name)
+		}
+	case property.Type == PropertyStatus && property.Status != nil:
+		for _, option := range property.Status.Options {
+			names = append(names, option.Name)
+		}
+	case property.Type == PropertyMultiSelect && property.MultiSelect != nil:
+		for _, option := range property.MultiSelect.Options {
+			names = append(names, option.Name)
+		}
+	default:
+		return nil
+	}
+
+	return names
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isEmptyJSON(raw json.RawMessage) bool {
+	trimmed := strings.TrimSpace(string(raw))
+	return trimmed == "" || trimmed == "null" || trimmed == "{}"
+}
@@ -0,0 +1,125 @@
+package notion
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateQuery(t *testing.T) {
+	database := Database{Properties: map[string]DatabaseProperty{
+		"Name":     {ID: "title", Type: PropertyTitle},
+		"Team":     {ID: "t%3Dm", Type: PropertySelect, Select: &SelectProperty{Options: []SelectPropertyOption{{Name: "Platform"}, {Name: "Growth"}}}},
+		"Tags":     {ID: "tg", Type: PropertyMultiSelect, MultiSelect: &MultiSelectProperty{Options: []MultiSelectPropertyOption{{Name: "bug"}}}},
+		"Estimate": {ID: "est", Type: PropertyNumber},
+		"Done":     {ID: "dn", Type: PropertyCheckbox},
+		"Overdue":  {ID: "ov", Type: PropertyFormula},
+	}}
+
+	tests := []struct {
+		name    string
+		filter  string
+		sorts   string
+		wantErr string
+	}{
+		{name: "empty"},
+		{name: "select", filter: `{"property": "Team", "select": {"equals": "Platform"}}`},
+		{name: "property ID", filter: `{"property": "t%3Dm", "select": {"is_empty": true}}`},
+		{name: "title as rich text", filter: `{"property": "Name", "rich_text": {"contains": "crash"}}`},
+		{name: "compound", filter: `{"and": [{"property": "Done", "checkbox": {"equals": false}}, {"or": [{"property": "Estimate", "number": {"greater_than": 3}}, {"property": "Tags", "multi_select": {"contains": "bug"}}]}]}`},
+		{name: "timestamp", filter: `{"timestamp": "last_edited_time", "last_edited_time": {"past_week": {}}}`},
+		{name: "formula", filter: `{"property": "Overdue", "formula": {"checkbox": {"equals": true}}}`},
+		{name: "sorts", sorts: `[{"property": "Estimate", "direction": "descending"}, {"timestamp": "created_time", "direction": "ascending"}]`},
+		{name: "unknown property", filter: `{"property": "Owner", "people": {"is_empty": true}}`, wantErr: `database has no property "Owner"`},
+		{name: "wrong type", filter: `{"property": "Team", "status": {"equals": "Platform"}}`, wantErr: `a select property can't be filtered with "status"`},
+		{name: "unknown option", filter: `{"property": "Team", "select": {"equals": "Sales"}}`, wantErr: `no option named "Sales"`},
+		{name: "unknown condition", filter: `{"property": "Estimate", "number": {"contains": 3}}`, wantErr: `unknown condition "contains"`},
+		{name: "wrong value", filter: `{"property": "Estimate", "number": {"equals": "3"}}`, wantErr: "filter.number.equals: must be a number"},
+		{name: "too deep", filter: `{"and": [{"or": [{"and": [{"or": []}]}]}]}`, wantErr: "nested 2 levels deep"},
+		{name: "bad direction", sorts: `[{"property": "Estimate", "direction": "up"}]`, wantErr: "sorts[0].direction"},
+		{name: "every problem", filter: `{"or": [{"property": "Owner", "people": {"is_empty": true}}, {"property": "Team", "select": {"equals": "Sales"}}]}`, wantErr: `database has no property "Owner"` + "\n" + `filter.or[1].select.equals: no option named "Sales"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateQuery(database, json.RawMessage(tt.filter), json.RawMessage(tt.sorts))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestQueryBody(t *testing.T) {
+	query := Query{}.
+		Filter(And(
+			Where("Team").Select().Equals("Platform"),
+			Or(
+				Where("Estimate").Number().GreaterThanOrEqualTo(3),
+				Where("Overdue").Formula().Checkbox().Equals(true),
+			),
+			WhereLastEditedTime().After(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)),
+		)).
+		Sort(SortByProperty("Estimate", SortDescending)).
+		Sort(SortByTimestamp("created_time", SortAscending)).
+		PageSize(50).
+		FilterProperties("title", "t%3Dm")
+
+	body, err := query.body("cursor-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+		"filter": {"and": [
+			{"property": "Team", "select": {"equals": "Platform"}},
+			{"or": [
+				{"property": "Estimate", "number": {"greater_than_or_equal_to": 3}},
+				{"property": "Overdue", "formula": {"checkbox": {"equals": true}}}
+			]},
+			{"timestamp": "last_edited_time", "last_edited_time": {"after": "2024-03-01T09:00:00Z"}}
+		]},
+		"sorts": [
+			{"property": "Estimate", "direction": "descending"},
+			{"timestamp": "created_time", "direction": "ascending"}
+		],
+		"start_cursor": "cursor-1",
+		"page_size": 50
+	}`
+	var got, wantValue any
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, wantValue) {
+		t.Errorf("got body %s", body)
+	}
+
+	if got, want := query.params(), "?filter_properties=title&filter_properties=t%253Dm"; got != want {
+		t.Errorf("got params %q, want %q", got, want)
+	}
+	if body, _ := (Query{}).body(""); string(body) != "{}" {
+		t.Errorf("got body %s for the zero query, want {}", body)
+	}
+
+	// A built filter passes the same validation as stored ones.
+	database := Database{Properties: map[string]DatabaseProperty{
+		"Team":     {Type: PropertySelect, Select: &SelectProperty{Options: []SelectPropertyOption{{Name: "Platform"}}}},
+		"Estimate": {Type: PropertyNumber},
+		"Overdue":  {Type: PropertyFormula},
+	}}
+	filter, _ := json.Marshal(query.filter)
+	sorts, _ := json.Marshal(query.sorts)
+	if err := ValidateQuery(database, filter, sorts); err != nil {
+		t.Errorf("ValidateQuery: %v", err)
+	}
+}
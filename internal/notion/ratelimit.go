@@ -0,0 +1,53 @@
+package notion
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Notion allows an average of three requests per second per integration
+// token. Every client created for the same token shares one budget, so
+// concurrent polls for a user queue behind each other instead of tripping
+// the API's rate limit.
+var tokenLimiter = newRateLimiter(334 * time.Millisecond)
+
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     map[string]time.Time
+}
+
+func newRateLimiter(interval time.Duration) *rateLimiter {
+	return &rateLimiter{
+		interval: interval,
+		next:     make(map[string]time.Time),
+	}
+}
+
+// Wait blocks until key may make another request or ctx is done.
+func (l *rateLimiter) Wait(ctx context.Context, key string) error {
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next[key]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[key] = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
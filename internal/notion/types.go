@@ -248,79 +248,6 @@ type DatabaseQueryResponse struct {
 	PageOrDatabase struct{} `json:"page_or_database"`
 }
 
-// Page Structs
-
-type PageDateProperty struct {
-	Start    string  `json:"start"`
-	End      *string `json:"end"`
-	Timezone *string `json:"timezone,omitempty"`
-}
-
-type PageFormulaProperty struct {
-	Type    string            `json:"type"`
-	Boolean *bool             `json:"boolean,omitempty"`
-	Date    *PageDateProperty `json:"date,omitempty"`
-	String  *string           `json:"string,omitempty"`
-	Number  *float64          `json:"number,omitempty"`
-}
-
-type PageRelationProperty struct {
-	ID string `json:"id"`
-}
-
-type PageRollupProperty struct {
-	Type        string            `json:"type"`
-	Number      *float64          `json:"number,omitempty"`
-	Date        *PageDateProperty `json:"date,omitempty"`
-	Array       *[]string         `json:"array,omitempty"`
-	Incomplete  *bool             `json:"incomplete,omitempty"`
-	Unsupported *interface{}      `json:"unsupported,omitempty"`
-	Function    string            `json:"function"`
-}
-
-type PageSelectProperty struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Color string `json:"color"`
-}
-
-type UniqueID struct {
-	Number int     `json:"number"`
-	Prefix *string `json:"prefix,omitempty"`
-}
-
-type Version struct {
-	State      string            `json:"state"`
-	VerfiyedBy *User             `json:"verified_by,omitempty"`
-	Date       *PageDateProperty `json:"date,omitempty"`
-}
-
-type PageProperty struct {
-	ID             string                  `json:"id"`
-	Type           *string                 `json:"type,omitempty"`
-	Checkbox       *bool                   `json:"checkbox,omitempty"`
-	CreatedBy      *PartialUser            `json:"created_by,omitempty"`
-	CreatedTime    *string                 `json:"created_time,omitempty"`
-	Date           *PageDateProperty       `json:"date,omitempty"`
-	Email          *string                 `json:"email,omitempty"`
-	Files          *[]File                 `json:"files,omitempty"`
-	Formula        *PageFormulaProperty    `json:"formula,omitempty"`
-	LastEditedBy   *User                   `json:"last_edited_by,omitempty"`
-	LastEditedTime *string                 `json:"last_edited_time,omitempty"`
-	MultiSelect    *[]MultiSelectProperty  `json:"multi_select,omitempty"`
-	Number         *float64                `json:"number,omitempty"`
-	People         *[]PartialUser          `json:"people,omitempty"`
-	PhoneNumber    *string                 `json:"phone_number,omitempty"`
-	Relation       *[]PageRelationProperty `json:"relation,omitempty"`
-	HasMore        *bool                   `json:"has_more,omitempty"`
-	Rollup         *PageRollupProperty     `json:"rollup,omitempty"`
-	RichText       *[]RichText             `json:"rich_text,omitempty"`
-	Select         *PageSelectProperty     `json:"select,omitempty"`
-	Title          *[]RichText             `json:"title,omitempty"`
-	URL            *string                 `json:"url,omitempty"`
-	UniqueID       *UniqueID               `json:"unique_id,omitempty"`
-}
-
 type Page struct {
 	Object         string      `json:"object"`
 	ID             string      `json:"id"`
@@ -0,0 +1,108 @@
+package schedule
+
+import "time"
+
+// Adaptive describes a webhook polled in adaptive mode, where the interval
+// shrinks while changes keep arriving and backs off while the source is
+// quiet.
+type Adaptive struct {
+	// Current is the interval used for the previous poll. Zero starts from
+	// the spec's base interval.
+	Current time.Duration
+	// Ceiling is the webhook's configured upper bound. It is capped by the
+	// plan's MaxInterval and raised to its MinInterval, and zero means the
+	// plan's bound.
+	Ceiling time.Duration
+}
+
+// Adapt returns the interval to use after a poll that found the given
+// number of changes. Busy sources halve the interval and quiet ones grow it
+// by half, always staying within the plan and webhook bounds.
+func Adapt(current time.Duration, changes int, floor, ceiling time.Duration) time.Duration {
+	next := current
+	if changes > 0 {
+		next = current / 2
+	} else {
+		next = current + current/2
+	}
+
+	return clamp(next, floor, ceiling)
+}
+
+// clamp bounds d to [floor, ceiling], with floor winning if they cross.
+func clamp(d, floor, ceiling time.Duration) time.Duration {
+	if d > ceiling {
+		d = ceiling
+	}
+	if d < floor {
+		d = floor
+	}
+
+	return d
+}
+
+// NextAdaptivePollAt returns when an adaptive webhook should next be polled
+// and the interval it was scheduled with. The interval starts from the
+// spec's fixed interval and any window in the spec is still honoured. The
+// spec's Expression is not used; the webhooks table rejects adaptive
+// webhooks that set one.
+func NextAdaptivePollAt(spec Spec, planName string, adaptive Adaptive, changes int, lastPolled time.Time) (time.Time, time.Duration, error) {
+	current, floor, ceiling := adaptiveBounds(spec, planName, adaptive)
+
+	return adaptivePollAt(spec, Adapt(current, changes, floor, ceiling), lastPolled)
+}
+
+// RetryAdaptivePollAt is NextAdaptivePollAt for a poll that failed. A
+// failure says nothing about how busy the source is, so the interval is
+// kept rather than adapted.
+func RetryAdaptivePollAt(spec Spec, planName string, adaptive Adaptive, lastPolled time.Time) (time.Time, time.Duration, error) {
+	current, floor, ceiling := adaptiveBounds(spec, planName, adaptive)
+
+	return adaptivePollAt(spec, clamp(current, floor, ceiling), lastPolled)
+}
+
+// adaptiveBounds returns the interval an adaptive webhook was last polled
+// with and the bounds it may adapt within.
+func adaptiveBounds(spec Spec, planName string, adaptive Adaptive) (current, floor, ceiling time.Duration) {
+	plan := PlanFor(planName)
+
+	ceiling = plan.MaxInterval
+	if adaptive.Ceiling > 0 && adaptive.Ceiling < ceiling {
+		ceiling = adaptive.Ceiling
+	}
+	if ceiling < plan.MinInterval {
+		ceiling = plan.MinInterval
+	}
+
+	current = adaptive.Current
+	if current <= 0 {
+		current = spec.Interval
+	}
+	if current <= 0 {
+		current = plan.MinInterval
+	}
+
+	return current, plan.MinInterval, ceiling
+}
+
+// adaptivePollAt schedules the next poll interval after lastPolled, moved
+// to the next opening of the spec's window if it falls outside it.
+func adaptivePollAt(spec Spec, interval time.Duration, lastPolled time.Time) (time.Time, time.Duration, error) {
+	next := lastPolled.Add(interval)
+
+	if spec.Window != "" {
+		window, err := ParseWindow(spec.Window)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		loc, err := location(spec.Timezone)
+		if err != nil {
+			return time.Time{}, 0, err
+		}
+		if local := next.In(loc); !window.Contains(local) {
+			next = window.NextOpen(local)
+		}
+	}
+
+	return next, interval, nil
+}
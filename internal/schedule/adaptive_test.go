@@ -0,0 +1,70 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdapt(t *testing.T) {
+	tests := []struct {
+		name    string
+		current time.Duration
+		changes int
+		want    time.Duration
+	}{
+		{"busy halves", 8 * time.Minute, 3, 4 * time.Minute},
+		{"quiet grows by half", 8 * time.Minute, 0, 12 * time.Minute},
+		{"busy stops at the floor", 90 * time.Second, 1, time.Minute},
+		{"quiet stops at the ceiling", 50 * time.Minute, 0, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Adapt(tt.current, tt.changes, time.Minute, time.Hour); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextAdaptivePollAt(t *testing.T) {
+	polled := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		spec     Spec
+		plan     string
+		adaptive Adaptive
+		changes  int
+		want     time.Duration
+	}{
+		{"starts from the spec interval", Spec{Interval: 10 * time.Minute}, "free", Adaptive{}, 0, 15 * time.Minute},
+		{"starts from the plan minimum", Spec{}, "free", Adaptive{}, 1, time.Minute},
+		{"capped by the webhook ceiling", Spec{}, "free", Adaptive{Current: 20 * time.Minute, Ceiling: 25 * time.Minute}, 0, 25 * time.Minute},
+		{"webhook ceiling capped by the plan", Spec{}, "premium", Adaptive{Current: 25 * time.Minute, Ceiling: time.Hour}, 0, 30 * time.Minute},
+		{"webhook ceiling below the plan minimum", Spec{}, "free", Adaptive{Current: time.Minute, Ceiling: 30 * time.Second}, 0, time.Minute},
+		{"busy source below the ceiling", Spec{}, "free", Adaptive{Current: 10 * time.Minute, Ceiling: 30 * time.Second}, 5, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, interval, err := NextAdaptivePollAt(tt.spec, tt.plan, tt.adaptive, tt.changes, polled)
+			if err != nil {
+				t.Fatalf("NextAdaptivePollAt: %v", err)
+			}
+			if interval != tt.want || !next.Equal(polled.Add(tt.want)) {
+				t.Errorf("got %s at %s, want %s", interval, next, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAdaptivePollAtKeepsInterval(t *testing.T) {
+	polled := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	next, interval, err := RetryAdaptivePollAt(Spec{}, "free", Adaptive{Current: 8 * time.Minute}, polled)
+	if err != nil {
+		t.Fatalf("RetryAdaptivePollAt: %v", err)
+	}
+	if interval != 8*time.Minute || !next.Equal(polled.Add(8*time.Minute)) {
+		t.Errorf("got %s at %s after a failed poll, want the 8m interval kept", interval, next)
+	}
+}
@@ -0,0 +1,49 @@
+package schedule
+
+import (
+	"fmt"
+	"time"
+)
+
+// Plan holds the polling limits for a subscription tier.
+type Plan struct {
+	Name        string
+	MinInterval time.Duration
+	// MaxInterval is the longest an adaptive webhook may back off to.
+	MaxInterval time.Duration
+}
+
+var plans = map[string]Plan{
+	"free":    {Name: "free", MinInterval: time.Minute, MaxInterval: time.Hour},
+	"premium": {Name: "premium", MinInterval: 10 * time.Second, MaxInterval: 30 * time.Minute},
+}
+
+// PlanFor returns the named plan, falling back to the free plan for unknown
+// or empty names.
+func PlanFor(name string) Plan {
+	if plan, ok := plans[name]; ok {
+		return plan
+	}
+
+	return plans["free"]
+}
+
+// NextPollAt returns when a webhook with the given spec and plan should next
+// be polled. Polls are never scheduled closer together than the plan allows.
+func NextPollAt(spec Spec, planName string, lastPolled time.Time) (time.Time, error) {
+	sched, err := Parse(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	plan := PlanFor(planName)
+	next := sched.Next(lastPolled)
+	if next.IsZero() {
+		return time.Time{}, fmt.Errorf("schedule %q never fires inside window %q", spec.Expression, spec.Window)
+	}
+	if earliest := lastPolled.Add(plan.MinInterval); next.Before(earliest) {
+		next = earliest
+	}
+
+	return next, nil
+}
@@ -0,0 +1,51 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPlanFor(t *testing.T) {
+	for name, want := range map[string]string{
+		"free":       "free",
+		"premium":    "premium",
+		"":           "free",
+		"enterprise": "free",
+	} {
+		if got := PlanFor(name).Name; got != want {
+			t.Errorf("PlanFor(%q) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestNextPollAt(t *testing.T) {
+	polled := time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		spec Spec
+		plan string
+		want time.Time
+	}{
+		{"clamped to the free minimum", Spec{Expression: "@every 10s"}, "free", polled.Add(time.Minute)},
+		{"clamped to the premium minimum", Spec{Expression: "@every 5s"}, "premium", polled.Add(10 * time.Second)},
+		{"above the minimum", Spec{Expression: "*/5 * * * *"}, "free", polled.Add(5 * time.Minute)},
+		{"interval", Spec{Interval: 5 * time.Minute}, "free", polled.Add(5 * time.Minute)},
+		{"outside the window", Spec{Interval: 5 * time.Minute, Window: "mon-fri 12:00-13:00"}, "free", polled.Add(2 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextPollAt(tt.spec, tt.plan, polled)
+			if err != nil {
+				t.Fatalf("NextPollAt: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NextPollAt(Spec{Expression: "0 0 * * *", Window: "09:00-17:00"}, "free", polled); err == nil {
+		t.Error("NextPollAt succeeded for a schedule that never fires inside its window")
+	}
+}
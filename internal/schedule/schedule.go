@@ -0,0 +1,113 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// parser accepts standard five field cron expressions, an optional leading
+// seconds field for sub-minute schedules and descriptors such as "@hourly"
+// or "@every 15s".
+var parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// Spec is the schedule configuration stored on a webhook.
+type Spec struct {
+	// Expression is a cron expression or descriptor. When empty the
+	// legacy Interval is used instead.
+	Expression string
+	// Interval is the fixed polling interval used when Expression is empty.
+	Interval time.Duration
+	// Timezone is the IANA timezone the expression and window are
+	// evaluated in. Defaults to UTC.
+	Timezone string
+	// Window optionally restricts polling to a weekly window such as
+	// "mon-fri 09:00-17:00".
+	Window string
+}
+
+type Schedule struct {
+	cron   cron.Schedule
+	loc    *time.Location
+	window *Window
+}
+
+func Parse(spec Spec) (*Schedule, error) {
+	loc, err := location(spec.Timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	var sched cron.Schedule
+	if spec.Expression != "" {
+		sched, err = parser.Parse(spec.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec.Expression, err)
+		}
+	} else {
+		if spec.Interval <= 0 {
+			return nil, errors.New("schedule requires an expression or a positive interval")
+		}
+		sched = cron.Every(spec.Interval)
+	}
+
+	var window *Window
+	if spec.Window != "" {
+		w, err := ParseWindow(spec.Window)
+		if err != nil {
+			return nil, err
+		}
+		window = &w
+	}
+
+	return &Schedule{
+		cron:   sched,
+		loc:    loc,
+		window: window,
+	}, nil
+}
+
+func location(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", timezone, err)
+	}
+
+	return loc, nil
+}
+
+// maxWindowSkips bounds how many windows Next tries before concluding that
+// the cron expression never fires inside the window.
+const maxWindowSkips = 1000
+
+// Next returns the first poll time after t that falls inside the schedule's
+// window, if it has one. Cron ticks outside the window are skipped up to the
+// first tick at or after it opens. A fixed interval has no ticks to line up
+// with, so it resumes as the window opens. Next returns the zero time if the
+// expression never fires inside the window.
+func (s *Schedule) Next(t time.Time) time.Time {
+	next := s.cron.Next(t.In(s.loc))
+	if s.window == nil {
+		return next
+	}
+	_, interval := s.cron.(cron.ConstantDelaySchedule)
+
+	for i := 0; i < maxWindowSkips && !next.IsZero(); i++ {
+		if s.window.Contains(next) {
+			return next
+		}
+		open := s.window.NextOpen(next)
+		if interval || open.IsZero() {
+			return open
+		}
+		next = s.cron.Next(open.Add(-time.Second))
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,70 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec Spec
+	}{
+		{"invalid expression", Spec{Expression: "every day"}},
+		{"too many fields", Spec{Expression: "0 0 0 * * * *"}},
+		{"no expression or interval", Spec{}},
+		{"invalid timezone", Spec{Interval: time.Minute, Timezone: "Mars/Olympus"}},
+		{"invalid window", Spec{Interval: time.Minute, Window: "weekdays"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse(tt.spec); err == nil {
+				t.Errorf("Parse(%+v) succeeded, want an error", tt.spec)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("loading timezone: %v", err)
+	}
+	// 2024-03-04 is a Monday.
+	at := func(day, hour, min, sec int) time.Time {
+		return time.Date(2024, 3, day, hour, min, sec, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		spec Spec
+		from time.Time
+		want time.Time
+	}{
+		{"five fields", Spec{Expression: "*/15 * * * *"}, at(4, 10, 7, 0), at(4, 10, 15, 0)},
+		{"optional seconds", Spec{Expression: "30 */5 * * * *"}, at(4, 10, 0, 0), at(4, 10, 0, 30)},
+		{"optional seconds after a tick", Spec{Expression: "30 */5 * * * *"}, at(4, 10, 0, 31), at(4, 10, 5, 30)},
+		{"descriptor", Spec{Expression: "@hourly"}, at(4, 10, 7, 0), at(4, 11, 0, 0)},
+		{"every descriptor", Spec{Expression: "@every 15s"}, at(4, 10, 0, 0), at(4, 10, 0, 15)},
+		{"interval", Spec{Interval: 10 * time.Minute}, at(4, 10, 7, 0), at(4, 10, 17, 0)},
+		{"timezone", Spec{Expression: "0 9 * * *", Timezone: "America/New_York"}, at(4, 12, 0, 0), at(4, 14, 0, 0)},
+		{"inside the window", Spec{Expression: "*/15 * * * *", Window: "mon-fri 09:00-17:00"}, at(4, 10, 7, 0), at(4, 10, 15, 0)},
+		{"first tick after the window opens", Spec{Expression: "*/15 * * * *", Window: "mon-fri 09:07-17:00"}, at(4, 8, 0, 0), at(4, 9, 15, 0)},
+		{"tick as the window opens", Spec{Expression: "*/15 * * * *", Window: "mon-fri 09:00-17:00"}, at(4, 8, 0, 0), at(4, 9, 0, 0)},
+		{"window over the weekend", Spec{Expression: "*/15 * * * *", Window: "mon-fri 09:07-17:00"}, at(8, 16, 55, 0), at(11, 9, 15, 0)},
+		{"interval resumes as the window opens", Spec{Interval: 10 * time.Minute, Window: "09:07-17:00"}, at(4, 16, 55, 0), at(5, 9, 7, 0)},
+		{"window in the timezone", Spec{Expression: "0 * * * *", Timezone: "America/New_York", Window: "09:00-17:00"}, at(4, 12, 30, 0), at(4, 14, 0, 0)},
+		{"never inside the window", Spec{Expression: "0 0 * * *", Window: "09:00-17:00"}, at(4, 10, 0, 0), time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sched, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if got := sched.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got.In(newYork), tt.want.In(newYork))
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a recurring weekly time range, for example business hours.
+// Times are evaluated in the location of the time passed to its methods.
+type Window struct {
+	Days  [7]bool
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow parses windows of the form "mon-fri 09:00-17:00". The day
+// list is optional and may combine ranges and single days, e.g.
+// "mon,wed,fri 08:30-12:00". Omitting it selects every day.
+func ParseWindow(s string) (Window, error) {
+	var w Window
+
+	fields := strings.Fields(strings.ToLower(s))
+	var days, hours string
+	switch len(fields) {
+	case 1:
+		days, hours = "sun-sat", fields[0]
+	case 2:
+		days, hours = fields[0], fields[1]
+	default:
+		return w, fmt.Errorf("invalid window %q", s)
+	}
+
+	for _, part := range strings.Split(days, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		start, ok := weekdays[from]
+		if !ok {
+			return w, fmt.Errorf("invalid window day %q", from)
+		}
+		end := start
+		if isRange {
+			end, ok = weekdays[to]
+			if !ok {
+				return w, fmt.Errorf("invalid window day %q", to)
+			}
+		}
+		for d := start; ; d = (d + 1) % 7 {
+			w.Days[d] = true
+			if d == end {
+				break
+			}
+		}
+	}
+
+	from, to, ok := strings.Cut(hours, "-")
+	if !ok {
+		return w, fmt.Errorf("invalid window hours %q", hours)
+	}
+	var err error
+	if w.Start, err = parseClock(from); err != nil {
+		return w, err
+	}
+	if w.End, err = parseClock(to); err != nil {
+		return w, err
+	}
+	if w.End <= w.Start {
+		return w, fmt.Errorf("window end %q must be after start %q", to, from)
+	}
+
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window time %q", s)
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w Window) Contains(t time.Time) bool {
+	if !w.Days[t.Weekday()] {
+		return false
+	}
+	offset := clock(t)
+
+	return offset >= w.Start && offset < w.End
+}
+
+// NextOpen returns the next time at or after t when the window opens. If
+// the opening falls in a daylight saving gap, the window opens when the
+// clocks have gone forward.
+func (w Window) NextOpen(t time.Time) time.Time {
+	day := midnight(t)
+	for i := 0; i < 8; i++ {
+		if w.Days[day.Weekday()] {
+			open := time.Date(day.Year(), day.Month(), day.Day(), int(w.Start/time.Hour), int(w.Start%time.Hour/time.Minute), 0, 0, day.Location())
+			if clock(open) != w.Start {
+				// time.Date may resolve a time in the gap to before it.
+				open = day.Add(w.Start)
+			}
+			if !open.Before(t) {
+				return open
+			}
+		}
+		day = midnight(day.AddDate(0, 0, 1))
+	}
+
+	return time.Time{}
+}
+
+// clock returns the wall clock time of day of t, which stays correct on
+// days with a daylight saving transition.
+func clock(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,137 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseWindow(t *testing.T) {
+	const (
+		sun = 1 << iota
+		mon
+		tue
+		wed
+		thu
+		fri
+		sat
+	)
+
+	tests := []struct {
+		window string
+		days   int
+		start  time.Duration
+		end    time.Duration
+	}{
+		{"mon-fri 09:00-17:00", mon | tue | wed | thu | fri, 9 * time.Hour, 17 * time.Hour},
+		{"fri-mon 08:00-12:00", fri | sat | sun | mon, 8 * time.Hour, 12 * time.Hour},
+		{"mon,wed,fri 08:30-12:00", mon | wed | fri, 8*time.Hour + 30*time.Minute, 12 * time.Hour},
+		{"sat-sun,wed 10:00-11:00", sat | sun | wed, 10 * time.Hour, 11 * time.Hour},
+		{"09:00-17:00", sun | mon | tue | wed | thu | fri | sat, 9 * time.Hour, 17 * time.Hour},
+		{"MON-FRI 09:00-17:00", mon | tue | wed | thu | fri, 9 * time.Hour, 17 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.window, func(t *testing.T) {
+			w, err := ParseWindow(tt.window)
+			if err != nil {
+				t.Fatalf("ParseWindow: %v", err)
+			}
+			days := 0
+			for d, selected := range w.Days {
+				if selected {
+					days |= 1 << d
+				}
+			}
+			if days != tt.days || w.Start != tt.start || w.End != tt.end {
+				t.Errorf("got days %07b from %s to %s, want %07b from %s to %s", days, w.Start, w.End, tt.days, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestParseWindowErrors(t *testing.T) {
+	for _, window := range []string{
+		"",
+		"weekdays 09:00-17:00",
+		"mon-xyz 09:00-17:00",
+		"mon-fri 9am-5pm",
+		"mon-fri 09:00",
+		"mon-fri 09:00-17:00 utc",
+		// Windows can't wrap around midnight.
+		"22:00-06:00",
+		"09:00-09:00",
+	} {
+		if _, err := ParseWindow(window); err == nil {
+			t.Errorf("ParseWindow(%q) succeeded, want an error", window)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	w, err := ParseWindow("mon-fri 09:00-17:00")
+	if err != nil {
+		t.Fatalf("ParseWindow: %v", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("loading timezone: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{"opening", time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC), true},
+		{"before opening", time.Date(2024, 3, 4, 8, 59, 59, 0, time.UTC), false},
+		{"last second", time.Date(2024, 3, 4, 16, 59, 59, 0, time.UTC), true},
+		{"closing", time.Date(2024, 3, 4, 17, 0, 0, 0, time.UTC), false},
+		{"weekend", time.Date(2024, 3, 9, 12, 0, 0, 0, time.UTC), false},
+		// Wall clock time is used, so daylight saving doesn't shift the
+		// window. 2024-03-11 is the Monday after clocks went forward.
+		{"after daylight saving starts", time.Date(2024, 3, 11, 9, 0, 0, 0, newYork), true},
+		{"before daylight saving starts", time.Date(2024, 3, 8, 16, 30, 0, 0, newYork), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := w.Contains(tt.t); got != tt.want {
+				t.Errorf("Contains(%s) = %t, want %t", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowNextOpen(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("loading timezone: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		window string
+		from   time.Time
+		want   time.Time
+	}{
+		{"later today", "mon-fri 09:00-17:00", time.Date(2024, 3, 4, 7, 0, 0, 0, time.UTC), time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)},
+		{"as it opens", "mon-fri 09:00-17:00", time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC), time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)},
+		{"after closing", "mon-fri 09:00-17:00", time.Date(2024, 3, 4, 18, 0, 0, 0, time.UTC), time.Date(2024, 3, 5, 9, 0, 0, 0, time.UTC)},
+		{"over the weekend", "mon-fri 09:00-17:00", time.Date(2024, 3, 8, 18, 0, 0, 0, time.UTC), time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)},
+		{"around the end of the week", "fri-mon 08:00-12:00", time.Date(2024, 3, 5, 8, 0, 0, 0, time.UTC), time.Date(2024, 3, 8, 8, 0, 0, 0, time.UTC)},
+		{"a week later", "mon 09:00-10:00", time.Date(2024, 3, 4, 9, 30, 0, 0, time.UTC), time.Date(2024, 3, 11, 9, 0, 0, 0, time.UTC)},
+		{"across daylight saving", "mon-fri 09:00-17:00", time.Date(2024, 3, 8, 18, 0, 0, 0, newYork), time.Date(2024, 3, 11, 9, 0, 0, 0, newYork)},
+		// 02:30 doesn't exist on 2024-03-10 in New York, so the window
+		// opens when the clocks have gone forward.
+		{"inside the daylight saving gap", "sun 02:30-04:00", time.Date(2024, 3, 9, 12, 0, 0, 0, newYork), time.Date(2024, 3, 10, 3, 30, 0, 0, newYork)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := ParseWindow(tt.window)
+			if err != nil {
+				t.Fatalf("ParseWindow: %v", err)
+			}
+			if got := w.NextOpen(tt.from); !got.Equal(tt.want) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
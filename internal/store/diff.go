@@ -0,0 +1,65 @@
+package store
+
+import (
+	"sort"
+
+	"github.com/gavsidhu/notion-hooks/internal/models"
+)
+
+// DiffPages compares two snapshots the way a poll compares the pages it
+// sees with the stored snapshot. Added and updated pages are in the order of
+// current, deleted pages are ordered by ID.
+func DiffPages(previous, current []models.SnapshotPage) models.SnapshotDiff {
+	before := make(map[string]models.SnapshotPage, len(previous))
+	for _, page := range previous {
+		before[page.PageID] = page
+	}
+
+	var diff models.SnapshotDiff
+	seen := make(map[string]bool, len(current))
+	for _, page := range current {
+		seen[page.PageID] = true
+		old, ok := before[page.PageID]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, page.PageID)
+		case pageUpdated(old, page):
+			diff.Updated = append(diff.Updated, page.PageID)
+		}
+	}
+	for _, page := range previous {
+		if !seen[page.PageID] {
+			diff.Deleted = append(diff.Deleted, page.PageID)
+		}
+	}
+	sort.Strings(diff.Deleted)
+
+	return diff
+}
+
+// UniquePages drops all but the last occurrence of each page, in the
+// position of its first. Notion's paginated query can return a page twice
+// when it is edited while the query is paged through, and a batch must not
+// write the same row twice.
+func UniquePages(pages []models.SnapshotPage) []models.SnapshotPage {
+	index := make(map[string]int, len(pages))
+	unique := make([]models.SnapshotPage, 0, len(pages))
+	for _, page := range pages {
+		if i, ok := index[page.PageID]; ok {
+			unique[i] = page
+			continue
+		}
+		index[page.PageID] = len(unique)
+		unique = append(unique, page)
+	}
+
+	return unique
+}
+
+// pageUpdated reports whether a page changed since it was stored. Notion
+// doesn't always bump last_edited_time, for example when a formula or
+// rollup changes, so the property hash is compared too. Rows carried over
+// from the old snapshot have no hash yet and only compare edit times.
+func pageUpdated(old, new models.SnapshotPage) bool {
+	return !old.LastEditedTime.Equal(new.LastEditedTime) || (old.PropertyHash != "" && old.PropertyHash != new.PropertyHash)
+}
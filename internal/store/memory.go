@@ -0,0 +1,841 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/google/uuid"
+)
+
+// Memory implements every store in memory, for tests and local runs without
+// Postgres. Events queued in the outbox are kept until the relay or
+// DrainOutbox takes them.
+type Memory struct {
+	clock        clock.Clock
+	mu           sync.Mutex
+	webhooks     map[string]models.Webhook
+	claimedAt    map[string]time.Time
+	snapshots    map[string]map[string]models.SnapshotPage
+	generations  map[string]int64
+	seen         map[string]map[string]int64
+	versions     map[string][]models.SnapshotVersion
+	discussions  map[string]map[string]models.Discussion
+	comments     map[string]map[string]memoryCommentCheck
+	accessTokens map[string]string
+	events       map[string]models.EventRecord
+	outbox       []memoryOutboxMessage
+}
+
+// memoryCommentCheck tracks a snapshot page's comment checks, like the
+// created_at and comments_checked_at columns of snapshot_pages.
+type memoryCommentCheck struct {
+	firstSeen time.Time
+	checkedAt *time.Time
+}
+
+type memoryOutboxMessage struct {
+	id           string
+	event        models.EventsToSend
+	claimedUntil time.Time
+	sentAt       *time.Time
+}
+
+// NewMemory returns an empty store that decides which webhooks are due
+// using clk.
+func NewMemory(clk clock.Clock) *Memory {
+	return &Memory{
+		clock:        clk,
+		webhooks:     make(map[string]models.Webhook),
+		claimedAt:    make(map[string]time.Time),
+		snapshots:    make(map[string]map[string]models.SnapshotPage),
+		generations:  make(map[string]int64),
+		seen:         make(map[string]map[string]int64),
+		versions:     make(map[string][]models.SnapshotVersion),
+		discussions:  make(map[string]map[string]models.Discussion),
+		comments:     make(map[string]map[string]memoryCommentCheck),
+		accessTokens: make(map[string]string),
+		events:       make(map[string]models.EventRecord),
+	}
+}
+
+// MemoryStores returns the stores backed by m.
+func MemoryStores(m *Memory) Stores {
+	return Stores{
+		Webhooks:     m,
+		Snapshots:    m,
+		Comments:     m,
+		Integrations: m,
+		Events:       m,
+		Outbox:       m,
+	}
+}
+
+// AddWebhook stores webhook, replacing any with the same ID.
+func (m *Memory) AddWebhook(webhook models.Webhook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.webhooks[webhook.ID] = webhook
+}
+
+// AddIntegration stores the Notion access token for userId.
+func (m *Memory) AddIntegration(userId string, accessToken string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.accessTokens[userId] = accessToken
+}
+
+// DrainOutbox removes the queued events that no relay has claimed or sent
+// and returns them.
+func (m *Memory) DrainOutbox() []models.EventsToSend {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	var events []models.EventsToSend
+	var kept []memoryOutboxMessage
+	for _, msg := range m.outbox {
+		if msg.sentAt == nil && !msg.claimedUntil.After(now) {
+			events = append(events, msg.event)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+	m.outbox = kept
+
+	return events
+}
+
+func (m *Memory) GetWebhook(ctx context.Context, webhookId string) (models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook, ok := m.webhooks[webhookId]
+	if !ok {
+		return models.Webhook{}, ErrNotFound
+	}
+
+	return webhook, nil
+}
+
+func (m *Memory) ListWebhooks(ctx context.Context, filter models.WebhookFilter) ([]models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var webhooks []models.Webhook
+	for _, webhook := range m.webhooks {
+		if filter.UserID != "" && webhook.UserID != filter.UserID {
+			continue
+		}
+		if filter.Status != "" && webhook.Status != filter.Status {
+			continue
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+
+	return webhooks, nil
+}
+
+func (m *Memory) GetURL(ctx context.Context, webhookId string) (string, error) {
+	webhook, err := m.GetWebhook(ctx, webhookId)
+	if err != nil {
+		return "", err
+	}
+
+	return webhook.URL, nil
+}
+
+func (m *Memory) ClaimDue(ctx context.Context, perUserLimit int, maxClaims int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	type candidate struct {
+		webhook models.Webhook
+		rank    int
+	}
+
+	processing := make(map[string]int)
+	for _, webhook := range m.webhooks {
+		if webhook.Status == models.WebhookStatusProcessing {
+			processing[webhook.UserID]++
+		}
+	}
+
+	due := m.dueLocked(m.clock.Now())
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].UserID != due[j].UserID {
+			return due[i].UserID < due[j].UserID
+		}
+		return due[i].NextPollAt.Before(*due[j].NextPollAt)
+	})
+
+	var claimable []candidate
+	rank := 0
+	for i, webhook := range due {
+		if i == 0 || webhook.UserID != due[i-1].UserID {
+			rank = 0
+		}
+		rank++
+		if processing[webhook.UserID]+rank <= perUserLimit {
+			claimable = append(claimable, candidate{webhook: webhook, rank: rank})
+		}
+	}
+	sort.SliceStable(claimable, func(i, j int) bool {
+		if claimable[i].rank != claimable[j].rank {
+			return claimable[i].rank < claimable[j].rank
+		}
+		return claimable[i].webhook.NextPollAt.Before(*claimable[j].webhook.NextPollAt)
+	})
+	if len(claimable) > maxClaims {
+		claimable = claimable[:maxClaims]
+	}
+
+	var ids []string
+	for _, c := range claimable {
+		c.webhook.Status = models.WebhookStatusProcessing
+		m.webhooks[c.webhook.ID] = c.webhook
+		m.claimedAt[c.webhook.ID] = m.clock.Now()
+		ids = append(ids, c.webhook.ID)
+	}
+
+	return ids, nil
+}
+
+func (m *Memory) CountDue(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.dueLocked(m.clock.Now())), nil
+}
+
+// dueLocked returns the active, idle webhooks due at now. m.mu must be held.
+func (m *Memory) dueLocked(now time.Time) []models.Webhook {
+	var due []models.Webhook
+	for _, webhook := range m.webhooks {
+		if webhook.IsActive && webhook.Status == models.WebhookStatusIdle && webhook.NextPollAt != nil && !webhook.NextPollAt.After(now) {
+			due = append(due, webhook)
+		}
+	}
+
+	return due
+}
+
+func (m *Memory) Claim(ctx context.Context, webhookId string) (models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook, ok := m.webhooks[webhookId]
+	if !ok {
+		return models.Webhook{}, ErrNotFound
+	}
+	if webhook.Status != models.WebhookStatusIdle {
+		return models.Webhook{}, ErrWebhookBusy
+	}
+
+	webhook.Status = models.WebhookStatusProcessing
+	m.webhooks[webhookId] = webhook
+	m.claimedAt[webhookId] = m.clock.Now()
+
+	return webhook, nil
+}
+
+func (m *Memory) Unclaim(ctx context.Context, webhookIds []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, webhookId := range webhookIds {
+		m.unclaimLocked(webhookId)
+	}
+
+	return nil
+}
+
+func (m *Memory) ReleaseStaleClaims(ctx context.Context, claimedBefore time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var released []string
+	for webhookId, claimedAt := range m.claimedAt {
+		if claimedAt.Before(claimedBefore) && m.unclaimLocked(webhookId) {
+			released = append(released, webhookId)
+		}
+	}
+	sort.Strings(released)
+
+	return released, nil
+}
+
+// unclaimLocked returns a processing webhook to idle and reports whether it
+// was processing. m.mu must be held.
+func (m *Memory) unclaimLocked(webhookId string) bool {
+	webhook, ok := m.webhooks[webhookId]
+	if !ok || webhook.Status != models.WebhookStatusProcessing {
+		return false
+	}
+
+	webhook.Status = models.WebhookStatusIdle
+	m.webhooks[webhookId] = webhook
+	delete(m.claimedAt, webhookId)
+
+	return true
+}
+
+func (m *Memory) ScheduleNextPoll(ctx context.Context, webhookId string, lastPolled time.Time, nextPollAt time.Time, effectiveIntervalSeconds int) error {
+	return m.updateWebhook(webhookId, func(webhook *models.Webhook) {
+		webhook.LastPolled = &lastPolled
+		webhook.NextPollAt = &nextPollAt
+		webhook.EffectiveIntervalSeconds = effectiveIntervalSeconds
+		if webhook.Status != models.WebhookStatusPaused {
+			webhook.Status = models.WebhookStatusIdle
+		}
+		delete(m.claimedAt, webhookId)
+	})
+}
+
+func (m *Memory) Reschedule(ctx context.Context, webhookId string, nextPollAt time.Time) error {
+	return m.updateWebhook(webhookId, func(webhook *models.Webhook) {
+		webhook.NextPollAt = &nextPollAt
+		if webhook.Status != models.WebhookStatusPaused {
+			webhook.Status = models.WebhookStatusIdle
+		}
+		delete(m.claimedAt, webhookId)
+	})
+}
+
+func (m *Memory) SetStatus(ctx context.Context, webhookId string, status string) error {
+	return m.updateWebhook(webhookId, func(webhook *models.Webhook) {
+		webhook.Status = status
+	})
+}
+
+func (m *Memory) Pause(ctx context.Context, webhookId string) error {
+	return m.updateWebhook(webhookId, func(webhook *models.Webhook) {
+		webhook.Status = models.WebhookStatusPaused
+	})
+}
+
+func (m *Memory) Resume(ctx context.Context, webhookId string) error {
+	return m.updateWebhook(webhookId, func(webhook *models.Webhook) {
+		if webhook.Status == models.WebhookStatusPaused {
+			webhook.Status = models.WebhookStatusIdle
+		}
+	})
+}
+
+func (m *Memory) SetQuery(ctx context.Context, webhookId string, filter json.RawMessage, sorts json.RawMessage) error {
+	return m.updateWebhook(webhookId, func(webhook *models.Webhook) {
+		webhook.QueryFilter = filter
+		webhook.QuerySorts = sorts
+	})
+}
+
+// updateWebhook applies update to the webhook with m.mu held.
+func (m *Memory) updateWebhook(webhookId string, update func(webhook *models.Webhook)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook, ok := m.webhooks[webhookId]
+	if !ok {
+		return ErrNotFound
+	}
+
+	update(&webhook)
+	webhook.UpdatedAt = m.clock.Now()
+	m.webhooks[webhookId] = webhook
+
+	return nil
+}
+
+func (m *Memory) GetSnapshot(ctx context.Context, webhookId string) ([]models.SnapshotPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pages := make([]models.SnapshotPage, 0, len(m.snapshots[webhookId]))
+	for _, page := range m.snapshots[webhookId] {
+		pages = append(pages, page)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].PageID < pages[j].PageID })
+
+	return pages, nil
+}
+
+func (m *Memory) BeginSnapshot(ctx context.Context, webhookId string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webhooks[webhookId]; !ok {
+		return 0, ErrNotFound
+	}
+	m.generations[webhookId]++
+
+	return m.generations[webhookId], nil
+}
+
+func (m *Memory) DiffSnapshot(ctx context.Context, webhookId string, pages []models.SnapshotPage) (models.SnapshotDiff, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var diff models.SnapshotDiff
+	for _, page := range pages {
+		old, ok := m.snapshots[webhookId][page.PageID]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, page.PageID)
+		case pageUpdated(old, page):
+			diff.Updated = append(diff.Updated, page.PageID)
+		}
+	}
+
+	return diff, nil
+}
+
+func (m *Memory) SaveSnapshotBatch(ctx context.Context, webhookId string, generation int64, polledAt time.Time, pages []models.SnapshotPage, events []models.EventsToSend) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := m.snapshots[webhookId]
+	if snapshot == nil {
+		snapshot = make(map[string]models.SnapshotPage)
+		m.snapshots[webhookId] = snapshot
+	}
+	seen := m.seen[webhookId]
+	if seen == nil {
+		seen = make(map[string]int64)
+		m.seen[webhookId] = seen
+	}
+	comments := m.comments[webhookId]
+	if comments == nil {
+		comments = make(map[string]memoryCommentCheck)
+		m.comments[webhookId] = comments
+	}
+
+	for _, page := range UniquePages(pages) {
+		old, ok := snapshot[page.PageID]
+		if ok && !snapshotPageChanged(old, page) {
+			seen[page.PageID] = generation
+			continue
+		}
+		if ok {
+			m.endVersionLocked(webhookId, page.PageID, polledAt)
+		} else {
+			comments[page.PageID] = memoryCommentCheck{firstSeen: m.clock.Now()}
+		}
+		m.versions[webhookId] = append(m.versions[webhookId], models.SnapshotVersion{SnapshotPage: page, ValidFrom: polledAt})
+		snapshot[page.PageID] = page
+		seen[page.PageID] = generation
+	}
+
+	m.insertEventsLocked(events)
+
+	return nil
+}
+
+func (m *Memory) UnseenPages(ctx context.Context, webhookId string, generation int64, limit int) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pageIDs []string
+	for id := range m.snapshots[webhookId] {
+		if m.seen[webhookId][id] != generation {
+			pageIDs = append(pageIDs, id)
+		}
+	}
+	sort.Strings(pageIDs)
+	if len(pageIDs) > limit {
+		pageIDs = pageIDs[:limit]
+	}
+
+	return pageIDs, nil
+}
+
+func (m *Memory) DeleteSnapshotPages(ctx context.Context, webhookId string, polledAt time.Time, pageIDs []string, events []models.EventsToSend) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, id := range pageIDs {
+		delete(m.snapshots[webhookId], id)
+		delete(m.seen[webhookId], id)
+		delete(m.comments[webhookId], id)
+		m.endVersionLocked(webhookId, id, polledAt)
+	}
+
+	m.insertEventsLocked(events)
+
+	return nil
+}
+
+// endVersionLocked ends the current version of a page at polledAt, if it
+// has one. A version that started in the same poll was never visible and
+// is dropped instead.
+func (m *Memory) endVersionLocked(webhookId string, pageID string, polledAt time.Time) {
+	versions := m.versions[webhookId][:0]
+	for _, version := range m.versions[webhookId] {
+		if version.PageID == pageID && version.ValidTo == nil {
+			if !version.ValidFrom.Before(polledAt) {
+				continue
+			}
+			version.ValidTo = &polledAt
+		}
+		versions = append(versions, version)
+	}
+	m.versions[webhookId] = versions
+}
+
+// insertEventsLocked stores events generated by a poll and queues them in
+// the outbox.
+func (m *Memory) insertEventsLocked(events []models.EventsToSend) {
+	for _, event := range events {
+		event.ID = uuid.New().String()
+		m.events[event.ID] = models.EventRecord{
+			ID:        event.ID,
+			WebhookID: event.WebhookID,
+			UserID:    event.UserID,
+			Type:      event.Type,
+			ObjectID:  event.Data.ObjectID,
+			Data:      event.Data,
+			Status:    models.EventStatusPending,
+			CreatedAt: time.Unix(event.Data.CreatedAt, 0),
+		}
+		m.queueLocked(event)
+	}
+}
+
+// queueLocked adds event to the outbox. m.mu must be held.
+func (m *Memory) queueLocked(event models.EventsToSend) {
+	m.outbox = append(m.outbox, memoryOutboxMessage{id: uuid.New().String(), event: event})
+}
+
+func snapshotPageChanged(old, new models.SnapshotPage) bool {
+	return !old.LastEditedTime.Equal(new.LastEditedTime) || old.PropertyHash != new.PropertyHash || !bytes.Equal(old.Properties, new.Properties)
+}
+
+func (m *Memory) SnapshotAt(ctx context.Context, webhookId string, at time.Time) ([]models.SnapshotPage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pages []models.SnapshotPage
+	for _, version := range m.versions[webhookId] {
+		if !version.ValidFrom.After(at) && (version.ValidTo == nil || version.ValidTo.After(at)) {
+			pages = append(pages, version.SnapshotPage)
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].PageID < pages[j].PageID })
+
+	return pages, nil
+}
+
+func (m *Memory) HistoryStart(ctx context.Context, webhookId string) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	versions := m.versions[webhookId]
+	if len(versions) == 0 {
+		return time.Time{}, ErrNotFound
+	}
+
+	start := versions[0].ValidFrom
+	for _, version := range versions[1:] {
+		if version.ValidFrom.Before(start) {
+			start = version.ValidFrom
+		}
+	}
+
+	return start, nil
+}
+
+func (m *Memory) PruneHistory(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var pruned int64
+	for webhookId, versions := range m.versions {
+		kept := versions[:0]
+		for _, version := range versions {
+			if version.ValidTo != nil && version.ValidTo.Before(before) {
+				pruned++
+				continue
+			}
+			kept = append(kept, version)
+		}
+		m.versions[webhookId] = kept
+	}
+
+	return pruned, nil
+}
+
+func (m *Memory) GetDiscussions(ctx context.Context, webhookId string, pageIDs []string) ([]models.Discussion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pages := make(map[string]bool, len(pageIDs))
+	for _, id := range pageIDs {
+		pages[id] = true
+	}
+
+	var discussions []models.Discussion
+	for _, discussion := range m.discussions[webhookId] {
+		if pages[discussion.PageID] {
+			discussion.CommentIDs = append([]string{}, discussion.CommentIDs...)
+			discussions = append(discussions, discussion)
+		}
+	}
+	sort.Slice(discussions, func(i, j int) bool { return discussions[i].DiscussionID < discussions[j].DiscussionID })
+
+	return discussions, nil
+}
+
+func (m *Memory) CommentsDue(ctx context.Context, webhookId string, checkedBefore time.Time, limit int) ([]models.CommentCheck, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Pages that were never checked sort first with a zero checked time.
+	type duePage struct {
+		check   models.CommentCheck
+		checked time.Time
+	}
+	var due []duePage
+	for id, check := range m.comments[webhookId] {
+		switch {
+		case check.checkedAt == nil:
+			due = append(due, duePage{models.CommentCheck{PageID: id, Since: check.firstSeen}, time.Time{}})
+		case !check.checkedAt.After(checkedBefore):
+			due = append(due, duePage{models.CommentCheck{PageID: id, Since: *check.checkedAt}, *check.checkedAt})
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].checked.Equal(due[j].checked) {
+			return due[i].checked.Before(due[j].checked)
+		}
+		return due[i].check.PageID < due[j].check.PageID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	checks := make([]models.CommentCheck, len(due))
+	for i, page := range due {
+		checks[i] = page.check
+	}
+
+	return checks, nil
+}
+
+func (m *Memory) SaveDiscussions(ctx context.Context, webhookId string, pageIDs []string, checkedAt time.Time, discussions []models.Discussion, events []models.EventsToSend) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pages := make(map[string]bool, len(pageIDs))
+	for _, id := range pageIDs {
+		pages[id] = true
+		// Like the Postgres UPDATE, only pages in the snapshot are marked.
+		if check, ok := m.comments[webhookId][id]; ok {
+			check.checkedAt = &checkedAt
+			m.comments[webhookId][id] = check
+		}
+	}
+
+	stored := m.discussions[webhookId]
+	if stored == nil {
+		stored = make(map[string]models.Discussion)
+		m.discussions[webhookId] = stored
+	}
+	for id, discussion := range stored {
+		if pages[discussion.PageID] {
+			delete(stored, id)
+		}
+	}
+	for _, discussion := range discussions {
+		discussion.CommentIDs = append([]string{}, discussion.CommentIDs...)
+		stored[discussion.DiscussionID] = discussion
+	}
+
+	m.insertEventsLocked(events)
+
+	return nil
+}
+
+func (m *Memory) GetNotionAccessToken(ctx context.Context, userId string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	accessToken, ok := m.accessTokens[userId]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	return accessToken, nil
+}
+
+func (m *Memory) GetEvent(ctx context.Context, eventId string) (models.EventRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event, ok := m.events[eventId]
+	if !ok {
+		return models.EventRecord{}, ErrNotFound
+	}
+
+	return event, nil
+}
+
+func (m *Memory) ListEvents(ctx context.Context, filter models.EventFilter) ([]models.EventRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []models.EventRecord
+	for _, event := range m.events {
+		switch {
+		case filter.UserID != "" && event.UserID != filter.UserID,
+			filter.WebhookID != "" && event.WebhookID != filter.WebhookID,
+			filter.Type != "" && event.Type != filter.Type,
+			filter.ObjectID != "" && event.ObjectID != filter.ObjectID,
+			filter.From != nil && event.CreatedAt.Before(*filter.From),
+			filter.To != nil && !event.CreatedAt.Before(*filter.To):
+			continue
+		}
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].CreatedAt.After(events[j].CreatedAt)
+	})
+
+	if limit := eventsLimit(filter.Limit); len(events) > limit {
+		events = events[:limit]
+	}
+
+	return events, nil
+}
+
+func (m *Memory) RecordDeliveryAttempt(ctx context.Context, eventId string, responseCode int, deliveryErr error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	event, ok := m.events[eventId]
+	if !ok {
+		return nil
+	}
+
+	event.Attempts++
+	event.Status, event.LastResponseCode, event.LastError, event.DeliveredAt = deliveryOutcome(responseCode, deliveryErr, m.clock.Now())
+	m.events[eventId] = event
+
+	return nil
+}
+
+func (m *Memory) Replay(ctx context.Context, eventIds []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var replayed []string
+	for _, eventId := range eventIds {
+		event, ok := m.events[eventId]
+		if !ok {
+			continue
+		}
+
+		event.Status = models.EventStatusPending
+		m.events[eventId] = event
+		m.queueLocked(models.EventsToSend{
+			ID:        event.ID,
+			Type:      event.Type,
+			UserID:    event.UserID,
+			WebhookID: event.WebhookID,
+			Data:      event.Data,
+		})
+		replayed = append(replayed, eventId)
+	}
+
+	return replayed, nil
+}
+
+func (m *Memory) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	var messages []models.OutboxMessage
+	for i, msg := range m.outbox {
+		if len(messages) == limit {
+			break
+		}
+		if msg.sentAt != nil || msg.claimedUntil.After(now) {
+			continue
+		}
+
+		payload, err := json.Marshal(msg.event)
+		if err != nil {
+			return nil, err
+		}
+		m.outbox[i].claimedUntil = now.Add(lease)
+		messages = append(messages, models.OutboxMessage{
+			ID:      msg.id,
+			Queue:   eventsQueue,
+			Payload: payload,
+			Headers: map[string]string{},
+		})
+	}
+
+	return messages, nil
+}
+
+func (m *Memory) MarkOutboxSent(ctx context.Context, ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	m.updateOutboxLocked(ids, func(msg *memoryOutboxMessage) {
+		msg.sentAt = &now
+	})
+
+	return nil
+}
+
+func (m *Memory) RetryOutbox(ctx context.Context, ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.updateOutboxLocked(ids, func(msg *memoryOutboxMessage) {
+		if msg.sentAt == nil {
+			msg.claimedUntil = time.Time{}
+		}
+	})
+
+	return nil
+}
+
+// updateOutboxLocked applies update to the outbox messages with the given
+// IDs. m.mu must be held.
+func (m *Memory) updateOutboxLocked(ids []string, update func(msg *memoryOutboxMessage)) {
+	for i := range m.outbox {
+		for _, id := range ids {
+			if m.outbox[i].id == id {
+				update(&m.outbox[i])
+			}
+		}
+	}
+}
+
+func (m *Memory) PruneSentOutbox(ctx context.Context, before time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var kept []memoryOutboxMessage
+	for _, msg := range m.outbox {
+		if msg.sentAt == nil || !msg.sentAt.Before(before) {
+			kept = append(kept, msg)
+		}
+	}
+	m.outbox = kept
+
+	return nil
+}
@@ -0,0 +1,792 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/tracing"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Postgres implements every store on a pgx pool.
+type Postgres struct {
+	db    *pgxpool.Pool
+	clock clock.Clock
+}
+
+// NewPostgres returns a store backed by db. Times the database doesn't set
+// itself, like when an event was delivered, come from clk.
+func NewPostgres(db *pgxpool.Pool, clk clock.Clock) *Postgres {
+	return &Postgres{db: db, clock: clk}
+}
+
+// PostgresStores returns the stores backed by db.
+func PostgresStores(db *pgxpool.Pool, clk clock.Clock) Stores {
+	pg := NewPostgres(db, clk)
+	return Stores{
+		Webhooks:     pg,
+		Snapshots:    pg,
+		Comments:     pg,
+		Integrations: pg,
+		Events:       pg,
+		Outbox:       pg,
+	}
+}
+
+// notFound maps pgx's missing row error to ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+const webhookColumns = `id, name, description, user_id, url, secret, events, is_active, polling_interval, last_polled, status, notion_object_id, notion_object_type, created_at, updated_at, schedule, timezone, poll_window, plan, next_poll_at, adaptive_polling, adaptive_max_interval, effective_interval_seconds, query_filter, query_sorts`
+
+func scanWebhook(row pgx.Row) (models.Webhook, error) {
+	var webhook models.Webhook
+	var filter, sorts []byte
+	err := row.Scan(&webhook.ID, &webhook.Name, &webhook.Description, &webhook.UserID, &webhook.URL, &webhook.Secret, &webhook.Events, &webhook.IsActive, &webhook.PollingInterval, &webhook.LastPolled, &webhook.Status, &webhook.NotionObjectID, &webhook.NotionObjectType, &webhook.CreatedAt, &webhook.UpdatedAt, &webhook.Schedule, &webhook.Timezone, &webhook.PollWindow, &webhook.Plan, &webhook.NextPollAt, &webhook.AdaptivePolling, &webhook.AdaptiveMaxInterval, &webhook.EffectiveIntervalSeconds, &filter, &sorts)
+	if err != nil {
+		return models.Webhook{}, notFound(err)
+	}
+	webhook.QueryFilter = filter
+	webhook.QuerySorts = sorts
+
+	return webhook, nil
+}
+
+func (p *Postgres) GetWebhook(ctx context.Context, webhookId string) (models.Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE id = $1;`
+
+	return scanWebhook(p.db.QueryRow(ctx, query, webhookId))
+}
+
+// ListWebhooks returns the webhooks matching filter ordered by creation.
+func (p *Postgres) ListWebhooks(ctx context.Context, filter models.WebhookFilter) ([]models.Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE ($1 = '' OR user_id = $1) AND ($2 = '' OR status = $2) ORDER BY created_at;`
+
+	rows, err := p.db.Query(ctx, query, filter.UserID, filter.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Webhook, error) {
+		return scanWebhook(row)
+	})
+}
+
+func (p *Postgres) GetURL(ctx context.Context, webhookId string) (string, error) {
+	query := `SELECT url FROM webhooks WHERE id = $1;`
+
+	var url string
+	err := p.db.QueryRow(ctx, query, webhookId).Scan(&url)
+	if err != nil {
+		return "", notFound(err)
+	}
+
+	return url, nil
+}
+
+func (p *Postgres) ClaimDue(ctx context.Context, perUserLimit int, maxClaims int) ([]string, error) {
+	query := `
+    WITH Running AS (
+        SELECT user_id, COUNT(*) AS processing
+        FROM webhooks
+        WHERE status = 'processing'
+        GROUP BY user_id
+    ),
+    Due AS (
+        SELECT w.id, w.next_poll_at,
+            ROW_NUMBER() OVER (PARTITION BY w.user_id ORDER BY w.next_poll_at) AS user_rank,
+            COALESCE(r.processing, 0) AS processing
+        FROM webhooks w
+        LEFT JOIN Running r ON r.user_id = w.user_id
+        WHERE w.next_poll_at <= NOW()
+        AND w.is_active = true AND w.status = 'idle'
+    ),
+    Claimable AS (
+        SELECT id, user_rank, next_poll_at
+        FROM Due
+        WHERE processing + user_rank <= $1
+        ORDER BY user_rank, next_poll_at
+        LIMIT $2
+    ),
+    UpdatedWebhooks AS (
+        UPDATE webhooks
+        SET status = 'processing', claimed_at = NOW()
+        WHERE id IN (SELECT id FROM Claimable) AND status = 'idle'
+        RETURNING id
+    )
+    SELECT u.id FROM UpdatedWebhooks u
+    JOIN Claimable c ON c.id = u.id
+    ORDER BY c.user_rank, c.next_poll_at;`
+
+	rows, err := p.db.Query(ctx, query, perUserLimit, maxClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (p *Postgres) CountDue(ctx context.Context) (int, error) {
+	query := `SELECT COUNT(*) FROM webhooks WHERE next_poll_at <= NOW() AND is_active = true AND status = 'idle';`
+
+	var due int
+	err := p.db.QueryRow(ctx, query).Scan(&due)
+	return due, err
+}
+
+func (p *Postgres) Claim(ctx context.Context, webhookId string) (models.Webhook, error) {
+	query := `UPDATE webhooks SET status = 'processing', claimed_at = NOW() WHERE id = $1 AND status = 'idle' RETURNING ` + webhookColumns + `;`
+
+	webhook, err := scanWebhook(p.db.QueryRow(ctx, query, webhookId))
+	if errors.Is(err, ErrNotFound) {
+		if _, err := p.GetWebhook(ctx, webhookId); err != nil {
+			return models.Webhook{}, err
+		}
+		return models.Webhook{}, ErrWebhookBusy
+	}
+
+	return webhook, err
+}
+
+func (p *Postgres) ScheduleNextPoll(ctx context.Context, webhookId string, lastPolled time.Time, nextPollAt time.Time, effectiveIntervalSeconds int) error {
+	query := `UPDATE webhooks SET last_polled = $1, next_poll_at = $2, effective_interval_seconds = $3, status = CASE WHEN status = 'paused' THEN 'paused' ELSE 'idle' END, claimed_at = NULL WHERE id = $4;`
+	_, err := p.db.Exec(ctx, query, lastPolled, nextPollAt, effectiveIntervalSeconds, webhookId)
+	return err
+}
+
+func (p *Postgres) Reschedule(ctx context.Context, webhookId string, nextPollAt time.Time) error {
+	query := `UPDATE webhooks SET next_poll_at = $1, status = CASE WHEN status = 'paused' THEN 'paused' ELSE 'idle' END, claimed_at = NULL WHERE id = $2;`
+	_, err := p.db.Exec(ctx, query, nextPollAt, webhookId)
+	return err
+}
+
+func (p *Postgres) Unclaim(ctx context.Context, webhookIds []string) error {
+	query := `UPDATE webhooks SET status = 'idle', claimed_at = NULL WHERE id = ANY($1) AND status = 'processing';`
+	_, err := p.db.Exec(ctx, query, webhookIds)
+	return err
+}
+
+func (p *Postgres) ReleaseStaleClaims(ctx context.Context, claimedBefore time.Time) ([]string, error) {
+	query := `UPDATE webhooks SET status = 'idle', claimed_at = NULL WHERE status = 'processing' AND claimed_at < $1 RETURNING id;`
+
+	rows, err := p.db.Query(ctx, query, claimedBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (p *Postgres) SetStatus(ctx context.Context, webhookId string, status string) error {
+	query := `UPDATE webhooks SET status = $1 WHERE id = $2;`
+	_, err := p.db.Exec(ctx, query, status, webhookId)
+	return err
+}
+
+// Pause leaves a poll that is already running to finish, but the webhook
+// stays paused afterwards.
+func (p *Postgres) Pause(ctx context.Context, webhookId string) error {
+	return p.setPaused(ctx, webhookId, `UPDATE webhooks SET status = 'paused' WHERE id = $1;`)
+}
+
+func (p *Postgres) Resume(ctx context.Context, webhookId string) error {
+	return p.setPaused(ctx, webhookId, `UPDATE webhooks SET status = 'idle' WHERE id = $1 AND status = 'paused';`)
+}
+
+func (p *Postgres) SetQuery(ctx context.Context, webhookId string, filter json.RawMessage, sorts json.RawMessage) error {
+	query := `UPDATE webhooks SET query_filter = $2, query_sorts = $3, updated_at = NOW() WHERE id = $1;`
+
+	tag, err := p.db.Exec(ctx, query, webhookId, nullJSON(filter), nullJSON(sorts))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// nullJSON stores an empty value as NULL rather than invalid JSON.
+func nullJSON(value json.RawMessage) any {
+	if len(value) == 0 {
+		return nil
+	}
+
+	return string(value)
+}
+
+func (p *Postgres) setPaused(ctx context.Context, webhookId string, query string) error {
+	_, err := p.db.Exec(ctx, query, webhookId)
+	if err != nil {
+		return err
+	}
+
+	// Confirm the webhook exists so a mistyped ID doesn't look like success.
+	_, err = p.GetWebhook(ctx, webhookId)
+	return err
+}
+
+func (p *Postgres) GetSnapshot(ctx context.Context, webhookId string) ([]models.SnapshotPage, error) {
+	query := `SELECT page_id, last_edited_time, property_hash, properties FROM snapshot_pages WHERE webhook_id = $1 ORDER BY page_id;`
+
+	rows, err := p.db.Query(ctx, query, webhookId)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.SnapshotPage, error) {
+		var page models.SnapshotPage
+		err := row.Scan(&page.PageID, &page.LastEditedTime, &page.PropertyHash, &page.Properties)
+		return page, err
+	})
+}
+
+func (p *Postgres) BeginSnapshot(ctx context.Context, webhookId string) (int64, error) {
+	query := `UPDATE webhooks SET snapshot_generation = snapshot_generation + 1 WHERE id = $1 RETURNING snapshot_generation;`
+
+	var generation int64
+	err := p.db.QueryRow(ctx, query, webhookId).Scan(&generation)
+	if err != nil {
+		return 0, notFound(err)
+	}
+
+	return generation, nil
+}
+
+// DiffSnapshot compares the batch with the stored rows using a set query,
+// so the snapshot never has to be loaded.
+func (p *Postgres) DiffSnapshot(ctx context.Context, webhookId string, pages []models.SnapshotPage) (models.SnapshotDiff, error) {
+	query := `
+    SELECT p.page_id, s.page_id IS NULL
+    FROM unnest($2::text[], $3::timestamptz[], $4::text[]) WITH ORDINALITY AS p(page_id, last_edited_time, property_hash, n)
+    LEFT JOIN snapshot_pages s ON s.webhook_id = $1 AND s.page_id = p.page_id
+    WHERE s.page_id IS NULL
+        OR s.last_edited_time <> p.last_edited_time
+        OR (s.property_hash <> '' AND s.property_hash <> p.property_hash)
+    ORDER BY p.n;`
+
+	pageIDs, lastEdited, hashes, _ := snapshotColumns(pages)
+
+	rows, err := p.db.Query(ctx, query, webhookId, pageIDs, lastEdited, hashes)
+	if err != nil {
+		return models.SnapshotDiff{}, err
+	}
+	defer rows.Close()
+
+	var diff models.SnapshotDiff
+	for rows.Next() {
+		var pageID string
+		var added bool
+		if err := rows.Scan(&pageID, &added); err != nil {
+			return models.SnapshotDiff{}, err
+		}
+		if added {
+			diff.Added = append(diff.Added, pageID)
+		} else {
+			diff.Updated = append(diff.Updated, pageID)
+		}
+	}
+
+	return diff, rows.Err()
+}
+
+// SaveSnapshotBatch writes the events to the outbox in the same transaction
+// as the batch, for the outbox relay to publish.
+func (p *Postgres) SaveSnapshotBatch(ctx context.Context, webhookId string, generation int64, polledAt time.Time, pages []models.SnapshotPage, events []models.EventsToSend) error {
+	// ON CONFLICT DO UPDATE can't affect the same row twice.
+	pageIDs, lastEdited, hashes, properties := snapshotColumns(UniquePages(pages))
+
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		// Unchanged rows are skipped by the WHERE clause, so they cost no
+		// writes.
+		_, err := tx.Exec(ctx, `
+        INSERT INTO snapshot_pages (webhook_id, page_id, last_edited_time, property_hash, properties, seen_generation)
+        SELECT $1, p.*, $6 FROM unnest($2::text[], $3::timestamptz[], $4::text[], $5::jsonb[]) AS p
+        ON CONFLICT (webhook_id, page_id) DO UPDATE
+        SET last_edited_time = EXCLUDED.last_edited_time,
+            property_hash = EXCLUDED.property_hash,
+            properties = EXCLUDED.properties,
+            seen_generation = EXCLUDED.seen_generation
+        WHERE snapshot_pages.last_edited_time <> EXCLUDED.last_edited_time
+            OR snapshot_pages.property_hash <> EXCLUDED.property_hash
+            OR snapshot_pages.properties IS DISTINCT FROM EXCLUDED.properties;`,
+			webhookId, pageIDs, lastEdited, hashes, properties, generation)
+		if err != nil {
+			return err
+		}
+
+		// The rows the upsert skipped are only marked as seen. Setting the
+		// one column leaves the stored properties as they are, and the
+		// updated_at trigger ignores it.
+		_, err = tx.Exec(ctx, `
+        UPDATE snapshot_pages SET seen_generation = $3
+        WHERE webhook_id = $1 AND page_id = ANY($2) AND seen_generation <> $3;`,
+			webhookId, pageIDs, generation)
+		if err != nil {
+			return err
+		}
+
+		err = recordSnapshotVersions(ctx, tx, webhookId, polledAt, pageIDs, lastEdited, hashes)
+		if err != nil {
+			return err
+		}
+
+		return insertPollEvents(ctx, tx, events)
+	})
+}
+
+func (p *Postgres) UnseenPages(ctx context.Context, webhookId string, generation int64, limit int) ([]string, error) {
+	query := `SELECT page_id FROM snapshot_pages WHERE webhook_id = $1 AND seen_generation <> $2 ORDER BY page_id LIMIT $3;`
+
+	rows, err := p.db.Query(ctx, query, webhookId, generation, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+func (p *Postgres) DeleteSnapshotPages(ctx context.Context, webhookId string, polledAt time.Time, pageIDs []string, events []models.EventsToSend) error {
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM snapshot_pages WHERE webhook_id = $1 AND page_id = ANY($2);`, webhookId, pageIDs)
+		if err != nil {
+			return err
+		}
+
+		err = endSnapshotVersions(ctx, tx, webhookId, polledAt, pageIDs)
+		if err != nil {
+			return err
+		}
+
+		return insertPollEvents(ctx, tx, events)
+	})
+}
+
+// insertPollEvents stores events generated by a poll and queues them in the
+// outbox.
+func insertPollEvents(ctx context.Context, tx pgx.Tx, events []models.EventsToSend) error {
+	// The trace context is stored with each outbox row so the relay can
+	// continue the poll's trace when it publishes the event.
+	headers := tracing.InjectMap(ctx)
+
+	for _, event := range events {
+		event.ID = uuid.New().String()
+
+		err := insertEvent(ctx, tx, event)
+		if err != nil {
+			return err
+		}
+
+		err = insertOutboxEvent(ctx, tx, event, headers)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordSnapshotVersions ends the current version of every page in the
+// batch that changed and starts one for every page in it that was added or
+// changed, both at polledAt.
+func recordSnapshotVersions(ctx context.Context, tx pgx.Tx, webhookId string, polledAt time.Time, pageIDs []string, lastEdited []time.Time, hashes []string) error {
+	rows, err := tx.Query(ctx, `
+    SELECT v.page_id FROM snapshot_page_versions v
+    JOIN unnest($2::text[], $3::timestamptz[], $4::text[]) AS p(page_id, last_edited_time, property_hash)
+        ON v.page_id = p.page_id
+    WHERE v.webhook_id = $1 AND v.valid_to IS NULL
+        AND (v.last_edited_time <> p.last_edited_time OR v.property_hash <> p.property_hash);`,
+		webhookId, pageIDs, lastEdited, hashes)
+	if err != nil {
+		return err
+	}
+	changed, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return err
+	}
+
+	err = endSnapshotVersions(ctx, tx, webhookId, polledAt, changed)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+    INSERT INTO snapshot_page_versions (webhook_id, page_id, last_edited_time, property_hash, valid_from)
+    SELECT $1, p.page_id, p.last_edited_time, p.property_hash, $5
+    FROM unnest($2::text[], $3::timestamptz[], $4::text[]) AS p(page_id, last_edited_time, property_hash)
+    WHERE NOT EXISTS (
+        SELECT 1 FROM snapshot_page_versions v
+        WHERE v.webhook_id = $1 AND v.page_id = p.page_id AND v.valid_to IS NULL
+    );`, webhookId, pageIDs, lastEdited, hashes, polledAt)
+
+	return err
+}
+
+// endSnapshotVersions ends the current versions of the pages at polledAt.
+// A version that started in the same poll, when a page shows up twice in
+// its query, was never visible and is dropped instead.
+func endSnapshotVersions(ctx context.Context, tx pgx.Tx, webhookId string, polledAt time.Time, pageIDs []string) error {
+	_, err := tx.Exec(ctx, `
+    DELETE FROM snapshot_page_versions
+    WHERE webhook_id = $1 AND page_id = ANY($2) AND valid_to IS NULL AND valid_from >= $3;`,
+		webhookId, pageIDs, polledAt)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+    UPDATE snapshot_page_versions SET valid_to = $3
+    WHERE webhook_id = $1 AND page_id = ANY($2) AND valid_to IS NULL;`,
+		webhookId, pageIDs, polledAt)
+
+	return err
+}
+
+func (p *Postgres) SnapshotAt(ctx context.Context, webhookId string, at time.Time) ([]models.SnapshotPage, error) {
+	query := `
+    SELECT page_id, last_edited_time, property_hash FROM snapshot_page_versions
+    WHERE webhook_id = $1 AND valid_from <= $2 AND (valid_to IS NULL OR valid_to > $2)
+    ORDER BY page_id;`
+
+	rows, err := p.db.Query(ctx, query, webhookId, at)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.SnapshotPage, error) {
+		var page models.SnapshotPage
+		err := row.Scan(&page.PageID, &page.LastEditedTime, &page.PropertyHash)
+		return page, err
+	})
+}
+
+func (p *Postgres) HistoryStart(ctx context.Context, webhookId string) (time.Time, error) {
+	var start *time.Time
+	err := p.db.QueryRow(ctx, `SELECT MIN(valid_from) FROM snapshot_page_versions WHERE webhook_id = $1;`, webhookId).Scan(&start)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if start == nil {
+		return time.Time{}, ErrNotFound
+	}
+
+	return *start, nil
+}
+
+func (p *Postgres) PruneHistory(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := p.db.Exec(ctx, `DELETE FROM snapshot_page_versions WHERE valid_to < $1;`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// snapshotColumns splits pages into one array per column for unnest.
+func snapshotColumns(pages []models.SnapshotPage) ([]string, []time.Time, []string, [][]byte) {
+	pageIDs := make([]string, len(pages))
+	lastEdited := make([]time.Time, len(pages))
+	hashes := make([]string, len(pages))
+	properties := make([][]byte, len(pages))
+	for i, page := range pages {
+		pageIDs[i] = page.PageID
+		lastEdited[i] = page.LastEditedTime
+		hashes[i] = page.PropertyHash
+		properties[i] = page.Properties
+	}
+
+	return pageIDs, lastEdited, hashes, properties
+}
+
+func (p *Postgres) GetDiscussions(ctx context.Context, webhookId string, pageIDs []string) ([]models.Discussion, error) {
+	query := `
+    SELECT discussion_id, page_id, comment_ids FROM comment_discussions
+    WHERE webhook_id = $1 AND page_id = ANY($2)
+    ORDER BY discussion_id;`
+
+	rows, err := p.db.Query(ctx, query, webhookId, pageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Discussion, error) {
+		var discussion models.Discussion
+		err := row.Scan(&discussion.DiscussionID, &discussion.PageID, &discussion.CommentIDs)
+		return discussion, err
+	})
+}
+
+func (p *Postgres) CommentsDue(ctx context.Context, webhookId string, checkedBefore time.Time, limit int) ([]models.CommentCheck, error) {
+	query := `
+    SELECT page_id, COALESCE(comments_checked_at, created_at) FROM snapshot_pages
+    WHERE webhook_id = $1 AND (comments_checked_at IS NULL OR comments_checked_at <= $2)
+    ORDER BY comments_checked_at NULLS FIRST, page_id
+    LIMIT $3;`
+
+	rows, err := p.db.Query(ctx, query, webhookId, checkedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.CommentCheck, error) {
+		var check models.CommentCheck
+		err := row.Scan(&check.PageID, &check.Since)
+		return check, err
+	})
+}
+
+func (p *Postgres) SaveDiscussions(ctx context.Context, webhookId string, pageIDs []string, checkedAt time.Time, discussions []models.Discussion, events []models.EventsToSend) error {
+	discussionIDs := make([]string, len(discussions))
+	for i, discussion := range discussions {
+		discussionIDs[i] = discussion.DiscussionID
+	}
+
+	return pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+        DELETE FROM comment_discussions
+        WHERE webhook_id = $1 AND page_id = ANY($2) AND NOT (discussion_id = ANY($3));`,
+			webhookId, pageIDs, discussionIDs)
+		if err != nil {
+			return err
+		}
+
+		// Each discussion has its own array of comment IDs, which unnest
+		// can't take apart, so they are written one at a time.
+		for _, discussion := range discussions {
+			_, err = tx.Exec(ctx, `
+            INSERT INTO comment_discussions (webhook_id, discussion_id, page_id, comment_ids)
+            VALUES ($1, $2, $3, $4)
+            ON CONFLICT (webhook_id, discussion_id) DO UPDATE
+            SET page_id = EXCLUDED.page_id, comment_ids = EXCLUDED.comment_ids
+            WHERE comment_discussions.page_id <> EXCLUDED.page_id
+                OR comment_discussions.comment_ids <> EXCLUDED.comment_ids;`,
+				webhookId, discussion.DiscussionID, discussion.PageID, discussion.CommentIDs)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.Exec(ctx, `
+        UPDATE snapshot_pages SET comments_checked_at = $3
+        WHERE webhook_id = $1 AND page_id = ANY($2);`,
+			webhookId, pageIDs, checkedAt)
+		if err != nil {
+			return err
+		}
+
+		return insertPollEvents(ctx, tx, events)
+	})
+}
+
+func (p *Postgres) GetNotionAccessToken(ctx context.Context, userId string) (string, error) {
+	query := `SELECT access_token FROM notion_integrations WHERE user_id = $1;`
+
+	var accessToken string
+	err := p.db.QueryRow(ctx, query, userId).Scan(&accessToken)
+	if err != nil {
+		return "", notFound(err)
+	}
+
+	return accessToken, nil
+}
+
+const eventColumns = `id, webhook_id, user_id, type, object_id, data, status, attempts, last_response_code, last_error, created_at, delivered_at`
+
+func scanEvent(row pgx.Row) (models.EventRecord, error) {
+	var event models.EventRecord
+	err := row.Scan(&event.ID, &event.WebhookID, &event.UserID, &event.Type, &event.ObjectID, &event.Data, &event.Status, &event.Attempts, &event.LastResponseCode, &event.LastError, &event.CreatedAt, &event.DeliveredAt)
+	if err != nil {
+		return models.EventRecord{}, notFound(err)
+	}
+
+	return event, nil
+}
+
+func insertEvent(ctx context.Context, tx pgx.Tx, event models.EventsToSend) error {
+	query := `INSERT INTO events (id, webhook_id, user_id, type, object_id, data, status, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, to_timestamp($8));`
+	_, err := tx.Exec(ctx, query, event.ID, event.WebhookID, event.UserID, event.Type, event.Data.ObjectID, event.Data, models.EventStatusPending, event.Data.CreatedAt)
+	return err
+}
+
+// insertOutboxEvent queues event for the events queue once tx commits.
+func insertOutboxEvent(ctx context.Context, tx pgx.Tx, event models.EventsToSend, headers map[string]string) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO event_outbox (id, webhook_id, queue, payload, headers) VALUES ($1, $2, $3, $4, $5);`, uuid.New().String(), event.WebhookID, eventsQueue, payload, headers)
+	return err
+}
+
+func (p *Postgres) GetEvent(ctx context.Context, eventId string) (models.EventRecord, error) {
+	query := `SELECT ` + eventColumns + ` FROM events WHERE id = $1;`
+
+	return scanEvent(p.db.QueryRow(ctx, query, eventId))
+}
+
+func (p *Postgres) ListEvents(ctx context.Context, filter models.EventFilter) ([]models.EventRecord, error) {
+	var conditions []string
+	var args []any
+
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.UserID != "" {
+		addCondition("user_id = $%d", filter.UserID)
+	}
+	if filter.WebhookID != "" {
+		addCondition("webhook_id = $%d", filter.WebhookID)
+	}
+	if filter.Type != "" {
+		addCondition("type = $%d", filter.Type)
+	}
+	if filter.ObjectID != "" {
+		addCondition("object_id = $%d", filter.ObjectID)
+	}
+	if filter.From != nil {
+		addCondition("created_at >= $%d", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("created_at < $%d", *filter.To)
+	}
+
+	query := `SELECT ` + eventColumns + ` FROM events`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, eventsLimit(filter.Limit))
+	query += fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d;", len(args))
+
+	rows, err := p.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.EventRecord, error) {
+		return scanEvent(row)
+	})
+}
+
+func (p *Postgres) RecordDeliveryAttempt(ctx context.Context, eventId string, responseCode int, deliveryErr error) error {
+	status, code, lastError, deliveredAt := deliveryOutcome(responseCode, deliveryErr, p.clock.Now())
+
+	query := `UPDATE events SET status = $1, attempts = attempts + 1, last_response_code = $2, last_error = $3, delivered_at = $4 WHERE id = $5;`
+	_, err := p.db.Exec(ctx, query, status, code, lastError, deliveredAt, eventId)
+	return err
+}
+
+// Replay queues the events through the outbox, carrying the caller's trace.
+func (p *Postgres) Replay(ctx context.Context, eventIds []string) ([]string, error) {
+	headers := tracing.InjectMap(ctx)
+	var replayed []string
+
+	err := pgx.BeginFunc(ctx, p.db, func(tx pgx.Tx) error {
+		replayed = nil
+
+		for _, eventId := range eventIds {
+			event := models.EventsToSend{ID: eventId}
+			query := `UPDATE events SET status = $1 WHERE id = $2 RETURNING webhook_id, user_id, type, data;`
+			err := tx.QueryRow(ctx, query, models.EventStatusPending, eventId).Scan(&event.WebhookID, &event.UserID, &event.Type, &event.Data)
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			err = insertOutboxEvent(ctx, tx, event, headers)
+			if err != nil {
+				return err
+			}
+			replayed = append(replayed, eventId)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return replayed, nil
+}
+
+// deliveryOutcome returns the status, response code, error and delivery
+// time to record for a delivery attempt.
+func deliveryOutcome(responseCode int, deliveryErr error, now time.Time) (status string, code *int, lastError *string, deliveredAt *time.Time) {
+	status = models.EventStatusDelivered
+	if responseCode != 0 {
+		code = &responseCode
+	}
+	if deliveryErr != nil {
+		status = models.EventStatusFailed
+		msg := deliveryErr.Error()
+		lastError = &msg
+	} else {
+		deliveredAt = &now
+	}
+
+	return status, code, lastError, deliveredAt
+}
+
+// ClaimOutbox leases the messages with an UPDATE, so the lease is committed
+// before they are published and no lock is held while the broker confirms
+// them.
+func (p *Postgres) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error) {
+	query := `
+    WITH claimed AS (
+        UPDATE event_outbox
+        SET claimed_until = NOW() + make_interval(secs => $2)
+        WHERE id IN (
+            SELECT id FROM event_outbox
+            WHERE sent_at IS NULL AND (claimed_until IS NULL OR claimed_until <= NOW())
+            ORDER BY created_at
+            LIMIT $1
+            FOR UPDATE SKIP LOCKED
+        )
+        RETURNING id, queue, payload, headers, created_at
+    )
+    SELECT id, queue, payload, headers FROM claimed ORDER BY created_at;`
+
+	rows, err := p.db.Query(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.OutboxMessage, error) {
+		var msg models.OutboxMessage
+		err := row.Scan(&msg.ID, &msg.Queue, &msg.Payload, &msg.Headers)
+		return msg, err
+	})
+}
+
+func (p *Postgres) MarkOutboxSent(ctx context.Context, ids []string) error {
+	_, err := p.db.Exec(ctx, `UPDATE event_outbox SET sent_at = NOW() WHERE id = ANY($1);`, ids)
+	return err
+}
+
+func (p *Postgres) RetryOutbox(ctx context.Context, ids []string) error {
+	_, err := p.db.Exec(ctx, `UPDATE event_outbox SET claimed_until = NULL WHERE id = ANY($1) AND sent_at IS NULL;`, ids)
+	return err
+}
+
+func (p *Postgres) PruneSentOutbox(ctx context.Context, before time.Time) error {
+	_, err := p.db.Exec(ctx, `DELETE FROM event_outbox WHERE sent_at < $1;`, before)
+	return err
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/models"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrWebhookBusy is returned when a webhook can't be claimed because it
+	// is already being polled or is paused.
+	ErrWebhookBusy = errors.New("webhook is processing or paused")
+)
+
+const (
+	// eventsQueue is the queue outbox messages are published to.
+	eventsQueue = "eventsQueue"
+
+	defaultEventsLimit = 100
+	maxEventsLimit     = 1000
+)
+
+// WebhookStore reads webhooks and moves them through the scheduler's
+// statuses.
+type WebhookStore interface {
+	GetWebhook(ctx context.Context, webhookId string) (models.Webhook, error)
+	ListWebhooks(ctx context.Context, filter models.WebhookFilter) ([]models.Webhook, error)
+	GetURL(ctx context.Context, webhookId string) (string, error)
+
+	// ClaimDue moves due, idle webhooks to the processing status and
+	// returns their IDs in the order they should be polled. A user never
+	// has more than perUserLimit webhooks processing at once, and claims
+	// are interleaved round-robin across users so one tenant with many due
+	// webhooks cannot crowd out everyone else. At most maxClaims webhooks
+	// are claimed.
+	ClaimDue(ctx context.Context, perUserLimit int, maxClaims int) ([]string, error)
+	// CountDue returns how many due webhooks are waiting to be claimed.
+	CountDue(ctx context.Context) (int, error)
+	// Claim moves a single idle webhook to the processing status so it can
+	// be polled on demand. It fails with ErrWebhookBusy otherwise.
+	Claim(ctx context.Context, webhookId string) (models.Webhook, error)
+	// Unclaim returns claimed webhooks to idle without recording a poll,
+	// for when they couldn't be queued.
+	Unclaim(ctx context.Context, webhookIds []string) error
+	// ReleaseStaleClaims returns webhooks that have been processing since
+	// before claimedBefore to idle and returns their IDs. Their poll is
+	// presumed lost with the worker running it.
+	ReleaseStaleClaims(ctx context.Context, claimedBefore time.Time) ([]string, error)
+	// ScheduleNextPoll records a finished poll and releases the webhook
+	// back to the scheduler, unless it was paused in the meantime.
+	ScheduleNextPoll(ctx context.Context, webhookId string, lastPolled time.Time, nextPollAt time.Time, effectiveIntervalSeconds int) error
+	// Reschedule hands a claimed webhook back to the scheduler to be polled
+	// at nextPollAt without recording a poll, unless it was paused in the
+	// meantime.
+	Reschedule(ctx context.Context, webhookId string, nextPollAt time.Time) error
+	SetStatus(ctx context.Context, webhookId string, status string) error
+	// Pause stops the scheduler from claiming the webhook until Resume is
+	// called.
+	Pause(ctx context.Context, webhookId string) error
+	Resume(ctx context.Context, webhookId string) error
+	// SetQuery replaces the webhook's query filter and sorts. Nil clears
+	// them.
+	SetQuery(ctx context.Context, webhookId string, filter json.RawMessage, sorts json.RawMessage) error
+}
+
+// SnapshotStore holds the last polled state of each webhook's database, one
+// row per page.
+type SnapshotStore interface {
+	// GetSnapshot returns the webhook's snapshot ordered by page ID. It is
+	// empty until the initial poll has stored one.
+	GetSnapshot(ctx context.Context, webhookId string) ([]models.SnapshotPage, error)
+	// BeginSnapshot starts a new generation of the webhook's snapshot for a
+	// poll and returns it. The poll saves the pages it sees in batches
+	// marked with the generation, and whatever is left unmarked when it has
+	// seen every page was deleted.
+	BeginSnapshot(ctx context.Context, webhookId string) (int64, error)
+	// DiffSnapshot compares a batch of polled pages with the webhook's
+	// snapshot. Pages in the batch that aren't in the snapshot were added
+	// and those whose last_edited_time or property hash changed were
+	// updated. Deleted pages are found with UnseenPages.
+	DiffSnapshot(ctx context.Context, webhookId string, pages []models.SnapshotPage) (models.SnapshotDiff, error)
+	// SaveSnapshotBatch writes a batch of polled pages to the webhook's
+	// snapshot, marked as seen in generation, and stores the events
+	// generated from them for delivery. Either the batch and all of its
+	// events are stored, or neither is, so a crash can't lose events or
+	// generate them twice. Each event is given an ID. Each change to the
+	// snapshot is also kept as a version in its history, current from
+	// polledAt. Every batch of a poll passes the same polledAt, so the
+	// history never holds part of a poll.
+	SaveSnapshotBatch(ctx context.Context, webhookId string, generation int64, polledAt time.Time, pages []models.SnapshotPage, events []models.EventsToSend) error
+	// UnseenPages returns up to limit IDs of pages in the webhook's
+	// snapshot that weren't saved in generation, ordered by ID.
+	UnseenPages(ctx context.Context, webhookId string, generation int64, limit int) ([]string, error)
+	// DeleteSnapshotPages removes pages from the webhook's snapshot and
+	// stores the events generated for them, atomically like
+	// SaveSnapshotBatch. Their versions stop being current at polledAt.
+	DeleteSnapshotPages(ctx context.Context, webhookId string, polledAt time.Time, pageIDs []string, events []models.EventsToSend) error
+
+	// SnapshotAt returns the webhook's snapshot as it was at the given time,
+	// ordered by page ID.
+	SnapshotAt(ctx context.Context, webhookId string, at time.Time) ([]models.SnapshotPage, error)
+	// HistoryStart returns when the webhook's oldest retained version
+	// became current, or ErrNotFound if it has no history.
+	HistoryStart(ctx context.Context, webhookId string) (time.Time, error)
+	// PruneHistory deletes versions that stopped being current before the
+	// given time and returns how many were deleted.
+	PruneHistory(ctx context.Context, before time.Time) (int64, error)
+}
+
+// CommentStore holds the open comment discussions each webhook has seen on
+// its pages, keyed by discussion ID.
+type CommentStore interface {
+	// GetDiscussions returns the webhook's discussions on the given pages,
+	// ordered by discussion ID.
+	GetDiscussions(ctx context.Context, webhookId string, pageIDs []string) ([]models.Discussion, error)
+	// CommentsDue returns up to limit of the webhook's snapshot pages whose
+	// comments were last checked at or before checkedBefore, pages that
+	// were never checked first, then the least recently checked. Since is
+	// the last check or, for a page never checked, when the snapshot first
+	// had it.
+	CommentsDue(ctx context.Context, webhookId string, checkedBefore time.Time, limit int) ([]models.CommentCheck, error)
+	// SaveDiscussions replaces the webhook's discussions on the given pages
+	// with discussions, records that their comments were checked at
+	// checkedAt and stores the events generated from them for delivery,
+	// atomically like SaveSnapshotBatch. Pages with no discussions are left
+	// with none.
+	SaveDiscussions(ctx context.Context, webhookId string, pageIDs []string, checkedAt time.Time, discussions []models.Discussion, events []models.EventsToSend) error
+}
+
+type IntegrationStore interface {
+	GetNotionAccessToken(ctx context.Context, userId string) (string, error)
+}
+
+// EventStore keeps the history of generated events and their delivery.
+type EventStore interface {
+	GetEvent(ctx context.Context, eventId string) (models.EventRecord, error)
+	// ListEvents returns stored events matching filter, newest first.
+	ListEvents(ctx context.Context, filter models.EventFilter) ([]models.EventRecord, error)
+	// RecordDeliveryAttempt stores the outcome of one attempt to deliver an
+	// event to the webhook's endpoint.
+	RecordDeliveryAttempt(ctx context.Context, eventId string, responseCode int, deliveryErr error) error
+	// Replay marks the given events as pending and queues them for delivery
+	// again. It returns the IDs that were found.
+	Replay(ctx context.Context, eventIds []string) ([]string, error)
+}
+
+// OutboxStore holds the messages committed with the events they carry until
+// the outbox relay has published them.
+type OutboxStore interface {
+	// ClaimOutbox leases up to limit unsent messages, oldest first, for
+	// lease. Other relays skip them until the lease runs out, so a relay
+	// that dies mid-batch only delays its messages.
+	ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxMessage, error)
+	// MarkOutboxSent records that the broker confirmed the messages.
+	MarkOutboxSent(ctx context.Context, ids []string) error
+	// RetryOutbox ends the lease on messages that weren't published, so the
+	// next claim picks them up again.
+	RetryOutbox(ctx context.Context, ids []string) error
+	// PruneSentOutbox deletes messages sent before the given time.
+	PruneSentOutbox(ctx context.Context, before time.Time) error
+}
+
+// Stores bundles the stores a process needs.
+type Stores struct {
+	Webhooks     WebhookStore
+	Snapshots    SnapshotStore
+	Comments     CommentStore
+	Integrations IntegrationStore
+	Events       EventStore
+	Outbox       OutboxStore
+}
+
+func eventsLimit(limit int) int {
+	if limit <= 0 {
+		return defaultEventsLimit
+	}
+	if limit > maxEventsLimit {
+		return maxEventsLimit
+	}
+
+	return limit
+}
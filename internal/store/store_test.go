@@ -0,0 +1,877 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/migrations"
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// fixture is a store under test with the means to seed it, since the store
+// interfaces can't create webhooks.
+type fixture struct {
+	stores store.Stores
+	// addWebhook stores a webhook. IDs must be UUIDs.
+	addWebhook func(t *testing.T, webhook models.Webhook)
+	// outbox returns the events queued since the last call.
+	outbox func(t *testing.T) []models.EventsToSend
+}
+
+func TestMemory(t *testing.T) {
+	runContract(t, func(t *testing.T) fixture {
+		mem := store.NewMemory(clock.Real())
+		return fixture{
+			stores: store.MemoryStores(mem),
+			addWebhook: func(t *testing.T, webhook models.Webhook) {
+				mem.AddWebhook(webhook)
+			},
+			outbox: func(t *testing.T) []models.EventsToSend {
+				return mem.DrainOutbox()
+			},
+		}
+	})
+}
+
+// TestPostgres runs the contract against the database in
+// TEST_DATABASE_URL, which it migrates and empties. It is skipped without
+// one.
+func TestPostgres(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	db, err := pgxpool.New(ctx, url)
+	if err != nil {
+		t.Fatalf("connecting to Postgres: %v", err)
+	}
+	defer db.Close()
+	if _, err := migrations.Up(ctx, db); err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+
+	runContract(t, func(t *testing.T) fixture {
+		if _, err := db.Exec(ctx, `TRUNCATE webhooks, notion_integrations CASCADE;`); err != nil {
+			t.Fatalf("emptying tables: %v", err)
+		}
+
+		return fixture{
+			stores: store.PostgresStores(db, clock.Real()),
+			addWebhook: func(t *testing.T, webhook models.Webhook) {
+				t.Helper()
+
+				nextPollAt := time.Now()
+				if webhook.NextPollAt != nil {
+					nextPollAt = *webhook.NextPollAt
+				}
+				_, err := db.Exec(ctx, `
+                INSERT INTO webhooks (id, user_id, url, events, is_active, status, notion_object_id, notion_object_type, next_poll_at)
+                VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);`,
+					webhook.ID, webhook.UserID, webhook.URL, webhook.Events, webhook.IsActive, webhook.Status, webhook.NotionObjectID, webhook.NotionObjectType, nextPollAt)
+				if err != nil {
+					t.Fatalf("inserting webhook: %v", err)
+				}
+			},
+			outbox: func(t *testing.T) []models.EventsToSend {
+				t.Helper()
+
+				rows, err := db.Query(ctx, `UPDATE event_outbox SET sent_at = NOW() WHERE sent_at IS NULL RETURNING payload;`)
+				if err != nil {
+					t.Fatalf("reading outbox: %v", err)
+				}
+				defer rows.Close()
+
+				var events []models.EventsToSend
+				for rows.Next() {
+					var event models.EventsToSend
+					if err := rows.Scan(&event); err != nil {
+						t.Fatalf("scanning outbox: %v", err)
+					}
+					events = append(events, event)
+				}
+				if err := rows.Err(); err != nil {
+					t.Fatalf("reading outbox: %v", err)
+				}
+
+				return events
+			},
+		}
+	})
+}
+
+// runContract runs the behaviour every store implementation must share.
+// newFixture returns an empty store for each test.
+func runContract(t *testing.T, newFixture func(t *testing.T) fixture) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, f fixture)
+	}{
+		{"ClaimDue", testClaimDue},
+		{"ClaimAndRelease", testClaimAndRelease},
+		{"Unclaim", testUnclaim},
+		{"SnapshotGenerations", testSnapshotGenerations},
+		{"DiffSnapshotHashes", testDiffSnapshotHashes},
+		{"SnapshotHistory", testSnapshotHistory},
+		{"RepeatedPage", testRepeatedPage},
+		{"Discussions", testDiscussions},
+		{"CommentsDue", testCommentsDue},
+		{"Events", testEvents},
+		{"Outbox", testOutbox},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newFixture(t))
+		})
+	}
+}
+
+// webhook returns an active, idle webhook due at nextPollAt.
+func webhook(userId string, nextPollAt time.Time) models.Webhook {
+	return models.Webhook{
+		ID:               uuid.New().String(),
+		UserID:           userId,
+		URL:              "https://example.com/hooks",
+		Events:           []string{"page.added", "page.deleted", "page.updated"},
+		IsActive:         true,
+		Status:           models.WebhookStatusIdle,
+		NotionObjectID:   uuid.New().String(),
+		NotionObjectType: "database",
+		NextPollAt:       &nextPollAt,
+	}
+}
+
+func testClaimDue(t *testing.T, f fixture) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	a1 := webhook("user-a", now.Add(-3*time.Minute))
+	a2 := webhook("user-a", now.Add(-2*time.Minute))
+	a3 := webhook("user-a", now.Add(-time.Minute))
+	b1 := webhook("user-b", now.Add(-90*time.Second))
+	// user-c is already at the limit.
+	c1 := webhook("user-c", now.Add(-5*time.Minute))
+	c1.Status = models.WebhookStatusProcessing
+	c2 := webhook("user-c", now.Add(-5*time.Minute))
+	c2.Status = models.WebhookStatusProcessing
+	c3 := webhook("user-c", now.Add(-4*time.Minute))
+	later := webhook("user-b", now.Add(time.Hour))
+	paused := webhook("user-b", now.Add(-time.Hour))
+	paused.Status = models.WebhookStatusPaused
+	inactive := webhook("user-b", now.Add(-time.Hour))
+	inactive.IsActive = false
+	for _, hook := range []models.Webhook{a1, a2, a3, b1, c1, c2, c3, later, paused, inactive} {
+		f.addWebhook(t, hook)
+	}
+
+	due, err := f.stores.Webhooks.CountDue(ctx)
+	if err != nil {
+		t.Fatalf("CountDue: %v", err)
+	}
+	if due != 5 {
+		t.Errorf("got %d due, want 5", due)
+	}
+
+	// Claims are interleaved across users and capped per user.
+	claimed, err := f.stores.Webhooks.ClaimDue(ctx, 2, 10)
+	if err != nil {
+		t.Fatalf("ClaimDue: %v", err)
+	}
+	if want := []string{a1.ID, b1.ID, a2.ID}; !reflect.DeepEqual(claimed, want) {
+		t.Errorf("got claims %v, want %v", claimed, want)
+	}
+	for _, id := range claimed {
+		hook, err := f.stores.Webhooks.GetWebhook(ctx, id)
+		if err != nil {
+			t.Fatalf("GetWebhook: %v", err)
+		}
+		if hook.Status != models.WebhookStatusProcessing {
+			t.Errorf("claimed webhook %s is %s", id, hook.Status)
+		}
+	}
+
+	claimed, err = f.stores.Webhooks.ClaimDue(ctx, 3, 1)
+	if err != nil {
+		t.Fatalf("ClaimDue: %v", err)
+	}
+	if want := []string{c3.ID}; !reflect.DeepEqual(claimed, want) {
+		t.Errorf("got claims %v with a limit of one, want %v", claimed, want)
+	}
+}
+
+func testClaimAndRelease(t *testing.T, f fixture) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	hook := webhook("user-a", now)
+	f.addWebhook(t, hook)
+
+	claimed, err := f.stores.Webhooks.Claim(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	if claimed.Status != models.WebhookStatusProcessing {
+		t.Errorf("got status %s after Claim, want processing", claimed.Status)
+	}
+	if _, err := f.stores.Webhooks.Claim(ctx, hook.ID); !errors.Is(err, store.ErrWebhookBusy) {
+		t.Errorf("got %v claiming a processing webhook, want ErrWebhookBusy", err)
+	}
+	if _, err := f.stores.Webhooks.Claim(ctx, uuid.New().String()); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("got %v claiming a missing webhook, want ErrNotFound", err)
+	}
+
+	// Pausing while a poll runs outlasts the poll.
+	if err := f.stores.Webhooks.Pause(ctx, hook.ID); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	next := now.Add(time.Minute)
+	if err := f.stores.Webhooks.ScheduleNextPoll(ctx, hook.ID, now, next, 60); err != nil {
+		t.Fatalf("ScheduleNextPoll: %v", err)
+	}
+	got, err := f.stores.Webhooks.GetWebhook(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	if got.Status != models.WebhookStatusPaused || got.NextPollAt == nil || !got.NextPollAt.Equal(next) || got.EffectiveIntervalSeconds != 60 {
+		t.Errorf("got %+v after a paused poll finished, want paused until %s", got, next)
+	}
+
+	if err := f.stores.Webhooks.Resume(ctx, hook.ID); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	got, err = f.stores.Webhooks.GetWebhook(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	if got.Status != models.WebhookStatusIdle {
+		t.Errorf("got status %s after Resume, want idle", got.Status)
+	}
+	// Rescheduling hands the webhook back without recording a poll.
+	if _, err := f.stores.Webhooks.Claim(ctx, hook.ID); err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	retry := now.Add(5 * time.Minute)
+	if err := f.stores.Webhooks.Reschedule(ctx, hook.ID, retry); err != nil {
+		t.Fatalf("Reschedule: %v", err)
+	}
+	got, err = f.stores.Webhooks.GetWebhook(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	if got.Status != models.WebhookStatusIdle || got.NextPollAt == nil || !got.NextPollAt.Equal(retry) || got.LastPolled == nil || !got.LastPolled.Equal(now) {
+		t.Errorf("got %+v after Reschedule, want idle until %s and last polled at %s", got, retry, now)
+	}
+}
+
+func testUnclaim(t *testing.T, f fixture) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	queued := webhook("user-a", now)
+	stale := webhook("user-a", now)
+	paused := webhook("user-a", now)
+	for _, hook := range []models.Webhook{queued, stale, paused} {
+		f.addWebhook(t, hook)
+		if _, err := f.stores.Webhooks.Claim(ctx, hook.ID); err != nil {
+			t.Fatalf("Claim: %v", err)
+		}
+	}
+	if err := f.stores.Webhooks.Pause(ctx, paused.ID); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+
+	if err := f.stores.Webhooks.Unclaim(ctx, []string{queued.ID, paused.ID}); err != nil {
+		t.Fatalf("Unclaim: %v", err)
+	}
+
+	released, err := f.stores.Webhooks.ReleaseStaleClaims(ctx, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("ReleaseStaleClaims: %v", err)
+	}
+	if len(released) != 0 {
+		t.Errorf("released %v claimed within the timeout", released)
+	}
+	released, err = f.stores.Webhooks.ReleaseStaleClaims(ctx, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("ReleaseStaleClaims: %v", err)
+	}
+	if !reflect.DeepEqual(released, []string{stale.ID}) {
+		t.Errorf("released %v, want only the webhook still processing", released)
+	}
+
+	want := map[string]string{queued.ID: models.WebhookStatusIdle, stale.ID: models.WebhookStatusIdle, paused.ID: models.WebhookStatusPaused}
+	for id, status := range want {
+		hook, err := f.stores.Webhooks.GetWebhook(ctx, id)
+		if err != nil {
+			t.Fatalf("GetWebhook: %v", err)
+		}
+		if hook.Status != status {
+			t.Errorf("webhook %s is %s, want %s", id, hook.Status, status)
+		}
+	}
+}
+
+func page(id string, lastEdited time.Time, hash string) models.SnapshotPage {
+	return models.SnapshotPage{PageID: id, LastEditedTime: lastEdited, PropertyHash: hash}
+}
+
+func pageIDs(pages []models.SnapshotPage) []string {
+	ids := make([]string, len(pages))
+	for i, page := range pages {
+		ids[i] = page.PageID
+	}
+
+	return ids
+}
+
+func pageEvent(hook models.Webhook, eventType string, pageID string) models.EventsToSend {
+	return models.EventsToSend{
+		Type:      eventType,
+		UserID:    hook.UserID,
+		WebhookID: hook.ID,
+		Data:      models.EventData{ObjectID: pageID, ObjectType: "page", CreatedAt: time.Now().Unix()},
+	}
+}
+
+func testSnapshotGenerations(t *testing.T, f fixture) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	edited := now.Add(-time.Hour)
+
+	hook := webhook("user-a", now)
+	f.addWebhook(t, hook)
+
+	if _, err := f.stores.Snapshots.BeginSnapshot(ctx, uuid.New().String()); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("got %v starting a snapshot of a missing webhook, want ErrNotFound", err)
+	}
+
+	// The first poll saves the pages in two batches.
+	first, err := f.stores.Snapshots.BeginSnapshot(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("BeginSnapshot: %v", err)
+	}
+	for _, batch := range [][]models.SnapshotPage{
+		{page("p1", edited, "h1"), page("p2", edited, "h2")},
+		{page("p3", edited, "h3")},
+	} {
+		diff, err := f.stores.Snapshots.DiffSnapshot(ctx, hook.ID, batch)
+		if err != nil {
+			t.Fatalf("DiffSnapshot: %v", err)
+		}
+		if want := pageIDs(batch); !reflect.DeepEqual(diff.Added, want) || len(diff.Updated) != 0 {
+			t.Errorf("got diff %+v of a new batch, want %v added", diff, want)
+		}
+		if err := f.stores.Snapshots.SaveSnapshotBatch(ctx, hook.ID, first, now, batch, nil); err != nil {
+			t.Fatalf("SaveSnapshotBatch: %v", err)
+		}
+	}
+	unseen, err := f.stores.Snapshots.UnseenPages(ctx, hook.ID, first, 10)
+	if err != nil {
+		t.Fatalf("UnseenPages: %v", err)
+	}
+	if len(unseen) != 0 {
+		t.Errorf("got unseen pages %v after saving every page", unseen)
+	}
+
+	// The second poll only sees p2, which was edited, and p3.
+	second, err := f.stores.Snapshots.BeginSnapshot(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("BeginSnapshot: %v", err)
+	}
+	if second <= first {
+		t.Errorf("got generation %d after %d", second, first)
+	}
+	batch := []models.SnapshotPage{page("p2", now, "h2"), page("p3", edited, "h3")}
+	diff, err := f.stores.Snapshots.DiffSnapshot(ctx, hook.ID, batch)
+	if err != nil {
+		t.Fatalf("DiffSnapshot: %v", err)
+	}
+	if !reflect.DeepEqual(diff, models.SnapshotDiff{Updated: []string{"p2"}}) {
+		t.Errorf("got diff %+v, want p2 updated", diff)
+	}
+	events := []models.EventsToSend{pageEvent(hook, "page.updated", "p2")}
+	if err := f.stores.Snapshots.SaveSnapshotBatch(ctx, hook.ID, second, now, batch, events); err != nil {
+		t.Fatalf("SaveSnapshotBatch: %v", err)
+	}
+
+	unseen, err = f.stores.Snapshots.UnseenPages(ctx, hook.ID, second, 10)
+	if err != nil {
+		t.Fatalf("UnseenPages: %v", err)
+	}
+	if !reflect.DeepEqual(unseen, []string{"p1"}) {
+		t.Fatalf("got unseen pages %v, want [p1]", unseen)
+	}
+	events = []models.EventsToSend{pageEvent(hook, "page.deleted", "p1")}
+	if err := f.stores.Snapshots.DeleteSnapshotPages(ctx, hook.ID, now, unseen, events); err != nil {
+		t.Fatalf("DeleteSnapshotPages: %v", err)
+	}
+
+	snapshot, err := f.stores.Snapshots.GetSnapshot(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("GetSnapshot: %v", err)
+	}
+	if got := pageIDs(snapshot); !reflect.DeepEqual(got, []string{"p2", "p3"}) {
+		t.Errorf("got snapshot %v, want [p2 p3]", got)
+	}
+	if !snapshot[0].LastEditedTime.Equal(now) {
+		t.Errorf("got p2 last edited at %s, want %s", snapshot[0].LastEditedTime, now)
+	}
+
+	var got []string
+	for _, event := range f.outbox(t) {
+		if event.ID == "" {
+			t.Errorf("queued event %s has no ID", event.Type)
+		}
+		got = append(got, event.Type+" "+event.Data.ObjectID)
+	}
+	sort.Strings(got)
+	if want := []string{"page.deleted p1", "page.updated p2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got queued events %v, want %v", got, want)
+	}
+}
+
+func testDiffSnapshotHashes(t *testing.T, f fixture) {
+	ctx := context.Background()
+	edited := time.Now().Truncate(time.Second).Add(-time.Hour)
+
+	hook := webhook("user-a", edited)
+	f.addWebhook(t, hook)
+
+	generation, err := f.stores.Snapshots.BeginSnapshot(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("BeginSnapshot: %v", err)
+	}
+	// p2 was carried over from before pages were hashed.
+	stored := []models.SnapshotPage{page("p1", edited, "a"), page("p2", edited, "")}
+	if err := f.stores.Snapshots.SaveSnapshotBatch(ctx, hook.ID, generation, edited, stored, nil); err != nil {
+		t.Fatalf("SaveSnapshotBatch: %v", err)
+	}
+
+	diff, err := f.stores.Snapshots.DiffSnapshot(ctx, hook.ID, []models.SnapshotPage{page("p1", edited, "b"), page("p2", edited, "b")})
+	if err != nil {
+		t.Fatalf("DiffSnapshot: %v", err)
+	}
+	if !reflect.DeepEqual(diff, models.SnapshotDiff{Updated: []string{"p1"}}) {
+		t.Errorf("got diff %+v, want p1 updated by its hash alone", diff)
+	}
+}
+
+func testRepeatedPage(t *testing.T, f fixture) {
+	ctx := context.Background()
+	polledAt := time.Now().Truncate(time.Second).Add(-time.Hour)
+
+	hook := webhook("user-a", polledAt)
+	f.addWebhook(t, hook)
+
+	generation, err := f.stores.Snapshots.BeginSnapshot(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("BeginSnapshot: %v", err)
+	}
+	// Notion returned p1 twice, edited in between.
+	batch := []models.SnapshotPage{page("p1", polledAt.Add(-time.Minute), "a"), page("p2", polledAt, "a"), page("p1", polledAt, "b")}
+	if err := f.stores.Snapshots.SaveSnapshotBatch(ctx, hook.ID, generation, polledAt, batch, nil); err != nil {
+		t.Fatalf("SaveSnapshotBatch: %v", err)
+	}
+
+	want := []models.SnapshotPage{page("p1", polledAt, "b"), page("p2", polledAt, "a")}
+	got, err := f.stores.Snapshots.GetSnapshot(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("GetSnapshot: %v", err)
+	}
+	if !samePages(got, want) {
+		t.Errorf("got snapshot %+v, want %+v", got, want)
+	}
+	got, err = f.stores.Snapshots.SnapshotAt(ctx, hook.ID, polledAt)
+	if err != nil {
+		t.Fatalf("SnapshotAt: %v", err)
+	}
+	if !samePages(got, want) {
+		t.Errorf("got history %+v, want %+v", got, want)
+	}
+}
+
+func testSnapshotHistory(t *testing.T, f fixture) {
+	ctx := context.Background()
+	t1 := time.Now().Truncate(time.Second).Add(-time.Hour)
+	t2 := t1.Add(30 * time.Minute)
+
+	hook := webhook("user-a", t1)
+	f.addWebhook(t, hook)
+
+	if _, err := f.stores.Snapshots.HistoryStart(ctx, hook.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("got %v for the start of an empty history, want ErrNotFound", err)
+	}
+
+	poll := func(polledAt time.Time, batches ...[]models.SnapshotPage) {
+		t.Helper()
+
+		generation, err := f.stores.Snapshots.BeginSnapshot(ctx, hook.ID)
+		if err != nil {
+			t.Fatalf("BeginSnapshot: %v", err)
+		}
+		for _, batch := range batches {
+			if err := f.stores.Snapshots.SaveSnapshotBatch(ctx, hook.ID, generation, polledAt, batch, nil); err != nil {
+				t.Fatalf("SaveSnapshotBatch: %v", err)
+			}
+		}
+		unseen, err := f.stores.Snapshots.UnseenPages(ctx, hook.ID, generation, 10)
+		if err != nil {
+			t.Fatalf("UnseenPages: %v", err)
+		}
+		if err := f.stores.Snapshots.DeleteSnapshotPages(ctx, hook.ID, polledAt, unseen, nil); err != nil {
+			t.Fatalf("DeleteSnapshotPages: %v", err)
+		}
+	}
+
+	poll(t1, []models.SnapshotPage{page("p1", t1, "a"), page("p2", t1, "a")})
+	// p1 changes between the batches of the second poll, as when it moves
+	// while the poll pages through the query.
+	poll(t2, []models.SnapshotPage{page("p1", t2, "b")}, []models.SnapshotPage{page("p1", t2, "c")})
+
+	for _, tt := range []struct {
+		at   time.Time
+		want []models.SnapshotPage
+	}{
+		{t1.Add(-time.Second), nil},
+		{t1, []models.SnapshotPage{page("p1", t1, "a"), page("p2", t1, "a")}},
+		{t2.Add(-time.Second), []models.SnapshotPage{page("p1", t1, "a"), page("p2", t1, "a")}},
+		{t2, []models.SnapshotPage{page("p1", t2, "c")}},
+	} {
+		got, err := f.stores.Snapshots.SnapshotAt(ctx, hook.ID, tt.at)
+		if err != nil {
+			t.Fatalf("SnapshotAt: %v", err)
+		}
+		if !samePages(got, tt.want) {
+			t.Errorf("got snapshot %+v at %s, want %+v", got, tt.at, tt.want)
+		}
+	}
+
+	start, err := f.stores.Snapshots.HistoryStart(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("HistoryStart: %v", err)
+	}
+	if !start.Equal(t1) {
+		t.Errorf("got history start %s, want %s", start, t1)
+	}
+
+	pruned, err := f.stores.Snapshots.PruneHistory(ctx, t2.Add(time.Second))
+	if err != nil {
+		t.Fatalf("PruneHistory: %v", err)
+	}
+	if pruned != 2 {
+		t.Errorf("pruned %d versions, want the 2 that ended at %s", pruned, t2)
+	}
+	start, err = f.stores.Snapshots.HistoryStart(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("HistoryStart: %v", err)
+	}
+	if !start.Equal(t2) {
+		t.Errorf("got history start %s after pruning, want %s", start, t2)
+	}
+}
+
+// samePages compares the fields both stores keep in the history.
+func samePages(got, want []models.SnapshotPage) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i].PageID != want[i].PageID || !got[i].LastEditedTime.Equal(want[i].LastEditedTime) || got[i].PropertyHash != want[i].PropertyHash {
+			return false
+		}
+	}
+
+	return true
+}
+
+func testDiscussions(t *testing.T, f fixture) {
+	ctx := context.Background()
+
+	hook := webhook("user-a", time.Now())
+	f.addWebhook(t, hook)
+
+	save := func(pageIDs []string, discussions ...models.Discussion) {
+		t.Helper()
+		if err := f.stores.Comments.SaveDiscussions(ctx, hook.ID, pageIDs, time.Now(), discussions, nil); err != nil {
+			t.Fatalf("SaveDiscussions: %v", err)
+		}
+	}
+	get := func(pageIDs ...string) []models.Discussion {
+		t.Helper()
+		discussions, err := f.stores.Comments.GetDiscussions(ctx, hook.ID, pageIDs)
+		if err != nil {
+			t.Fatalf("GetDiscussions: %v", err)
+		}
+		return discussions
+	}
+
+	d1 := models.Discussion{DiscussionID: "d1", PageID: "a", CommentIDs: []string{"c1"}}
+	d2 := models.Discussion{DiscussionID: "d2", PageID: "a", CommentIDs: []string{"c2", "c3"}}
+	d3 := models.Discussion{DiscussionID: "d3", PageID: "b", CommentIDs: []string{"c4"}}
+	save([]string{"a", "b"}, d1, d2, d3)
+
+	if got := get("a"); !reflect.DeepEqual(got, []models.Discussion{d1, d2}) {
+		t.Errorf("got discussions %+v on a, want d1 and d2", got)
+	}
+
+	// Saving a page replaces its discussions and leaves other pages alone.
+	d1.CommentIDs = append(d1.CommentIDs, "c5")
+	save([]string{"a"}, d1)
+	if got := get("a", "b"); !reflect.DeepEqual(got, []models.Discussion{d1, d3}) {
+		t.Errorf("got discussions %+v, want d1 with a reply and d3", got)
+	}
+
+	save([]string{"b"})
+	if got := get("a", "b"); !reflect.DeepEqual(got, []models.Discussion{d1}) {
+		t.Errorf("got discussions %+v after clearing b, want d1", got)
+	}
+}
+
+func testCommentsDue(t *testing.T, f fixture) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	hook := webhook("user-a", now)
+	f.addWebhook(t, hook)
+
+	generation, err := f.stores.Snapshots.BeginSnapshot(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("BeginSnapshot: %v", err)
+	}
+	pages := []models.SnapshotPage{page("a", now, "a"), page("b", now, "a"), page("c", now, "a")}
+	if err := f.stores.Snapshots.SaveSnapshotBatch(ctx, hook.ID, generation, now, pages, nil); err != nil {
+		t.Fatalf("SaveSnapshotBatch: %v", err)
+	}
+
+	due := func(checkedBefore time.Time, limit int) []models.CommentCheck {
+		t.Helper()
+		checks, err := f.stores.Comments.CommentsDue(ctx, hook.ID, checkedBefore, limit)
+		if err != nil {
+			t.Fatalf("CommentsDue: %v", err)
+		}
+		return checks
+	}
+	ids := func(checks []models.CommentCheck) []string {
+		ids := make([]string, len(checks))
+		for i, check := range checks {
+			ids[i] = check.PageID
+		}
+		return ids
+	}
+
+	if got := ids(due(now.Add(-time.Hour), 10)); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("got %v due before any check, want every page", got)
+	}
+
+	checked := now.Add(-2 * time.Hour)
+	for _, id := range []string{"b", "a"} {
+		if err := f.stores.Comments.SaveDiscussions(ctx, hook.ID, []string{id}, checked, nil, nil); err != nil {
+			t.Fatalf("SaveDiscussions: %v", err)
+		}
+		checked = checked.Add(time.Hour)
+	}
+
+	// c was never checked, b was checked before a and a too recently.
+	got := due(now.Add(-90*time.Minute), 10)
+	if !reflect.DeepEqual(ids(got), []string{"c", "b"}) {
+		t.Fatalf("got %v due, want c then b", ids(got))
+	}
+	if !got[1].Since.Equal(now.Add(-2 * time.Hour)) {
+		t.Errorf("got b due since %s, want its last check at %s", got[1].Since, now.Add(-2*time.Hour))
+	}
+	if got := ids(due(now, 1)); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("got %v due with a limit of 1, want c", got)
+	}
+}
+
+func testEvents(t *testing.T, f fixture) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	hook := webhook("user-a", now)
+	f.addWebhook(t, hook)
+
+	generation, err := f.stores.Snapshots.BeginSnapshot(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("BeginSnapshot: %v", err)
+	}
+	added := pageEvent(hook, "page.added", "p1")
+	added.Data.CreatedAt = now.Add(-time.Minute).Unix()
+	updated := pageEvent(hook, "page.updated", "p2")
+	updated.Data.CreatedAt = now.Unix()
+	pages := []models.SnapshotPage{page("p1", now, "a"), page("p2", now, "a")}
+	if err := f.stores.Snapshots.SaveSnapshotBatch(ctx, hook.ID, generation, now, pages, []models.EventsToSend{added, updated}); err != nil {
+		t.Fatalf("SaveSnapshotBatch: %v", err)
+	}
+	f.outbox(t)
+
+	events, err := f.stores.Events.ListEvents(ctx, models.EventFilter{WebhookID: hook.ID})
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if len(events) != 2 || events[0].ObjectID != "p2" || events[1].ObjectID != "p1" {
+		t.Fatalf("got events %+v, want p2 then p1, newest first", events)
+	}
+	for _, event := range events {
+		if event.Status != models.EventStatusPending || event.UserID != hook.UserID {
+			t.Errorf("got stored event %+v, want pending for %s", event, hook.UserID)
+		}
+	}
+
+	filtered, err := f.stores.Events.ListEvents(ctx, models.EventFilter{Type: "page.added"})
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].ObjectID != "p1" {
+		t.Errorf("got %+v filtering by type, want the page.added event", filtered)
+	}
+	from := now
+	filtered, err = f.stores.Events.ListEvents(ctx, models.EventFilter{From: &from})
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if len(filtered) != 1 || filtered[0].ObjectID != "p2" {
+		t.Errorf("got %+v filtering from %s, want the page.updated event", filtered, from)
+	}
+	filtered, err = f.stores.Events.ListEvents(ctx, models.EventFilter{UserID: "user-b"})
+	if err != nil {
+		t.Fatalf("ListEvents: %v", err)
+	}
+	if len(filtered) != 0 {
+		t.Errorf("got %+v filtering by another user, want no events", filtered)
+	}
+
+	id := events[0].ID
+	if err := f.stores.Events.RecordDeliveryAttempt(ctx, id, 500, errors.New("server error")); err != nil {
+		t.Fatalf("RecordDeliveryAttempt: %v", err)
+	}
+	// Postgres keeps microseconds.
+	attempted := time.Now().Truncate(time.Microsecond)
+	if err := f.stores.Events.RecordDeliveryAttempt(ctx, id, 200, nil); err != nil {
+		t.Fatalf("RecordDeliveryAttempt: %v", err)
+	}
+	event, err := f.stores.Events.GetEvent(ctx, id)
+	if err != nil {
+		t.Fatalf("GetEvent: %v", err)
+	}
+	if event.Status != models.EventStatusDelivered || event.Attempts != 2 || event.DeliveredAt == nil || event.LastResponseCode == nil || *event.LastResponseCode != 200 {
+		t.Fatalf("got %+v after a failed and a successful attempt, want delivered after 2", event)
+	}
+	if event.DeliveredAt.Before(attempted) || event.DeliveredAt.After(time.Now()) {
+		t.Errorf("got delivered_at %s, want the store's clock at the attempt, after %s", event.DeliveredAt, attempted)
+	}
+
+	replayed, err := f.stores.Events.Replay(ctx, []string{id, uuid.New().String()})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if !reflect.DeepEqual(replayed, []string{id}) {
+		t.Errorf("got replayed %v, want only the stored event", replayed)
+	}
+	queued := f.outbox(t)
+	if len(queued) != 1 || queued[0].ID != id || queued[0].Data.ObjectID != "p2" {
+		t.Errorf("got queued %+v after Replay, want the replayed event", queued)
+	}
+	event, err = f.stores.Events.GetEvent(ctx, id)
+	if err != nil {
+		t.Fatalf("GetEvent: %v", err)
+	}
+	if event.Status != models.EventStatusPending {
+		t.Errorf("got status %s after Replay, want pending", event.Status)
+	}
+
+	if _, err := f.stores.Events.GetEvent(ctx, uuid.New().String()); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("got %v for a missing event, want ErrNotFound", err)
+	}
+}
+
+func testOutbox(t *testing.T, f fixture) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	hook := webhook("user-a", now)
+	f.addWebhook(t, hook)
+
+	generation, err := f.stores.Snapshots.BeginSnapshot(ctx, hook.ID)
+	if err != nil {
+		t.Fatalf("BeginSnapshot: %v", err)
+	}
+	for _, id := range []string{"p1", "p2"} {
+		events := []models.EventsToSend{pageEvent(hook, "page.added", id)}
+		if err := f.stores.Snapshots.SaveSnapshotBatch(ctx, hook.ID, generation, now, []models.SnapshotPage{page(id, now, "a")}, events); err != nil {
+			t.Fatalf("SaveSnapshotBatch: %v", err)
+		}
+	}
+
+	claim := func() []string {
+		t.Helper()
+		messages, err := f.stores.Outbox.ClaimOutbox(ctx, 1, time.Minute)
+		if err != nil {
+			t.Fatalf("ClaimOutbox: %v", err)
+		}
+		var ids []string
+		for _, msg := range messages {
+			if msg.Queue != "eventsQueue" || msg.Headers == nil {
+				t.Errorf("got message %+v, want one for eventsQueue with headers", msg)
+			}
+			ids = append(ids, msg.ID)
+		}
+		return ids
+	}
+
+	first := claim()
+	second := claim()
+	if len(first) != 1 || len(second) != 1 || first[0] == second[0] {
+		t.Fatalf("got claims %v and %v, want a message each", first, second)
+	}
+	if leased := claim(); len(leased) != 0 {
+		t.Errorf("claimed %v while every message was leased", leased)
+	}
+
+	if err := f.stores.Outbox.RetryOutbox(ctx, first); err != nil {
+		t.Fatalf("RetryOutbox: %v", err)
+	}
+	if retried := claim(); !reflect.DeepEqual(retried, first) {
+		t.Errorf("claimed %v after retrying %v", retried, first)
+	}
+
+	if err := f.stores.Outbox.MarkOutboxSent(ctx, append(first, second...)); err != nil {
+		t.Fatalf("MarkOutboxSent: %v", err)
+	}
+	if err := f.stores.Outbox.RetryOutbox(ctx, first); err != nil {
+		t.Fatalf("RetryOutbox: %v", err)
+	}
+	if sent := claim(); len(sent) != 0 {
+		t.Errorf("claimed %v after every message was sent", sent)
+	}
+	if queued := f.outbox(t); len(queued) != 0 {
+		t.Errorf("got %d events queued after every message was sent", len(queued))
+	}
+	if err := f.stores.Outbox.PruneSentOutbox(ctx, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("PruneSentOutbox: %v", err)
+	}
+}
+
+func TestDiffPages(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Minute)
+
+	previous := []models.SnapshotPage{page("a", t1, "x"), page("b", t1, "x"), page("c", t1, "x"), page("d", t1, "x")}
+	current := []models.SnapshotPage{page("e", t2, "x"), page("b", t2, "x"), page("a", t1, "x"), page("c", t1, "y")}
+
+	got := store.DiffPages(previous, current)
+	want := models.SnapshotDiff{Added: []string{"e"}, Deleted: []string{"d"}, Updated: []string{"b", "c"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,239 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/notion"
+	"github.com/gavsidhu/notion-hooks/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultCommentInterval     = 15 * time.Minute
+	defaultCommentPagesPerPoll = 100
+)
+
+// CommentOptions bounds how much of a database poll goes to comments,
+// which Notion only lists one page at a time.
+type CommentOptions struct {
+	// Interval is how long a page's comments go unchecked before a poll
+	// checks them again. It defaults to 15 minutes.
+	Interval time.Duration
+	// MaxPagesPerPoll caps how many pages' comments a poll checks. Pages
+	// never checked go first, then the least recently checked. It defaults
+	// to 100.
+	MaxPagesPerPoll int
+}
+
+// wantsComments reports whether the webhook subscribes to comment events.
+// Only then are comments polled, since that takes a request per page.
+func wantsComments(webhook models.Webhook) bool {
+	return utils.StringInSlice("comment.added", webhook.Events) || utils.StringInSlice("comment.resolved", webhook.Events)
+}
+
+// previousPoll returns when the webhook was last polled, rounded down to
+// the minute like Notion's created_time, so anything created in the same
+// minute as the previous poll counts as new.
+func previousPoll(webhook models.Webhook) time.Time {
+	polled := webhook.CreatedAt
+	if webhook.LastPolled != nil {
+		polled = *webhook.LastPolled
+	}
+
+	return polled.Truncate(time.Minute)
+}
+
+// pageCommentCheck is the comment check of a page webhook, which checks its
+// page on every poll.
+func pageCommentCheck(webhook models.Webhook) []models.CommentCheck {
+	return []models.CommentCheck{{PageID: webhook.NotionObjectID, Since: previousPoll(webhook)}}
+}
+
+// discussionsOf groups a page's comments into discussions.
+func discussionsOf(pageID string, comments []notion.Comment) []models.Discussion {
+	var discussions []models.Discussion
+	index := make(map[string]int)
+	for _, comment := range comments {
+		i, ok := index[comment.DiscussionID]
+		if !ok {
+			i = len(discussions)
+			index[comment.DiscussionID] = i
+			discussions = append(discussions, models.Discussion{DiscussionID: comment.DiscussionID, PageID: pageID})
+		}
+		discussions[i].CommentIDs = append(discussions[i].CommentIDs, comment.ID)
+	}
+
+	return discussions
+}
+
+// commentEvents compares the comments polled from pages, in order, with
+// the discussions stored for them. A comment that wasn't stored was added,
+// unless it was created before the page's Since, which happens when the
+// webhook starts watching a page that already had comments. Since is
+// rounded down to the minute like Notion's created_time, so anything
+// created in the same minute counts as new. A stored discussion that Notion
+// no longer lists was resolved.
+func commentEvents(webhook models.Webhook, stored []models.Discussion, pages []models.CommentCheck, comments map[string][]notion.Comment, createdAt time.Time) ([]models.EventsToSend, error) {
+	seen := make(map[string]bool)
+	for _, discussion := range stored {
+		for _, id := range discussion.CommentIDs {
+			seen[id] = true
+		}
+	}
+
+	var events []models.EventsToSend
+	event := func(eventType string, data models.EventData) {
+		if !utils.StringInSlice(eventType, webhook.Events) {
+			return
+		}
+		data.CreatedAt = createdAt.Unix()
+		events = append(events, models.EventsToSend{
+			Type:      eventType,
+			UserID:    webhook.UserID,
+			WebhookID: webhook.ID,
+			Data:      data,
+		})
+	}
+
+	open := make(map[string]bool)
+	for _, page := range pages {
+		pageID := page.PageID
+		since := page.Since.Truncate(time.Minute)
+		for _, comment := range comments[pageID] {
+			open[comment.DiscussionID] = true
+			if seen[comment.ID] {
+				continue
+			}
+			created, err := time.Parse(time.RFC3339, comment.CreatedTime)
+			if err == nil && created.Before(since) {
+				continue
+			}
+
+			content, err := json.Marshal(comment.RichText)
+			if err != nil {
+				return nil, fmt.Errorf("marshalling comment %s: %w", comment.ID, err)
+			}
+			event("comment.added", models.EventData{
+				ObjectID:     comment.ID,
+				ObjectType:   "comment",
+				PageID:       pageID,
+				DiscussionID: comment.DiscussionID,
+				AuthorID:     comment.CreatedBy.ID,
+				Content:      content,
+			})
+		}
+	}
+
+	// Notion doesn't say who resolved a discussion, and a discussion whose
+	// only comment was deleted disappears the same way.
+	for _, discussion := range stored {
+		if _, polled := comments[discussion.PageID]; !polled || open[discussion.DiscussionID] {
+			continue
+		}
+		event("comment.resolved", models.EventData{
+			ObjectID:     discussion.DiscussionID,
+			ObjectType:   "discussion",
+			PageID:       discussion.PageID,
+			DiscussionID: discussion.DiscussionID,
+		})
+	}
+
+	return events, nil
+}
+
+// syncDueComments checks the comments on the database pages that are due,
+// at most MaxPagesPerPoll of them, and returns how many events were
+// generated.
+func (p *Processor) syncDueComments(ctx context.Context, notionClient *notion.NotionClient, webhook models.Webhook, polledAt time.Time, emit bool) (int, error) {
+	due, err := p.stores.Comments.CommentsDue(ctx, webhook.ID, polledAt.Add(-p.comments.Interval), p.comments.MaxPagesPerPoll)
+	if err != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":     err,
+			"webhookId": webhook.ID,
+		}).Error("Error getting pages with comments due")
+		return 0, err
+	}
+	if len(due) == 0 {
+		return 0, nil
+	}
+
+	return p.syncComments(ctx, notionClient, webhook, due, polledAt, emit)
+}
+
+// syncComments polls the comments on pages, compares them with the
+// discussions stored for the webhook and saves them as checked at
+// checkedAt, together with the comment events they generate if emit is set.
+// It returns how many events were generated. Pages Notion no longer has are
+// skipped, since the page poll reports them, and so are pages whose
+// comments fail to load, which stay due for the next poll. Only when every
+// page fails is the error returned.
+func (p *Processor) syncComments(ctx context.Context, notionClient *notion.NotionClient, webhook models.Webhook, pages []models.CommentCheck, checkedAt time.Time, emit bool) (int, error) {
+	comments := make(map[string][]notion.Comment, len(pages))
+	var polled []models.CommentCheck
+	var listErr error
+	for _, page := range pages {
+		pageComments, err := notionClient.ListComments(ctx, page.PageID)
+		if errors.Is(err, notion.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, err
+			}
+			p.log.WithFields(logrus.Fields{
+				"error":     err,
+				"webhookId": webhook.ID,
+				"pageId":    page.PageID,
+			}).Warn("Error listing comments on page, skipping it")
+			listErr = err
+			continue
+		}
+		comments[page.PageID] = pageComments
+		polled = append(polled, page)
+	}
+	if len(polled) == 0 {
+		return 0, listErr
+	}
+
+	pageIDs := make([]string, len(polled))
+	var discussions []models.Discussion
+	for i, page := range polled {
+		pageIDs[i] = page.PageID
+		discussions = append(discussions, discussionsOf(page.PageID, comments[page.PageID])...)
+	}
+
+	var eventsToSend []models.EventsToSend
+	if emit {
+		stored, err := p.stores.Comments.GetDiscussions(ctx, webhook.ID, pageIDs)
+		if err != nil {
+			p.log.WithFields(logrus.Fields{
+				"error":     err,
+				"webhookId": webhook.ID,
+			}).Error("Error getting stored discussions")
+			return 0, err
+		}
+
+		eventsToSend, err = commentEvents(webhook, stored, polled, comments, p.clock.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err := p.stores.Comments.SaveDiscussions(ctx, webhook.ID, pageIDs, checkedAt, discussions, eventsToSend)
+	if err != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":     err,
+			"webhookId": webhook.ID,
+		}).Error("Error saving discussions to database")
+		return 0, err
+	}
+
+	countEvents(eventsToSend)
+
+	return len(eventsToSend), nil
+}
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/metrics"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/gavsidhu/notion-hooks/internal/tracing"
+	"github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Publisher publishes a message to a named queue.
+type Publisher interface {
+	Publish(ctx context.Context, queue string, msg amqp091.Publishing) error
+}
+
+// GetWebhooksForProcessing claims active webhooks whose next_poll_at has
+// passed by moving them to the processing status, and queues them for
+// polling. A user never has more than perUserLimit webhooks processing at
+// once, and claims are interleaved round-robin across users so one tenant
+// with many due webhooks cannot crowd out everyone else in the queue. At
+// most maxClaims webhooks are claimed per call. If publishing fails, the
+// webhooks that weren't queued are handed back so the next tick retries
+// them.
+func GetWebhooksForProcessing(ctx context.Context, webhooks store.WebhookStore, publisher Publisher, perUserLimit int, maxClaims int) error {
+	webhookIds, err := webhooks.ClaimDue(ctx, perUserLimit, maxClaims)
+	if err != nil {
+		return err
+	}
+
+	for i, webhookId := range webhookIds {
+		err = enqueueWebhook(ctx, publisher, webhookId)
+		if err != nil {
+			return unclaimWebhooks(webhooks, webhookIds[i:], err)
+		}
+	}
+
+	return nil
+}
+
+// unclaimWebhooks hands back webhooks that couldn't be queued and returns
+// publishErr, or both errors if that fails too. It uses its own context as
+// publishing commonly fails because ctx was cancelled.
+func unclaimWebhooks(webhooks store.WebhookStore, webhookIds []string, publishErr error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	if err := webhooks.Unclaim(ctx, webhookIds); err != nil {
+		return errors.Join(publishErr, err)
+	}
+
+	return publishErr
+}
+
+// enqueueWebhook publishes a claimed webhook to the processing queue. Each
+// poll starts its own trace, which follows it through every later stage.
+func enqueueWebhook(ctx context.Context, publisher Publisher, webhookId string) error {
+	ctx, span := tracing.Tracer.Start(ctx, "scheduler.enqueue",
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("webhook.id", webhookId)),
+	)
+	defer span.End()
+
+	err := publisher.Publish(ctx, "proccessingQueue", amqp091.Publishing{
+		ContentType: "text/plain",
+		Timestamp:   time.Now(),
+		Headers:     tracing.InjectAMQP(ctx, nil),
+		Body:        []byte(webhookId),
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+	}
+
+	return err
+}
+
+// updateSchedulerBacklog reports how many due webhooks are still waiting to
+// be claimed, e.g. because their user is at the concurrency limit.
+func updateSchedulerBacklog(ctx context.Context, webhooks store.WebhookStore) error {
+	backlog, err := webhooks.CountDue(ctx)
+	if err != nil {
+		return err
+	}
+
+	metrics.SchedulerBacklog.Set(float64(backlog))
+
+	return nil
+}
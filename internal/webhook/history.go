@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/sirupsen/logrus"
+)
+
+const historyPruneEvery = time.Hour
+
+var (
+	// ErrHistoryUnavailable is returned for a point in time that is older
+	// than the webhook's retained snapshot history.
+	ErrHistoryUnavailable  = errors.New("snapshot history is not available for that time")
+	ErrInvalidHistoryRange = errors.New("to is before from")
+)
+
+// History answers what changed in a webhook's database between two points
+// in time from the versioned snapshots kept by each poll.
+type History struct {
+	snapshots store.SnapshotStore
+	retention time.Duration
+	clock     clock.Clock
+}
+
+// NewHistory returns a History that keeps versions for retention after
+// they are replaced. A zero retention keeps them forever. A nil clock uses
+// the real one.
+func NewHistory(snapshots store.SnapshotStore, retention time.Duration, clk clock.Clock) *History {
+	if clk == nil {
+		clk = clock.Real()
+	}
+
+	return &History{snapshots: snapshots, retention: retention, clock: clk}
+}
+
+// Start returns the earliest time the webhook's snapshot can be rebuilt
+// for.
+func (h *History) Start(ctx context.Context, webhookId string) (time.Time, error) {
+	start, err := h.snapshots.HistoryStart(ctx, webhookId)
+	if errors.Is(err, store.ErrNotFound) {
+		return time.Time{}, ErrHistoryUnavailable
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Versions that ended before the last prune are gone, so a snapshot
+	// from then would be missing pages.
+	if h.retention > 0 {
+		if pruned := h.clock.Now().Add(-h.retention); pruned.After(start) {
+			start = pruned
+		}
+	}
+
+	return start, nil
+}
+
+// Diff compares the webhook's snapshot at from with the one at to and
+// returns the events a poll at to would have generated if the previous poll
+// had been at from.
+func (h *History) Diff(ctx context.Context, webhook models.Webhook, from, to time.Time) (models.HistoryDiff, error) {
+	if to.Before(from) {
+		return models.HistoryDiff{}, ErrInvalidHistoryRange
+	}
+
+	start, err := h.Start(ctx, webhook.ID)
+	if err != nil {
+		return models.HistoryDiff{}, err
+	}
+	if from.Before(start) {
+		return models.HistoryDiff{}, fmt.Errorf("%w: history starts at %s", ErrHistoryUnavailable, start.Format(time.RFC3339))
+	}
+
+	previous, err := h.snapshots.SnapshotAt(ctx, webhook.ID, from)
+	if err != nil {
+		return models.HistoryDiff{}, err
+	}
+	current, err := h.snapshots.SnapshotAt(ctx, webhook.ID, to)
+	if err != nil {
+		return models.HistoryDiff{}, err
+	}
+
+	diff := store.DiffPages(previous, current)
+	events := diffEvents(webhook.ID, webhook.UserID, webhook.Events, diff, to)
+	if events == nil {
+		events = []models.EventsToSend{}
+	}
+
+	return models.HistoryDiff{
+		WebhookID: webhook.ID,
+		From:      from,
+		To:        to,
+		Diff:      diff,
+		Events:    events,
+	}, nil
+}
+
+// Prune deletes versions older than the retention period.
+func (h *History) Prune(ctx context.Context) (int64, error) {
+	if h.retention <= 0 {
+		return 0, nil
+	}
+
+	return h.snapshots.PruneHistory(ctx, h.clock.Now().Add(-h.retention))
+}
+
+// StartHistoryPruner prunes the snapshot history every hour until ctx is
+// cancelled.
+func StartHistoryPruner(ctx context.Context, log logrus.FieldLogger, history *History) {
+	ticker := history.clock.NewTicker(historyPruneEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		pruned, err := history.Prune(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithFields(logrus.Fields{
+					"error": err,
+				}).Error("Error pruning snapshot history")
+			}
+			continue
+		}
+		if pruned > 0 {
+			log.WithFields(logrus.Fields{
+				"versions": pruned,
+			}).Info("Pruned snapshot history")
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/gavsidhu/notion-hooks/internal/tracing"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	outboxPollInterval = time.Second
+	outboxBatchSize    = 100
+	outboxRetention    = 7 * 24 * time.Hour
+	outboxPruneEvery   = time.Hour
+
+	// outboxLease is how long a claimed batch is left to one relay. It only
+	// runs out if the relay dies or the broker stalls mid-batch.
+	outboxLease = time.Minute
+)
+
+// ConfirmPublisher publishes a message and waits for the broker to confirm
+// it.
+type ConfirmPublisher interface {
+	PublishWithConfirm(ctx context.Context, queue string, msg amqp091.Publishing) error
+}
+
+// StartOutboxRelay publishes committed outbox messages until ctx is
+// cancelled. Messages are only marked as sent once the broker has confirmed
+// them, so every event reaches its queue at least once; the outbox id is
+// sent as the message id for consumers that need to drop the rare
+// redelivery.
+func StartOutboxRelay(ctx context.Context, log logrus.FieldLogger, outbox store.OutboxStore, publisher ConfirmPublisher) {
+	log.Info("Starting event outbox relay.")
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	lastPruned := time.Time{}
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopping event outbox relay.")
+			return
+		case <-ticker.C:
+		}
+
+		for {
+			sent, err := relayOutboxBatch(ctx, outbox, publisher)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.WithFields(logrus.Fields{
+						"error": err,
+					}).Error("Error relaying events from outbox")
+				}
+				break
+			}
+			if sent < outboxBatchSize {
+				break
+			}
+		}
+
+		if time.Since(lastPruned) >= outboxPruneEvery {
+			err := outbox.PruneSentOutbox(ctx, time.Now().Add(-outboxRetention))
+			if err != nil && ctx.Err() == nil {
+				log.WithFields(logrus.Fields{
+					"error": err,
+				}).Error("Error pruning sent events from outbox")
+			}
+			lastPruned = time.Now()
+		}
+	}
+}
+
+// relayOutboxBatch publishes up to outboxBatchSize claimed messages in order
+// and marks the confirmed ones as sent. Publishing stops at the first
+// failure, and the rest of the batch is handed back for the next claim.
+func relayOutboxBatch(ctx context.Context, outbox store.OutboxStore, publisher ConfirmPublisher) (int, error) {
+	messages, err := outbox.ClaimOutbox(ctx, outboxBatchSize, outboxLease)
+	if err != nil {
+		return 0, err
+	}
+
+	var sentIDs, unsentIDs []string
+	var publishErr error
+	for _, msg := range messages {
+		if publishErr == nil {
+			publishErr = publishOutboxMessage(ctx, publisher, msg)
+		}
+		if publishErr != nil {
+			unsentIDs = append(unsentIDs, msg.ID)
+			continue
+		}
+		sentIDs = append(sentIDs, msg.ID)
+	}
+
+	if len(sentIDs) > 0 {
+		if err := outbox.MarkOutboxSent(ctx, sentIDs); err != nil {
+			return 0, err
+		}
+	}
+	if len(unsentIDs) > 0 {
+		if err := outbox.RetryOutbox(ctx, unsentIDs); err != nil {
+			return len(sentIDs), err
+		}
+	}
+
+	return len(sentIDs), publishErr
+}
+
+// publishOutboxMessage publishes msg in the trace of the poll that
+// generated it.
+func publishOutboxMessage(ctx context.Context, publisher ConfirmPublisher, msg models.OutboxMessage) error {
+	parent := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(msg.Headers))
+	ctx, span := tracing.Tracer.Start(parent, "outbox.publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("messaging.destination.name", msg.Queue)),
+	)
+	defer span.End()
+
+	err := publisher.PublishWithConfirm(ctx, msg.Queue, amqp091.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp091.Persistent,
+		MessageId:    msg.ID,
+		Timestamp:    time.Now(),
+		Headers:      tracing.InjectAMQP(ctx, nil),
+		Body:         msg.Payload,
+	})
+	if err != nil {
+		tracing.RecordError(span, err)
+	}
+
+	return err
+}
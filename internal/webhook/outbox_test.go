@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var errNacked = errors.New("broker nacked the message")
+
+// confirmPublisher records confirmed messages and nacks the calls listed in
+// nack, counting from 1.
+type confirmPublisher struct {
+	calls     int
+	nack      map[int]bool
+	published []amqp091.Publishing
+}
+
+func (p *confirmPublisher) PublishWithConfirm(ctx context.Context, queue string, msg amqp091.Publishing) error {
+	p.calls++
+	if p.nack[p.calls] {
+		return errNacked
+	}
+	if queue != "eventsQueue" {
+		return errors.New("unexpected queue " + queue)
+	}
+	p.published = append(p.published, msg)
+
+	return nil
+}
+
+func (p *confirmPublisher) objectIDs(t *testing.T) []string {
+	t.Helper()
+
+	var ids []string
+	for _, msg := range p.published {
+		var event models.EventsToSend
+		if err := json.Unmarshal(msg.Body, &event); err != nil {
+			t.Fatalf("unmarshalling published event: %v", err)
+		}
+		if msg.MessageId == "" {
+			t.Errorf("event %s was published without a message id", event.Data.ObjectID)
+		}
+		ids = append(ids, event.Data.ObjectID)
+	}
+
+	return ids
+}
+
+func TestRelayOutboxRetriesNackedMessages(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory(clock.Real())
+	mem.AddWebhook(models.Webhook{ID: "hook", UserID: "user"})
+
+	var events []models.EventsToSend
+	var pages []models.SnapshotPage
+	for _, id := range []string{"p1", "p2", "p3"} {
+		events = append(events, models.EventsToSend{Type: "page.added", UserID: "user", WebhookID: "hook", Data: models.EventData{ObjectID: id}})
+		pages = append(pages, models.SnapshotPage{PageID: id})
+	}
+	if err := mem.SaveSnapshotBatch(ctx, "hook", 1, time.Now(), pages, events); err != nil {
+		t.Fatalf("SaveSnapshotBatch: %v", err)
+	}
+
+	// The broker nacks p2, so p3 isn't published either.
+	publisher := &confirmPublisher{nack: map[int]bool{2: true}}
+	sent, err := relayOutboxBatch(ctx, mem, publisher)
+	if !errors.Is(err, errNacked) {
+		t.Errorf("got error %v, want the nack", err)
+	}
+	if sent != 1 {
+		t.Errorf("sent %d messages before the nack, want 1", sent)
+	}
+	if got := publisher.objectIDs(t); !reflect.DeepEqual(got, []string{"p1"}) {
+		t.Errorf("published %v before the nack, want [p1]", got)
+	}
+
+	// The next batch picks up where the nack left off without waiting for
+	// the lease to run out.
+	sent, err = relayOutboxBatch(ctx, mem, publisher)
+	if err != nil {
+		t.Fatalf("relayOutboxBatch: %v", err)
+	}
+	if sent != 2 {
+		t.Errorf("sent %d messages after the nack, want 2", sent)
+	}
+	if got := publisher.objectIDs(t); !reflect.DeepEqual(got, []string{"p1", "p2", "p3"}) {
+		t.Errorf("published %v, want every event once, in order", got)
+	}
+
+	sent, err = relayOutboxBatch(ctx, mem, publisher)
+	if err != nil || sent != 0 {
+		t.Errorf("got %d sent and error %v once the outbox was empty", sent, err)
+	}
+
+	if err := mem.PruneSentOutbox(ctx, time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("PruneSentOutbox: %v", err)
+	}
+	if drained := mem.DrainOutbox(); len(drained) != 0 {
+		t.Errorf("got %d events left in the outbox after relaying", len(drained))
+	}
+}
+
+func TestRelayOutboxSkipsLeasedMessages(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory(clock.Real())
+	mem.AddWebhook(models.Webhook{ID: "hook", UserID: "user"})
+
+	events := []models.EventsToSend{{Type: "page.added", UserID: "user", WebhookID: "hook", Data: models.EventData{ObjectID: "p1"}}}
+	if err := mem.SaveSnapshotBatch(ctx, "hook", 1, time.Now(), []models.SnapshotPage{{PageID: "p1"}}, events); err != nil {
+		t.Fatalf("SaveSnapshotBatch: %v", err)
+	}
+
+	// Another relay claimed the message and died before publishing it.
+	claimed, err := mem.ClaimOutbox(ctx, outboxBatchSize, outboxLease)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("got %d claimed and error %v, want the one message", len(claimed), err)
+	}
+
+	publisher := &confirmPublisher{}
+	sent, err := relayOutboxBatch(ctx, mem, publisher)
+	if err != nil || sent != 0 {
+		t.Errorf("got %d sent and error %v while the message was leased, want none", sent, err)
+	}
+}
@@ -3,351 +3,493 @@ package webhook
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"time"
 
-	"github.com/gavsidhu/notion-hooks/internal/logging"
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/metrics"
 	"github.com/gavsidhu/notion-hooks/internal/models"
 	"github.com/gavsidhu/notion-hooks/internal/notion"
-	"github.com/gavsidhu/notion-hooks/internal/utils"
+	"github.com/gavsidhu/notion-hooks/internal/schedule"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/gavsidhu/notion-hooks/internal/tracing"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rabbitmq/amqp091-go"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ProccessWebhook(msg amqp091.Delivery, ch *amqp091.Channel, pool *pgxpool.Pool) {
-	logging.Logger.WithFields(logrus.Fields{
+// ErrUnsupportedObjectType is returned when polling a webhook on a Notion
+// object other than a database.
+var ErrUnsupportedObjectType = errors.New("notion object type not supported")
+
+// defaultSnapshotBatchSize is how many pages a poll holds in memory before
+// it diffs and saves them, which bounds its memory on large databases.
+const defaultSnapshotBatchSize = 500
+
+// Processor handles messages from the processing, initial poll and events
+// queues.
+type Processor struct {
+	log           logrus.FieldLogger
+	stores        store.Stores
+	clock         clock.Clock
+	comments      CommentOptions
+	notionOptions []notion.Option
+	// snapshotBatchSize is lowered by tests to poll in many batches.
+	snapshotBatchSize int
+}
+
+func NewProcessor(log logrus.FieldLogger, stores store.Stores, clk clock.Clock, comments CommentOptions, notionOptions ...notion.Option) *Processor {
+	if comments.Interval <= 0 {
+		comments.Interval = defaultCommentInterval
+	}
+	if comments.MaxPagesPerPoll <= 0 {
+		comments.MaxPagesPerPoll = defaultCommentPagesPerPoll
+	}
+
+	return &Processor{
+		log:           log,
+		stores:        stores,
+		clock:         clk,
+		comments:      comments,
+		notionOptions: notionOptions,
+
+		snapshotBatchSize: defaultSnapshotBatchSize,
+	}
+}
+
+func (p *Processor) ProccessWebhook(ctx context.Context, msg amqp091.Delivery) {
+	p.log.WithFields(logrus.Fields{
 		"webhook_id": string(msg.Body),
 	}).Info("Received message from processing queue")
 
-	webhook, err := GetWebhook(context.Background(), pool, string(msg.Body))
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("webhook.id", string(msg.Body)))
+
+	webhook, err := p.stores.Webhooks.GetWebhook(ctx, string(msg.Body))
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		p.log.WithFields(logrus.Fields{
 			"error":        err,
 			"message_body": string(msg.Body),
 		}).Error("Error getting webhook from database")
+		if !errors.Is(err, store.ErrNotFound) {
+			p.unclaimWebhook(string(msg.Body))
+		}
+		p.reject(msg, string(msg.Body))
 		return
 	}
 
-	accesstoken, err := GetNotionAccessToken(context.Background(), pool, webhook.UserID)
+	polledAt := p.clock.Now()
+	changes := 0
+	defer func() {
+		p.releaseWebhook(webhook, polledAt, changes, err)
+	}()
+
+	changes, err = p.poll(ctx, webhook, polledAt)
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		p.reject(msg, webhook.ID)
+		return
+	}
+
+	if err := msg.Ack(false); err != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":      err,
+			"webhook_id": webhook.ID,
+		}).Error("Error acknowledging message")
+	}
+
+	p.log.WithFields(logrus.Fields{
+		"webhook_id": webhook.ID,
+	}).Info("Successfully processed webhook")
+}
+
+// reject drops a message whose poll failed. It isn't requeued: the webhook
+// is handed back to the scheduler, which polls it again on its schedule.
+func (p *Processor) reject(msg amqp091.Delivery, webhookId string) {
+	if err := msg.Nack(false, false); err != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":      err,
+			"webhook_id": webhookId,
+		}).Error("Error rejecting message")
+	}
+}
+
+// unclaimWebhook hands a webhook that couldn't be loaded back to the
+// scheduler as it was, so it is retried on the next tick.
+func (p *Processor) unclaimWebhook(webhookId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	if err := p.stores.Webhooks.Unclaim(ctx, []string{webhookId}); err != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":      err,
+			"webhook_id": webhookId,
+		}).Error("Error handing webhook back to the scheduler")
+	}
+}
+
+// PollOnce polls a webhook immediately, outside of the scheduler, and
+// returns how many events it generated. It fails with store.ErrWebhookBusy if the
+// webhook is already being polled or is paused.
+func (p *Processor) PollOnce(ctx context.Context, webhookId string) (int, error) {
+	webhook, err := p.stores.Webhooks.Claim(ctx, webhookId)
+	if err != nil {
+		return 0, err
+	}
+
+	polledAt := p.clock.Now()
+	changes, err := p.poll(ctx, webhook, polledAt)
+	p.releaseWebhook(webhook, polledAt, changes, err)
+
+	return changes, err
+}
+
+// poll diffs a claimed webhook's Notion object against its snapshot and
+// returns how many events were generated. Failures are logged here.
+func (p *Processor) poll(ctx context.Context, webhook models.Webhook, polledAt time.Time) (int, error) {
+	accesstoken, err := p.stores.Integrations.GetNotionAccessToken(ctx, webhook.UserID)
+	if err != nil {
+		p.log.WithFields(logrus.Fields{
 			"error":      err,
 			"webhook_id": webhook.ID,
 			"user_id":    webhook.UserID,
 		}).Error("Error getting Notion access token from database")
-		return
+		return 0, err
 	}
 
-	notionClient := notion.NewNotionClient(accesstoken)
+	notionClient := notion.NewNotionClient(accesstoken, p.notionOptions...)
 
-	if webhook.NotionObjectType == "database" {
-		err := handleDatabaseEvents(context.Background(), pool, ch, notionClient, webhook.ID, webhook.UserID, webhook.NotionObjectID, webhook.Events)
+	if webhook.NotionObjectType == "page" && wantsComments(webhook) {
+		changes, err := p.handlePageEvents(ctx, notionClient, webhook)
 		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
+			p.log.WithFields(logrus.Fields{
 				"error":      err,
 				"webhook_id": webhook.ID,
 				"user_id":    webhook.UserID,
-			}).Error("Error handling database events")
-			return
+			}).Error("Error handling page events")
+			metrics.PollsTotal.WithLabelValues("page", "error").Inc()
+			return 0, err
 		}
-	} else {
-		// TODO: Add support for handling page events
-		logging.Logger.WithFields(logrus.Fields{
+		metrics.PollsTotal.WithLabelValues("page", "success").Inc()
+
+		return changes, nil
+	}
+
+	if webhook.NotionObjectType != "database" {
+		// TODO: Add support for handling page events other than comments
+		p.log.WithFields(logrus.Fields{
 			"webhook_id": webhook.ID,
 			"user_id":    webhook.UserID,
 		}).Info("Notion object type not supported")
-		return
+		metrics.PollsTotal.WithLabelValues("other", "unsupported").Inc()
+		return 0, ErrUnsupportedObjectType
 	}
 
-	if err := msg.Ack(false); err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+	changes, err := p.handleDatabaseEvents(ctx, notionClient, webhook, polledAt)
+	if err != nil {
+		p.log.WithFields(logrus.Fields{
 			"error":      err,
 			"webhook_id": webhook.ID,
-		}).Error("Error acknowledging message")
+			"user_id":    webhook.UserID,
+		}).Error("Error handling database events")
+		metrics.PollsTotal.WithLabelValues("database", "error").Inc()
+		return 0, err
 	}
+	metrics.PollsTotal.WithLabelValues("database", "success").Inc()
 
-	logging.Logger.WithFields(logrus.Fields{
-		"webhook_id": webhook.ID,
-	}).Info("Successfully processed webhook")
+	return changes, nil
+}
+
+// handlePageEvents compares the comments on a watched page with its stored
+// discussions and returns how many events were generated. Comments are the
+// only events page webhooks support.
+func (p *Processor) handlePageEvents(ctx context.Context, notionClient *notion.NotionClient, webhook models.Webhook) (changes int, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "webhook.diff_page", trace.WithAttributes(
+		attribute.String("webhook.id", webhook.ID),
+		attribute.String("notion.page.id", webhook.NotionObjectID),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("events.generated", changes))
+		if err != nil {
+			tracing.RecordError(span, err)
+		}
+		span.End()
+	}()
+
+	return p.syncComments(ctx, notionClient, webhook, pageCommentCheck(webhook), p.clock.Now(), polledBefore(webhook))
+}
+
+// polledBefore reports whether the webhook has a baseline to compare a poll
+// with. A webhook whose initial poll failed hasn't, so its first scheduled
+// poll takes the baseline instead of reporting every page as added.
+func polledBefore(webhook models.Webhook) bool {
+	return webhook.LastPolled != nil
 }
 
-func handleDatabaseEvents(ctx context.Context, pool *pgxpool.Pool, ch *amqp091.Channel, notionClient *notion.NotionClient, webhookId string, userId string, notionObjectID string, events []string) error {
-	var eventsToSend []models.EventsToSend
+// handleDatabaseEvents diffs the database against the stored snapshot,
+// stores the resulting events in the outbox together with the new snapshot
+// and returns how many were generated.
+func (p *Processor) handleDatabaseEvents(ctx context.Context, notionClient *notion.NotionClient, webhook models.Webhook, polledAt time.Time) (changes int, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "webhook.diff_database", trace.WithAttributes(
+		attribute.String("webhook.id", webhook.ID),
+		attribute.String("notion.database.id", webhook.NotionObjectID),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("events.generated", changes))
+		if err != nil {
+			tracing.RecordError(span, err)
+		}
+		span.End()
+	}()
 
-	logging.Logger.WithFields(logrus.Fields{
-		"webhookId":      webhookId,
-		"userId":         userId,
-		"notionObjectID": notionObjectID,
-		"events":         events,
+	p.log.WithFields(logrus.Fields{
+		"webhookId":      webhook.ID,
+		"userId":         webhook.UserID,
+		"notionObjectID": webhook.NotionObjectID,
+		"events":         webhook.Events,
 	}).Info("Starting to handle database events")
 
-	newPages, err := notionClient.GetAllDatabasePages(ctx, notionObjectID)
+	return p.syncSnapshot(ctx, notionClient, webhook, polledAt, polledBefore(webhook))
+}
+
+// syncSnapshot streams the webhook's database query into its snapshot
+// in batches of at most snapshotBatchSize pages, so a poll never holds the
+// whole database in memory. Each batch is diffed and saved together with
+// its events before the next is fetched. Once every page has been seen, the
+// pages left over from the previous poll are deleted in batches too, and
+// the comments due to be checked are polled. Events are only generated if
+// emit is set, and the number generated is returned.
+func (p *Processor) syncSnapshot(ctx context.Context, notionClient *notion.NotionClient, webhook models.Webhook, polledAt time.Time, emit bool) (int, error) {
+	query, err := webhookQuery(webhook)
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{
-			"error":          err,
-			"notionObjectID": notionObjectID,
-		}).Error("Error getting all pages from notion database")
-		return err
+		p.log.WithFields(logrus.Fields{
+			"error":     err,
+			"webhookId": webhook.ID,
+		}).Error("Error building the webhook's database query")
+		return 0, err
 	}
 
-	if utils.StringInSlice("page.added", events) || utils.StringInSlice("page.deleted", events) {
-		oldPageIDs, err := GetPageIDsSnapshot(ctx, pool, webhookId)
-		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
-				"error":     err,
-				"webhookId": webhookId,
-			}).Error("Error getting page ids from database")
-			return err
-		}
+	generation, err := p.stores.Snapshots.BeginSnapshot(ctx, webhook.ID)
+	if err != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":     err,
+			"webhookId": webhook.ID,
+		}).Error("Error starting a new snapshot generation")
+		return 0, err
+	}
+
+	filtered := len(webhook.QueryFilter) > 0
+	changes := 0
+	scanned := 0
 
-		newPageIDs, err := notionClient.GetAllDatabasePageIDs(ctx, newPages)
+	saveBatch := func(pages []notion.Page) error {
+		snapshot, err := snapshotPages(pages)
 		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
+			p.log.WithFields(logrus.Fields{
 				"error":     err,
-				"webhookId": webhookId,
-			}).Error("Error getting page ids from new pages")
+				"webhookId": webhook.ID,
+			}).Error("Error building snapshot from new pages")
 			return err
 		}
 
-		added, deleted := findAddedOrDeletedPages(newPageIDs, oldPageIDs)
+		var eventsToSend []models.EventsToSend
+		if emit {
+			diff, err := p.stores.Snapshots.DiffSnapshot(ctx, webhook.ID, snapshot)
+			if err != nil {
+				p.log.WithFields(logrus.Fields{
+					"error":     err,
+					"webhookId": webhook.ID,
+				}).Error("Error comparing new pages with snapshot")
+				return err
+			}
+			if filtered {
+				splitEnteredView(webhook, pages, &diff)
+			}
+			eventsToSend = diffEvents(webhook.ID, webhook.UserID, webhook.Events, diff, p.clock.Now())
+		}
 
-		oldPage, err := GetDatabaseDetailsSnapshot(ctx, pool, webhookId)
+		// The events are handed to the outbox relay, which publishes them to
+		// the events queue once they are committed with the batch.
+		err = p.stores.Snapshots.SaveSnapshotBatch(ctx, webhook.ID, generation, polledAt, snapshot, eventsToSend)
 		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
+			p.log.WithFields(logrus.Fields{
 				"error":     err,
-				"webhookId": webhookId,
-			}).Error("Error getting database details from database")
+				"webhookId": webhook.ID,
+			}).Error("Error saving snapshot batch to database")
 			return err
 		}
 
-		if utils.StringInSlice("page.added", events) && !utils.StringInSlice("page.deleted", events) {
-			for _, id := range added {
-				eventsToSend = append(eventsToSend, models.EventsToSend{
-					Type:      "page.added",
-					UserID:    userId,
-					WebhookID: webhookId,
-					Data: models.EventData{
-						ObjectID:   id,
-						ObjectType: "page",
-						CreatedAt:  time.Now().Unix(),
-					},
-				})
-			}
-		} else if utils.StringInSlice("page.deleted", events) && !utils.StringInSlice("page.added", events) {
-			for _, id := range deleted {
-				eventsToSend = append(eventsToSend, models.EventsToSend{
-					Type:      "page.deleted",
-					UserID:    userId,
-					WebhookID: webhookId,
-					Data: models.EventData{
-						ObjectID:   id,
-						ObjectType: "page",
-						CreatedAt:  time.Now().Unix(),
-					},
-				})
-			}
+		countEvents(eventsToSend)
+		changes += len(eventsToSend)
+		scanned += len(pages)
 
-		} else {
-			for _, id := range added {
-				eventsToSend = append(eventsToSend, models.EventsToSend{
-					Type:      "page.added",
-					UserID:    userId,
-					WebhookID: webhookId,
-					Data: models.EventData{
-						ObjectID:   id,
-						ObjectType: "page",
-						CreatedAt:  time.Now().Unix(),
-					},
-				})
-			}
-			for _, id := range deleted {
-				eventsToSend = append(eventsToSend, models.EventsToSend{
-					Type:      "page.deleted",
-					UserID:    userId,
-					WebhookID: webhookId,
-					Data: models.EventData{
-						ObjectID:   id,
-						ObjectType: "page",
-						CreatedAt:  time.Now().Unix(),
-					},
-				})
-			}
-		}
+		return nil
+	}
 
-		if utils.StringInSlice("page.updated", events) {
-			updatedPages := compareSnapshotsByLastEditedTime(oldPage, newPages)
-			for _, id := range updatedPages {
-				eventsToSend = append(eventsToSend, models.EventsToSend{
-					Type:      "page.updated",
-					UserID:    userId,
-					WebhookID: webhookId,
-					Data: models.EventData{
-						ObjectID:   id,
-						ObjectType: "page",
-						CreatedAt:  time.Now().Unix(),
-					},
-				})
-			}
+	batch := make([]notion.Page, 0, p.snapshotBatchSize)
+	pages := notionClient.IterateDatabase(ctx, webhook.NotionObjectID, query)
+	for pages.Next() {
+		batch = append(batch, pages.Page())
+		if len(batch) < p.snapshotBatchSize {
+			continue
+		}
+		if err := saveBatch(batch); err != nil {
+			return changes, err
+		}
+		batch = batch[:0]
+	}
+	if err := pages.Err(); err != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":          err,
+			"notionObjectID": webhook.NotionObjectID,
+		}).Error("Error getting all pages from notion database")
+		return changes, err
+	}
+	if len(batch) > 0 {
+		if err := saveBatch(batch); err != nil {
+			return changes, err
 		}
 	}
 
-	newPageIDs, err := notionClient.GetAllDatabasePageIDs(ctx, newPages)
+	metrics.PagesScanned.Observe(float64(scanned))
 
-	go func() {
-		err = UpdateSavedPageIDsSnapshot(ctx, pool, webhookId, userId, newPageIDs)
+	// Deletions can only be told apart once the whole query has been seen,
+	// so a poll that fails before then never reports a page as deleted.
+	for {
+		unseen, err := p.stores.Snapshots.UnseenPages(ctx, webhook.ID, generation, p.snapshotBatchSize)
 		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
+			p.log.WithFields(logrus.Fields{
 				"error":     err,
-				"webhookId": webhookId,
-			}).Error("Error updating page ids to database")
-			return
+				"webhookId": webhook.ID,
+			}).Error("Error getting pages missing from the poll")
+			return changes, err
+		}
+		if len(unseen) == 0 {
+			break
 		}
-	}()
 
-	go func() {
-		err = UpdateSavedDatabaseDetailsSnapshot(ctx, pool, webhookId, userId, newPages)
-		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
-				"error":     err,
-				"webhookId": webhookId,
-			}).Error("Error updating database details to database")
-			return
+		var eventsToSend []models.EventsToSend
+		if emit {
+			diff := models.SnapshotDiff{Deleted: unseen}
+			if filtered {
+				if err := splitLeftView(ctx, notionClient, &diff); err != nil {
+					p.log.WithFields(logrus.Fields{
+						"error":     err,
+						"webhookId": webhook.ID,
+					}).Error("Error checking pages that left the webhook's filter")
+					return changes, err
+				}
+			}
+			eventsToSend = diffEvents(webhook.ID, webhook.UserID, webhook.Events, diff, p.clock.Now())
 		}
-	}()
 
-	go func() {
-		err := UpdateWebhookLastPolled(ctx, pool, webhookId)
+		err = p.stores.Snapshots.DeleteSnapshotPages(ctx, webhook.ID, polledAt, unseen, eventsToSend)
 		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
+			p.log.WithFields(logrus.Fields{
 				"error":     err,
-				"webhookId": webhookId,
-			}).Error("Error updating webhook last polled")
-			return
+				"webhookId": webhook.ID,
+			}).Error("Error deleting pages missing from the poll")
+			return changes, err
 		}
-	}()
 
-	for _, event := range eventsToSend {
-		jsonEvent, err := json.Marshal(event)
+		// Discussions go with their page, without comment.resolved events.
+		err = p.stores.Comments.SaveDiscussions(ctx, webhook.ID, unseen, polledAt, nil, nil)
 		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
+			p.log.WithFields(logrus.Fields{
 				"error":     err,
-				"webhookId": webhookId,
-			}).Error("Error marshalling event to json")
-			return err
+				"webhookId": webhook.ID,
+			}).Error("Error deleting discussions on pages missing from the poll")
+			return changes, err
 		}
-		err = ch.PublishWithContext(context.Background(), "", "eventsQueue", false, false, amqp091.Publishing{
-			ContentType: "text/plain",
-			Body:        []byte(jsonEvent),
-		})
+
+		countEvents(eventsToSend)
+		changes += len(eventsToSend)
+	}
+
+	if wantsComments(webhook) {
+		commentChanges, err := p.syncDueComments(ctx, notionClient, webhook, polledAt, emit)
+		changes += commentChanges
 		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
-				"error":     err,
-				"webhookId": webhookId,
-			}).Error("Error publishing event to events queue")
+			return changes, err
 		}
 	}
 
-	return nil
+	return changes, nil
+}
+
+func countEvents(events []models.EventsToSend) {
+	for _, event := range events {
+		metrics.EventsGenerated.WithLabelValues(event.Type).Inc()
+	}
 }
 
-func HandleInitialPolling(msg amqp091.Delivery, ch *amqp091.Channel, pool *pgxpool.Pool) {
-	logging.Logger.WithFields(logrus.Fields{
+func (p *Processor) HandleInitialPolling(ctx context.Context, msg amqp091.Delivery) {
+	p.log.WithFields(logrus.Fields{
 		"message_body": string(msg.Body),
 	}).Info("Received message from initial polling queue")
 
 	var pollMsg models.InitialPollMessage
 	err := json.Unmarshal(msg.Body, &pollMsg)
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		p.log.WithFields(logrus.Fields{
 			"error":        err,
 			"message_body": string(msg.Body),
 		}).Error("Error unmarshalling message")
+		p.reject(msg, "")
 		return
 	}
 
-	accesstoken, err := GetNotionAccessToken(context.Background(), pool, pollMsg.UserID)
+	webhook, err := p.stores.Webhooks.GetWebhook(ctx, pollMsg.WebhookID)
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		p.log.WithFields(logrus.Fields{
 			"error":      err,
 			"webhook_id": pollMsg.WebhookID,
 			"user_id":    pollMsg.UserID,
-		}).Error("Error getting Notion access token from database")
+		}).Error("Error getting webhook from database")
+		if !errors.Is(err, store.ErrNotFound) {
+			p.unclaimWebhook(pollMsg.WebhookID)
+		}
+		p.reject(msg, pollMsg.WebhookID)
 		return
 	}
 
-	notionClient := notion.NewNotionClient(accesstoken)
+	polledAt := p.clock.Now()
 
-	pages, err := notionClient.GetAllDatabasePages(context.Background(), pollMsg.NotionObjectID)
+	accesstoken, err := p.stores.Integrations.GetNotionAccessToken(ctx, pollMsg.UserID)
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		p.log.WithFields(logrus.Fields{
 			"error":      err,
 			"webhook_id": pollMsg.WebhookID,
 			"user_id":    pollMsg.UserID,
-		}).Error("Error getting all pages from notion database")
+		}).Error("Error getting Notion access token from database")
+		p.retryInitialPoll(webhook, polledAt)
+		p.reject(msg, webhook.ID)
 		return
 	}
 
-	pageIDs, err := notionClient.GetAllDatabasePageIDs(context.Background(), pages)
+	notionClient := notion.NewNotionClient(accesstoken, p.notionOptions...)
+
+	// The initial snapshot is only a baseline, so it comes with no events.
+	if webhook.NotionObjectType == "page" {
+		_, err = p.syncComments(ctx, notionClient, webhook, pageCommentCheck(webhook), polledAt, false)
+	} else {
+		_, err = p.syncSnapshot(ctx, notionClient, webhook, polledAt, false)
+	}
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		p.log.WithFields(logrus.Fields{
 			"error":      err,
 			"webhook_id": pollMsg.WebhookID,
 			"user_id":    pollMsg.UserID,
-		}).Error("Error getting all page ids from notion database")
+		}).Error("Error saving initial snapshot to database")
+		p.retryInitialPoll(webhook, polledAt)
+		p.reject(msg, webhook.ID)
 		return
 	}
 
-	go func() {
-		err = SavePageIDsSnapshot(context.Background(), pool, pollMsg.WebhookID, pollMsg.UserID, pageIDs)
-		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
-				"error":      err,
-				"webhook_id": pollMsg.WebhookID,
-				"user_id":    pollMsg.UserID,
-			}).Error("Error saving page ids to database")
-			return
-		}
-	}()
-
-	go func() {
-		err = SaveDatabaseDetailsSnapshot(context.Background(), pool, pollMsg.WebhookID, pollMsg.UserID, pages)
-		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
-				"error":      err,
-				"webhook_id": pollMsg.WebhookID,
-				"user_id":    pollMsg.UserID,
-			}).Error("Error saving database details to database")
-			return
-		}
-	}()
-
-	go func() {
-		err := UpdateWebhookLastPolled(context.Background(), pool, pollMsg.WebhookID)
-		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
-				"error":      err,
-				"webhook_id": pollMsg.WebhookID,
-				"user_id":    pollMsg.UserID,
-			}).Error("Error updating webhook last polled")
-			return
-		}
-	}()
-
-	go func() {
-		err := UpdateWebhookStatus(context.Background(), pool, pollMsg.WebhookID, "idle")
-		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{
-				"error":      err,
-				"webhook_id": pollMsg.WebhookID,
-				"user_id":    pollMsg.UserID,
-			}).Error("Error updating webhook status")
-			return
-		}
-	}()
+	p.releaseWebhook(webhook, polledAt, 0, nil)
 
 	if err := msg.Ack(false); err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		p.log.WithFields(logrus.Fields{
 			"error":      err,
 			"webhook_id": pollMsg.WebhookID,
 		}).Error("Error acknowledging message")
@@ -355,106 +497,123 @@ func HandleInitialPolling(msg amqp091.Delivery, ch *amqp091.Channel, pool *pgxpo
 
 }
 
-func SendEventsToUser(msg amqp091.Delivery, ch *amqp091.Channel, pool *pgxpool.Pool) {
-	logging.Logger.WithFields(logrus.Fields{
+// retryInitialPoll hands a webhook whose initial poll failed back to the
+// scheduler after its plan's minimum interval. No poll is recorded, so the
+// scheduled poll takes the baseline instead.
+func (p *Processor) retryInitialPoll(webhook models.Webhook, polledAt time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	nextPollAt := polledAt.Add(schedule.PlanFor(webhook.Plan).MinInterval)
+	if err := p.stores.Webhooks.Reschedule(ctx, webhook.ID, nextPollAt); err != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":      err,
+			"webhook_id": webhook.ID,
+		}).Error("Error rescheduling initial poll for webhook")
+	}
+}
+
+func (p *Processor) SendEventsToUser(ctx context.Context, msg amqp091.Delivery) {
+	p.log.WithFields(logrus.Fields{
 		"message_body": string(msg.Body),
 	}).Info("Received message from events queue for sending events to user")
 
 	var eventMsg models.EventsToSend
 	err := json.Unmarshal(msg.Body, &eventMsg)
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		p.log.WithFields(logrus.Fields{
 			"error":        err,
 			"message_body": string(msg.Body),
 		}).Error("Error unmarshalling message")
+		p.reject(msg, "")
 		return
 	}
 
+	eventID := eventMsg.ID
+	if eventID == "" {
+		// Published before events were stored.
+		eventID = uuid.New().String()
+	} else {
+		stored, err := p.stores.Events.GetEvent(ctx, eventID)
+		if err == nil && stored.Status == models.EventStatusDelivered {
+			p.log.WithFields(logrus.Fields{
+				"event_id":   eventID,
+				"webhook_id": eventMsg.WebhookID,
+			}).Info("Event already delivered, skipping redelivery")
+			if err := msg.Ack(false); err != nil {
+				p.log.WithFields(logrus.Fields{
+					"error":      err,
+					"webhook_id": eventMsg.WebhookID,
+				}).Error("Error acknowledging message")
+			}
+			return
+		}
+	}
+
 	var event models.Event
 	event = models.Event{
-		ID:        uuid.New().String(),
+		ID:        eventID,
 		Type:      eventMsg.Type,
 		WebhookID: eventMsg.WebhookID,
 		Data:      eventMsg.Data,
-		CreatedAt: time.Now().Unix(),
+		CreatedAt: p.clock.Now().Unix(),
 	}
 
-	url, err := GetURLForWebhook(context.Background(), pool, eventMsg.WebhookID)
+	webhook, err := p.stores.Webhooks.GetWebhook(ctx, eventMsg.WebhookID)
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		p.log.WithFields(logrus.Fields{
 			"error":      err,
 			"webhook_id": eventMsg.WebhookID,
-		}).Error("Error getting URL for webhook")
+		}).Error("Error getting webhook from database")
+		p.reject(msg, eventMsg.WebhookID)
 		return
 	}
 
-	err = SendEventToUser(url, event)
+	deliverCtx, span := tracing.Tracer.Start(ctx, "webhook.deliver",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("webhook.id", event.WebhookID),
+			attribute.String("event.id", event.ID),
+			attribute.String("event.type", event.Type),
+		),
+	)
+	start := time.Now()
+	statusCode, err := SendEventToUser(deliverCtx, p.log, webhook.URL, webhook.Secret, p.clock.Now(), event)
+	metrics.DeliveryDuration.WithLabelValues(metrics.StatusClass(statusCode)).Observe(time.Since(start).Seconds())
+	if statusCode != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	}
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		tracing.RecordError(span, err)
+	}
+	span.End()
+
+	if recordErr := p.stores.Events.RecordDeliveryAttempt(ctx, event.ID, statusCode, err); recordErr != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":      recordErr,
+			"event_id":   event.ID,
+			"webhook_id": eventMsg.WebhookID,
+		}).Error("Error recording delivery attempt")
+	}
+
+	// An endpoint that can't be reached or times out is recorded as a
+	// failed attempt and the message dropped rather than requeued, which
+	// would hand it straight back and hold up every other webhook's events.
+	// The stored event can be replayed.
+	var statusErr *UnexpectedStatusError
+	if err != nil && !errors.As(err, &statusErr) {
+		p.log.WithFields(logrus.Fields{
 			"error":      err,
 			"webhook_id": eventMsg.WebhookID,
 		}).Error("Error sending event to user")
+		p.reject(msg, eventMsg.WebhookID)
 		return
 	}
 
 	if err := msg.Ack(false); err != nil {
-		logging.Logger.WithFields(logrus.Fields{
+		p.log.WithFields(logrus.Fields{
 			"error":      err,
 			"webhook_id": eventMsg.WebhookID,
 		}).Error("Error acknowledging message")
 	}
 }
-
-func compareSnapshotsByLastEditedTime(previous, current *notion.DatabaseQueryResponse) []string {
-	var updatedPages []string
-
-	prevPagesByLastEditedTime := make(map[string]string)
-	for _, page := range previous.Results {
-
-		prevPagesByLastEditedTime[page.ID] = page.LastEditedTime
-
-	}
-
-	for _, currentPage := range current.Results {
-		prevLastEditedTime, exists := prevPagesByLastEditedTime[currentPage.ID]
-		if !exists {
-			updatedPages = append(updatedPages, currentPage.ID)
-			continue
-		}
-
-		if currentPage.LastEditedTime != prevLastEditedTime {
-			updatedPages = append(updatedPages, currentPage.ID)
-		}
-	}
-
-	return updatedPages
-}
-
-func findAddedOrDeletedPages(newPageIDs []string, oldPageIDs []string) ([]string, []string) {
-	var added []string
-	var deleted []string
-
-	newSet := make(map[string]bool)
-	oldSet := make(map[string]bool)
-
-	for _, id := range newPageIDs {
-		newSet[id] = true
-	}
-	for _, id := range oldPageIDs {
-		oldSet[id] = true
-	}
-
-	for id := range newSet {
-		if !oldSet[id] {
-			added = append(added, id)
-		}
-	}
-
-	for id := range oldSet {
-		if !newSet[id] {
-			deleted = append(deleted, id)
-		}
-	}
-
-	return added, deleted
-}
@@ -0,0 +1,500 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/logging"
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/notion"
+	"github.com/gavsidhu/notion-hooks/internal/notion/notiontest"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// acknowledger records what a handler did with a delivery.
+type acknowledger struct {
+	acked   bool
+	nacked  bool
+	requeue bool
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked = true
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.nacked, a.requeue = true, requeue
+	return nil
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func delivery(t *testing.T, body any) (amqp091.Delivery, *acknowledger) {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshalling message: %v", err)
+	}
+	ack := &acknowledger{}
+
+	return amqp091.Delivery{Acknowledger: ack, Body: payload}, ack
+}
+
+// receiver is a user's endpoint that records the events delivered to it.
+type receiver struct {
+	mu     sync.Mutex
+	events []models.Event
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var event models.Event
+	if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func TestPollDiffDeliver(t *testing.T) {
+	ctx := context.Background()
+
+	fake := notiontest.NewServer()
+	defer fake.Close()
+
+	endpoint := &receiver{}
+	endpointServer := httptest.NewServer(endpoint)
+	defer endpointServer.Close()
+
+	database := fake.AddDatabase(notion.Database{})
+	unchanged := fake.AddPage(database.ID, notion.Page{})
+	edited := fake.AddPage(database.ID, notion.Page{})
+	archived := fake.AddPage(database.ID, notion.Page{})
+
+	mem := store.NewMemory(clock.Real())
+	mem.AddIntegration("user-1", "secret_"+t.Name())
+	mem.AddWebhook(models.Webhook{
+		ID:               "webhook-1",
+		UserID:           "user-1",
+		URL:              endpointServer.URL,
+		Events:           []string{"page.added", "page.deleted", "page.updated"},
+		IsActive:         true,
+		Status:           models.WebhookStatusProcessing,
+		PollingInterval:  5,
+		NotionObjectID:   database.ID,
+		NotionObjectType: "database",
+	})
+
+	p := NewProcessor(logging.Nop(), store.MemoryStores(mem), clock.Real(), CommentOptions{}, fake.Options()...)
+
+	msg, ack := delivery(t, models.InitialPollMessage{
+		WebhookID:        "webhook-1",
+		UserID:           "user-1",
+		NotionObjectID:   database.ID,
+		NotionObjectType: "database",
+	})
+	p.HandleInitialPolling(ctx, msg)
+	if !ack.acked {
+		t.Fatalf("initial poll wasn't acknowledged")
+	}
+
+	added := fake.AddPage(database.ID, notion.Page{})
+	fake.EditPage(edited.ID, func(page *notion.Page) {})
+	fake.ArchivePage(archived.ID)
+
+	changes, err := p.PollOnce(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("PollOnce: %v", err)
+	}
+	if changes != 3 {
+		t.Fatalf("got %d changes, want 3", changes)
+	}
+
+	hook, err := mem.GetWebhook(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	if hook.Status != models.WebhookStatusIdle || hook.NextPollAt == nil {
+		t.Errorf("webhook wasn't released back to the scheduler: %+v", hook)
+	}
+
+	for _, event := range mem.DrainOutbox() {
+		msg, ack := delivery(t, event)
+		p.SendEventsToUser(ctx, msg)
+		if !ack.acked {
+			t.Errorf("event %s wasn't acknowledged", event.ID)
+		}
+
+		stored, err := mem.GetEvent(ctx, event.ID)
+		if err != nil {
+			t.Fatalf("GetEvent: %v", err)
+		}
+		if stored.Status != models.EventStatusDelivered || stored.Attempts != 1 {
+			t.Errorf("event %s: got status %s after %d attempts, want delivered after 1", event.ID, stored.Status, stored.Attempts)
+		}
+	}
+
+	got := make(map[string][]string)
+	for _, event := range endpoint.events {
+		got[event.Data.ObjectID] = append(got[event.Data.ObjectID], event.Type)
+	}
+	for _, types := range got {
+		sort.Strings(types)
+	}
+	want := map[string][]string{
+		added.ID:    {"page.added"},
+		edited.ID:   {"page.updated"},
+		archived.ID: {"page.deleted"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+	if _, ok := got[unchanged.ID]; ok {
+		t.Errorf("got an event for the unchanged page")
+	}
+}
+
+func TestSnapshotPagesHashesProperties(t *testing.T) {
+	status := func(name string) map[string]notion.PageProperty {
+		return map[string]notion.PageProperty{
+			"Status": {ID: "s", Type: notion.PropertySelect, Select: &notion.PageSelectProperty{Name: name}},
+		}
+	}
+	pages := []notion.Page{
+		{ID: "a", LastEditedTime: "2024-01-01T00:00:00.000Z", Properties: status("Todo")},
+		{ID: "b", LastEditedTime: "2024-01-01T00:00:00.000Z", Properties: status("Todo")},
+		{ID: "c", LastEditedTime: "2024-01-01T00:00:00.000Z", Properties: status("Done")},
+	}
+
+	snapshot, err := snapshotPages(pages)
+	if err != nil {
+		t.Fatalf("snapshotPages: %v", err)
+	}
+	if len(snapshot) != 3 {
+		t.Fatalf("got %d pages, want 3", len(snapshot))
+	}
+	if want := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC); !snapshot[0].LastEditedTime.Equal(want) {
+		t.Errorf("got last_edited_time %s, want %s", snapshot[0].LastEditedTime, want)
+	}
+	if snapshot[0].PropertyHash != snapshot[1].PropertyHash {
+		t.Errorf("equal properties hashed differently")
+	}
+	if snapshot[0].PropertyHash == snapshot[2].PropertyHash {
+		t.Errorf("different properties hashed the same")
+	}
+}
+
+func TestPollInBatches(t *testing.T) {
+	ctx := context.Background()
+
+	fake := notiontest.NewServer()
+	defer fake.Close()
+
+	database := fake.AddDatabase(notion.Database{})
+	var pages []notion.Page
+	for i := 0; i < 10; i++ {
+		pages = append(pages, fake.AddPage(database.ID, notion.Page{}))
+	}
+
+	mem := store.NewMemory(clock.Real())
+	mem.AddIntegration("user-1", "secret_"+t.Name())
+	mem.AddWebhook(models.Webhook{
+		ID:               "webhook-1",
+		UserID:           "user-1",
+		Events:           []string{"page.added", "page.deleted", "page.updated"},
+		IsActive:         true,
+		Status:           models.WebhookStatusProcessing,
+		PollingInterval:  5,
+		NotionObjectID:   database.ID,
+		NotionObjectType: "database",
+	})
+
+	p := NewProcessor(logging.Nop(), store.MemoryStores(mem), clock.Real(), CommentOptions{}, fake.Options()...)
+	p.snapshotBatchSize = 3
+
+	msg, _ := delivery(t, models.InitialPollMessage{
+		WebhookID:        "webhook-1",
+		UserID:           "user-1",
+		NotionObjectID:   database.ID,
+		NotionObjectType: "database",
+	})
+	p.HandleInitialPolling(ctx, msg)
+
+	snapshot, err := mem.GetSnapshot(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("GetSnapshot: %v", err)
+	}
+	if len(snapshot) != len(pages) {
+		t.Fatalf("got %d pages in the initial snapshot, want %d", len(snapshot), len(pages))
+	}
+
+	want := make(map[string]string)
+	for i := 0; i < 4; i++ {
+		added := fake.AddPage(database.ID, notion.Page{})
+		want[added.ID] = "page.added"
+	}
+	for _, page := range []notion.Page{pages[0], pages[5], pages[9]} {
+		fake.EditPage(page.ID, func(page *notion.Page) {})
+		want[page.ID] = "page.updated"
+	}
+	for _, page := range []notion.Page{pages[1], pages[2], pages[3], pages[6], pages[8]} {
+		fake.ArchivePage(page.ID)
+		want[page.ID] = "page.deleted"
+	}
+
+	changes, err := p.PollOnce(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("PollOnce: %v", err)
+	}
+	if changes != len(want) {
+		t.Errorf("got %d changes, want %d", changes, len(want))
+	}
+
+	got := make(map[string]string)
+	for _, event := range mem.DrainOutbox() {
+		if previous, ok := got[event.Data.ObjectID]; ok {
+			t.Errorf("page %s got both %s and %s", event.Data.ObjectID, previous, event.Type)
+		}
+		got[event.Data.ObjectID] = event.Type
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+
+	snapshot, err = mem.GetSnapshot(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("GetSnapshot: %v", err)
+	}
+	if len(snapshot) != 9 {
+		t.Errorf("got %d pages in the snapshot, want 9", len(snapshot))
+	}
+}
+
+func TestFailedPollIsRejectedAndReleased(t *testing.T) {
+	ctx := context.Background()
+
+	mem := store.NewMemory(clock.Real())
+	// The user has no Notion integration, so the poll fails.
+	mem.AddWebhook(models.Webhook{
+		ID:               "webhook-1",
+		UserID:           "user-1",
+		IsActive:         true,
+		Status:           models.WebhookStatusProcessing,
+		PollingInterval:  5,
+		NotionObjectType: "database",
+	})
+	p := NewProcessor(logging.Nop(), store.MemoryStores(mem), clock.Real(), CommentOptions{})
+
+	for _, id := range []string{"webhook-1", "missing"} {
+		ack := &acknowledger{}
+		p.ProccessWebhook(ctx, amqp091.Delivery{Acknowledger: ack, Body: []byte(id)})
+		if ack.acked || !ack.nacked || ack.requeue {
+			t.Errorf("got %+v for the message for %s, want it nacked without requeue", ack, id)
+		}
+	}
+
+	hook, err := mem.GetWebhook(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	if hook.Status != models.WebhookStatusIdle || hook.NextPollAt == nil {
+		t.Errorf("webhook wasn't released back to the scheduler: %+v", hook)
+	}
+}
+
+func TestFailedInitialPollIsRetriedAsBaseline(t *testing.T) {
+	ctx := context.Background()
+
+	fake := notiontest.NewServer()
+	defer fake.Close()
+
+	database := fake.AddDatabase(notion.Database{})
+	fake.AddPage(database.ID, notion.Page{})
+
+	mem := store.NewMemory(clock.Real())
+	mem.AddWebhook(models.Webhook{
+		ID:               "webhook-1",
+		UserID:           "user-1",
+		Events:           []string{"page.added"},
+		IsActive:         true,
+		Status:           models.WebhookStatusProcessing,
+		PollingInterval:  5,
+		NotionObjectID:   database.ID,
+		NotionObjectType: "database",
+	})
+	p := NewProcessor(logging.Nop(), store.MemoryStores(mem), clock.Real(), CommentOptions{}, fake.Options()...)
+
+	// The user's integration isn't stored yet, so the initial poll fails.
+	msg, ack := delivery(t, models.InitialPollMessage{WebhookID: "webhook-1", UserID: "user-1"})
+	p.HandleInitialPolling(ctx, msg)
+	if ack.acked || !ack.nacked || ack.requeue {
+		t.Fatalf("got %+v for the failed initial poll, want it nacked without requeue", ack)
+	}
+
+	hook, err := mem.GetWebhook(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("GetWebhook: %v", err)
+	}
+	if hook.Status != models.WebhookStatusIdle || hook.NextPollAt == nil || hook.LastPolled != nil {
+		t.Fatalf("webhook wasn't handed back without a poll: %+v", hook)
+	}
+
+	// The retry takes the baseline rather than reporting the existing page.
+	mem.AddIntegration("user-1", "secret_"+t.Name())
+	changes, err := p.PollOnce(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("PollOnce: %v", err)
+	}
+	if changes != 0 {
+		t.Errorf("got %d changes from the baseline poll, want 0", changes)
+	}
+
+	fake.AddPage(database.ID, notion.Page{})
+	changes, err = p.PollOnce(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("PollOnce: %v", err)
+	}
+	if changes != 1 {
+		t.Errorf("got %d changes after adding a page, want 1", changes)
+	}
+}
+
+func TestUndeliverableEventsAreRejected(t *testing.T) {
+	ctx := context.Background()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	mem := store.NewMemory(clock.Real())
+	mem.AddWebhook(models.Webhook{ID: "unreachable", UserID: "user-1", URL: closed.URL})
+	p := NewProcessor(logging.Nop(), store.MemoryStores(mem), clock.Real(), CommentOptions{})
+
+	malformed := amqp091.Delivery{Acknowledger: &acknowledger{}, Body: []byte("{")}
+	missing, _ := delivery(t, models.EventsToSend{Type: "page.added", WebhookID: "missing"})
+	unreachable, _ := delivery(t, models.EventsToSend{Type: "page.added", WebhookID: "unreachable"})
+
+	for name, msg := range map[string]amqp091.Delivery{"malformed": malformed, "missing webhook": missing, "unreachable": unreachable} {
+		p.SendEventsToUser(ctx, msg)
+		if ack := msg.Acknowledger.(*acknowledger); ack.acked || !ack.nacked || ack.requeue {
+			t.Errorf("got %+v for the %s event, want it nacked without requeue", ack, name)
+		}
+	}
+}
+
+func TestHangingEndpointTimesOut(t *testing.T) {
+	ctx := context.Background()
+
+	client := deliveryClient
+	deliveryClient = &http.Client{Timeout: 50 * time.Millisecond}
+	t.Cleanup(func() { deliveryClient = client })
+
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hanging.Close()
+	defer close(release)
+
+	mem := store.NewMemory(clock.Real())
+	mem.AddWebhook(models.Webhook{ID: "hanging", UserID: "user-1", URL: hanging.URL})
+	p := NewProcessor(logging.Nop(), store.MemoryStores(mem), clock.Real(), CommentOptions{})
+
+	msg, ack := delivery(t, models.EventsToSend{Type: "page.added", WebhookID: "hanging"})
+	done := make(chan struct{})
+	go func() {
+		p.SendEventsToUser(ctx, msg)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery to a hanging endpoint didn't time out")
+	}
+	if ack.acked || !ack.nacked || ack.requeue {
+		t.Errorf("got %+v for the timed out delivery, want it nacked without requeue", ack)
+	}
+}
+
+func TestRepeatedPageIsDiffedOnce(t *testing.T) {
+	ctx := context.Background()
+
+	fake := notiontest.NewServer()
+	defer fake.Close()
+
+	database := fake.AddDatabase(notion.Database{})
+	existing := fake.AddPage(database.ID, notion.Page{})
+
+	mem := store.NewMemory(clock.Real())
+	mem.AddIntegration("user-1", "secret_"+t.Name())
+	mem.AddWebhook(models.Webhook{
+		ID:               "webhook-1",
+		UserID:           "user-1",
+		Events:           []string{"page.added", "page.deleted", "page.updated"},
+		IsActive:         true,
+		Status:           models.WebhookStatusProcessing,
+		PollingInterval:  5,
+		NotionObjectID:   database.ID,
+		NotionObjectType: "database",
+	})
+	p := NewProcessor(logging.Nop(), store.MemoryStores(mem), clock.Real(), CommentOptions{}, fake.Options()...)
+
+	msg, ack := delivery(t, models.InitialPollMessage{
+		WebhookID:        "webhook-1",
+		UserID:           "user-1",
+		NotionObjectID:   database.ID,
+		NotionObjectType: "database",
+	})
+	p.HandleInitialPolling(ctx, msg)
+	if !ack.acked {
+		t.Fatalf("initial poll wasn't acknowledged")
+	}
+
+	added := fake.AddPage(database.ID, notion.Page{})
+	fake.EditPage(existing.ID, func(page *notion.Page) {})
+	fake.SetPageRepeated(added.ID, true)
+	fake.SetPageRepeated(existing.ID, true)
+
+	changes, err := p.PollOnce(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("PollOnce: %v", err)
+	}
+	if changes != 2 {
+		t.Errorf("got %d changes, want 2", changes)
+	}
+
+	got := make(map[string][]string)
+	for _, event := range mem.DrainOutbox() {
+		got[event.Data.ObjectID] = append(got[event.Data.ObjectID], event.Type)
+	}
+	want := map[string][]string{
+		added.ID:    {"page.added"},
+		existing.ID: {"page.updated"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+
+	snapshot, err := mem.GetSnapshot(ctx, "webhook-1")
+	if err != nil {
+		t.Fatalf("GetSnapshot: %v", err)
+	}
+	if len(snapshot) != 2 {
+		t.Errorf("got %d pages in the snapshot, want 2", len(snapshot))
+	}
+}
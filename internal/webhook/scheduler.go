@@ -0,0 +1,282 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/schedule"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+const releaseTimeout = 10 * time.Second
+
+// defaultClaimTimeout is how long a webhook may stay processing before the
+// scheduler presumes its poll was lost.
+const defaultClaimTimeout = 30 * time.Minute
+
+// schedulerLockKey is the Postgres advisory lock held by the leading
+// scheduler. Only the leader claims webhooks, so any number of scheduler
+// processes can run for availability.
+const schedulerLockKey int64 = 7_262_014_653
+
+type SchedulerStatus struct {
+	Leader   bool       `json:"leader"`
+	LastTick *time.Time `json:"last_tick,omitempty"`
+}
+
+type SchedulerOptions struct {
+	// Tick is how often the scheduler looks for due webhooks. It bounds how
+	// late a poll can start, so it must stay below the shortest schedule.
+	Tick time.Duration
+	// PerUserLimit caps how many webhooks a user can have processing.
+	PerUserLimit int
+	// MaxClaimsPerTick caps how many webhooks a single tick queues.
+	MaxClaimsPerTick int
+	// ClaimTimeout is how long a webhook may stay processing before it is
+	// returned to idle, in case the worker polling it died. It must exceed
+	// the longest poll. It defaults to 30 minutes.
+	ClaimTimeout time.Duration
+	// Clock drives the ticks. It defaults to the system clock.
+	Clock clock.Clock
+}
+
+type Scheduler struct {
+	// db is only used for the leadership lock; webhooks are claimed through
+	// the store. Without one the scheduler is always the leader, which
+	// suits a single scheduler in tests.
+	db        *pgxpool.Pool
+	webhooks  store.WebhookStore
+	publisher Publisher
+	opts      SchedulerOptions
+	log       logrus.FieldLogger
+
+	// lockConn holds the advisory lock while this scheduler is the leader.
+	lockConn *pgxpool.Conn
+
+	mu     sync.Mutex
+	status SchedulerStatus
+}
+
+func NewScheduler(db *pgxpool.Pool, webhooks store.WebhookStore, publisher Publisher, opts SchedulerOptions, log logrus.FieldLogger) *Scheduler {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real()
+	}
+	if opts.ClaimTimeout <= 0 {
+		opts.ClaimTimeout = defaultClaimTimeout
+	}
+
+	return &Scheduler{
+		db:        db,
+		webhooks:  webhooks,
+		publisher: publisher,
+		opts:      opts,
+		log:       log,
+	}
+}
+
+func (s *Scheduler) Status() SchedulerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.status
+}
+
+func (s *Scheduler) setLeader(leader bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.Leader = leader
+}
+
+func (s *Scheduler) recordTick(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.status.LastTick = &t
+}
+
+// StartPollingDatabase queues due webhooks every tick while this scheduler
+// holds leadership, until ctx is cancelled.
+func (s *Scheduler) StartPollingDatabase(ctx context.Context) error {
+	s.log.Info(fmt.Sprintf("Starting polling database every %s.", s.opts.Tick))
+
+	ticker := s.opts.Clock.NewTicker(s.opts.Tick)
+	defer ticker.Stop()
+	defer s.resign()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.log.Info("Stopping database polling.")
+			return nil
+		case <-ticker.C():
+		}
+
+		err := s.Tick(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			// Keep ticking so a broker outage doesn't stop the scheduler for
+			// good.
+			s.log.WithFields(logrus.Fields{
+				"error": err,
+			}).Error("Error queueing due webhooks for processing")
+		}
+	}
+}
+
+// Tick queues the webhooks that are due now if this scheduler is the
+// leader, after handing back any whose claim has timed out.
+func (s *Scheduler) Tick(ctx context.Context) error {
+	now := s.opts.Clock.Now()
+	s.recordTick(now)
+
+	if !s.ensureLeadership(ctx) {
+		return nil
+	}
+
+	released, err := s.webhooks.ReleaseStaleClaims(ctx, now.Add(-s.opts.ClaimTimeout))
+	if err != nil {
+		return err
+	}
+	if len(released) > 0 {
+		s.log.WithFields(logrus.Fields{
+			"webhook_ids": released,
+		}).Warn("Released webhooks whose poll timed out")
+	}
+
+	err = GetWebhooksForProcessing(ctx, s.webhooks, s.publisher, s.opts.PerUserLimit, s.opts.MaxClaimsPerTick)
+	if err != nil {
+		return err
+	}
+
+	return updateSchedulerBacklog(ctx, s.webhooks)
+}
+
+// ensureLeadership reports whether this scheduler holds the advisory lock,
+// trying to take it if not. Leadership is lost when the lock's connection
+// breaks.
+func (s *Scheduler) ensureLeadership(ctx context.Context) bool {
+	if s.db == nil {
+		s.setLeader(true)
+		return true
+	}
+
+	if s.lockConn != nil {
+		if err := s.lockConn.Ping(ctx); err == nil {
+			return true
+		}
+		s.log.Warn("Lost scheduler leadership")
+		s.lockConn.Conn().Close(ctx)
+		s.lockConn.Release()
+		s.lockConn = nil
+		s.setLeader(false)
+	}
+
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"error": err,
+		}).Error("Error acquiring connection for scheduler leadership")
+		return false
+	}
+
+	var acquired bool
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1);`, schedulerLockKey).Scan(&acquired)
+	if err != nil || !acquired {
+		conn.Release()
+		return false
+	}
+
+	s.log.Info("Acquired scheduler leadership")
+	s.lockConn = conn
+	s.setLeader(true)
+
+	return true
+}
+
+// resign releases the advisory lock so another scheduler can take over
+// without waiting for this process's connections to close.
+func (s *Scheduler) resign() {
+	if s.lockConn == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	_, err := s.lockConn.Exec(ctx, `SELECT pg_advisory_unlock($1);`, schedulerLockKey)
+	if err != nil {
+		s.lockConn.Conn().Close(ctx)
+	}
+	s.lockConn.Release()
+	s.lockConn = nil
+	s.setLeader(false)
+}
+
+func scheduleSpec(webhook models.Webhook) schedule.Spec {
+	return schedule.Spec{
+		Expression: webhook.Schedule,
+		Interval:   time.Duration(webhook.PollingInterval) * time.Minute,
+		Timezone:   webhook.Timezone,
+		Window:     webhook.PollWindow,
+	}
+}
+
+// releaseWebhook computes the webhook's next poll time and hands it back to
+// the scheduler. Adaptive webhooks derive it from the number of changes the
+// poll found, and keep their interval if the poll failed with pollErr. A
+// webhook with an invalid schedule is retried after its plan's minimum
+// interval so it never stays claimed.
+//
+// Releasing uses its own context so a webhook is still handed back to the
+// scheduler when the handler's context was cancelled during shutdown.
+func (p *Processor) releaseWebhook(webhook models.Webhook, polledAt time.Time, changes int, pollErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	var nextPollAt time.Time
+	var interval time.Duration
+	var err error
+
+	adaptive := schedule.Adaptive{
+		Current: time.Duration(webhook.EffectiveIntervalSeconds) * time.Second,
+		Ceiling: time.Duration(webhook.AdaptiveMaxInterval) * time.Minute,
+	}
+	switch {
+	case webhook.AdaptivePolling && pollErr != nil:
+		nextPollAt, interval, err = schedule.RetryAdaptivePollAt(scheduleSpec(webhook), webhook.Plan, adaptive, polledAt)
+	case webhook.AdaptivePolling:
+		nextPollAt, interval, err = schedule.NextAdaptivePollAt(scheduleSpec(webhook), webhook.Plan, adaptive, changes, polledAt)
+	default:
+		nextPollAt, err = schedule.NextPollAt(scheduleSpec(webhook), webhook.Plan, polledAt)
+	}
+	if err != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":      err,
+			"webhook_id": webhook.ID,
+		}).Error("Error computing next poll time for webhook")
+		nextPollAt = polledAt.Add(schedule.PlanFor(webhook.Plan).MinInterval)
+	} else if webhook.AdaptivePolling && pollErr == nil {
+		p.log.WithFields(logrus.Fields{
+			"webhook_id": webhook.ID,
+			"changes":    changes,
+			"interval":   interval.String(),
+		}).Info("Adapted webhook polling interval")
+	}
+
+	err = p.stores.Webhooks.ScheduleNextPoll(ctx, webhook.ID, polledAt, nextPollAt, int(interval/time.Second))
+	if err != nil {
+		p.log.WithFields(logrus.Fields{
+			"error":      err,
+			"webhook_id": webhook.ID,
+		}).Error("Error scheduling next poll for webhook")
+	}
+}
@@ -0,0 +1,115 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/logging"
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+var errBrokerDown = errors.New("broker is down")
+
+// publisher records the webhook IDs queued for processing and fails the
+// calls listed in fail, counting from 1.
+type publisher struct {
+	calls  int
+	fail   map[int]bool
+	queued []string
+}
+
+func (p *publisher) Publish(ctx context.Context, queue string, msg amqp091.Publishing) error {
+	p.calls++
+	if p.fail[p.calls] {
+		return errBrokerDown
+	}
+	p.queued = append(p.queued, string(msg.Body))
+
+	return nil
+}
+
+func dueWebhook(id string, nextPollAt time.Time) models.Webhook {
+	return models.Webhook{
+		ID:         id,
+		UserID:     "user",
+		IsActive:   true,
+		Status:     models.WebhookStatusIdle,
+		NextPollAt: &nextPollAt,
+	}
+}
+
+func TestUnqueuedClaimsAreHandedBack(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	mem := store.NewMemory(clock.Real())
+	mem.AddWebhook(dueWebhook("a", now.Add(-3*time.Minute)))
+	mem.AddWebhook(dueWebhook("b", now.Add(-2*time.Minute)))
+	mem.AddWebhook(dueWebhook("c", now.Add(-time.Minute)))
+
+	pub := &publisher{fail: map[int]bool{2: true}}
+	err := GetWebhooksForProcessing(ctx, mem, pub, 3, 10)
+	if !errors.Is(err, errBrokerDown) {
+		t.Fatalf("got error %v, want the publish failure", err)
+	}
+
+	want := map[string]string{"a": models.WebhookStatusProcessing, "b": models.WebhookStatusIdle, "c": models.WebhookStatusIdle}
+	for id, status := range want {
+		hook, err := mem.GetWebhook(ctx, id)
+		if err != nil {
+			t.Fatalf("GetWebhook: %v", err)
+		}
+		if hook.Status != status {
+			t.Errorf("webhook %s is %s after the publish failed, want %s", id, hook.Status, status)
+		}
+	}
+
+	if err := GetWebhooksForProcessing(ctx, mem, pub, 3, 10); err != nil {
+		t.Fatalf("GetWebhooksForProcessing: %v", err)
+	}
+	if !reflect.DeepEqual(pub.queued, []string{"a", "b", "c"}) {
+		t.Errorf("queued %v, want every webhook once", pub.queued)
+	}
+}
+
+func TestSchedulerReleasesStaleClaims(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewFake(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+	mem := store.NewMemory(clk)
+	mem.AddWebhook(dueWebhook("a", clk.Now()))
+
+	pub := &publisher{}
+	scheduler := NewScheduler(nil, mem, pub, SchedulerOptions{
+		Tick:             5 * time.Second,
+		PerUserLimit:     1,
+		MaxClaimsPerTick: 10,
+		ClaimTimeout:     10 * time.Minute,
+		Clock:            clk,
+	}, logging.Nop())
+
+	if err := scheduler.Tick(ctx); err != nil {
+		t.Fatalf("Tick: %v", err)
+	}
+
+	// The worker polling the webhook dies, so it is never released.
+	clk.Advance(9 * time.Minute)
+	if err := scheduler.Tick(ctx); err != nil {
+		t.Fatalf("Tick: %v", err)
+	}
+	if !reflect.DeepEqual(pub.queued, []string{"a"}) {
+		t.Fatalf("queued %v before the claim timed out, want [a]", pub.queued)
+	}
+
+	clk.Advance(2 * time.Minute)
+	if err := scheduler.Tick(ctx); err != nil {
+		t.Fatalf("Tick: %v", err)
+	}
+	if !reflect.DeepEqual(pub.queued, []string{"a", "a"}) {
+		t.Errorf("queued %v after the claim timed out, want a queued again", pub.queued)
+	}
+}
@@ -2,46 +2,74 @@ package webhook
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"time"
 
-	"github.com/gavsidhu/notion-hooks/internal/logging"
 	"github.com/gavsidhu/notion-hooks/internal/models"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
-func SendEventToUser(url string, event models.Event) error {
+// deliveryTimeout bounds a delivery, so an endpoint that accepts the
+// connection and never answers can't hold up the events queue.
+const deliveryTimeout = 10 * time.Second
+
+// deliveryClient sends every delivery. Tests shorten its timeout.
+var deliveryClient = &http.Client{Timeout: deliveryTimeout}
+
+// UnexpectedStatusError is returned when the user's endpoint answers with a
+// status other than 200.
+type UnexpectedStatusError struct {
+	StatusCode int
+}
+
+func (e *UnexpectedStatusError) Error() string {
+	return fmt.Sprintf("endpoint responded with status %d", e.StatusCode)
+}
+
+// SendEventToUser posts event to url and returns the response status code.
+// When the webhook has a secret the request is signed with it, see
+// SignatureHeader.
+func SendEventToUser(ctx context.Context, log logrus.FieldLogger, url string, secret string, sentAt time.Time, event models.Event) (int, error) {
 	eventBytes, err := json.Marshal(event)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(eventBytes))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(eventBytes))
 	if err != nil {
-		return err
+		return 0, err
 	}
 	request.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		request.Header.Set(SignatureHeader, Sign(secret, sentAt, eventBytes))
+	}
+	// Send the W3C traceparent so receivers can correlate the delivery.
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(request.Header))
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	response, err := deliveryClient.Do(request)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer response.Body.Close()
 
 	bodyBytes, err := io.ReadAll(response.Body)
 	if err != nil {
 		// Log error reading response body
-		logging.Logger.WithFields(logrus.Fields{
+		log.WithFields(logrus.Fields{
 			"error": err,
 			"url":   url,
 		}).Error("Failed to read response body from user event")
-		return err
+		return response.StatusCode, err
 	}
 
 	if response.StatusCode != 200 {
-		logging.Logger.WithFields(logrus.Fields{
+		log.WithFields(logrus.Fields{
 			"event":      event,
 			"response":   string(bodyBytes),
 			"url":        url,
@@ -49,18 +77,16 @@ func SendEventToUser(url string, event models.Event) error {
 			"webhook_id": event.WebhookID,
 		}).Warn("Failed to send event to user's endpoint.")
 
-		// TODO: Implement retry logic and add to log table
-		return nil
+		// TODO: Implement retry logic
+		return response.StatusCode, &UnexpectedStatusError{StatusCode: response.StatusCode}
 	}
 
-	logging.Logger.WithFields(logrus.Fields{
+	log.WithFields(logrus.Fields{
 		"event":    event,
 		"url":      url,
 		"status":   response.StatusCode,
 		"response": string(bodyBytes),
 	}).Info("Successfully sent event to user's endpoint.")
 
-	// TODO add to log table
-
-	return nil
+	return response.StatusCode, nil
 }
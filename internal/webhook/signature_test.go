@@ -0,0 +1,38 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	sentAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"type":"page.added"}`)
+	header := Sign("whsec_test", sentAt, body)
+
+	tests := []struct {
+		name   string
+		secret string
+		header string
+		body   []byte
+		now    time.Time
+		want   error
+	}{
+		{"valid", "whsec_test", header, body, sentAt.Add(time.Minute), nil},
+		{"wrong secret", "whsec_other", header, body, sentAt, ErrSignatureMismatch},
+		{"tampered body", "whsec_test", header, []byte(`{"type":"page.deleted"}`), sentAt, ErrSignatureMismatch},
+		{"too old", "whsec_test", header, body, sentAt.Add(10 * time.Minute), ErrSignatureExpired},
+		{"missing", "whsec_test", "", body, sentAt, ErrSignatureMissing},
+		{"malformed", "whsec_test", "v1=abc", body, sentAt, ErrSignatureMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySignature(tt.secret, tt.header, tt.body, 5*time.Minute, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package webhook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/notion"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/gavsidhu/notion-hooks/internal/utils"
+)
+
+// snapshotPages turns queried pages into the rows a snapshot keeps. Each
+// page's properties are hashed so changes can be told apart without storing
+// them. A page Notion returned twice is kept once, as last returned.
+func snapshotPages(pages []notion.Page) ([]models.SnapshotPage, error) {
+	snapshot := make([]models.SnapshotPage, 0, len(pages))
+	for _, page := range pages {
+		lastEdited, err := time.Parse(time.RFC3339, page.LastEditedTime)
+		if err != nil {
+			return nil, fmt.Errorf("parsing last_edited_time of page %s: %w", page.ID, err)
+		}
+
+		// The normalized form leaves out what changes without an edit, like
+		// the signed URLs of files, and marshalling a map sorts its keys, so
+		// equal properties always hash the same.
+		properties, err := json.Marshal(notion.NormalizeProperties(page.Properties))
+		if err != nil {
+			return nil, fmt.Errorf("marshalling properties of page %s: %w", page.ID, err)
+		}
+		hash := sha256.Sum256(properties)
+
+		snapshot = append(snapshot, models.SnapshotPage{
+			PageID:         page.ID,
+			LastEditedTime: lastEdited,
+			PropertyHash:   hex.EncodeToString(hash[:]),
+		})
+	}
+
+	return store.UniquePages(snapshot), nil
+}
+
+// diffEvents turns a diff into the events the webhook subscribes to. A new
+// page is only added, not also updated.
+func diffEvents(webhookId string, userId string, subscribed []string, diff models.SnapshotDiff, createdAt time.Time) []models.EventsToSend {
+	var events []models.EventsToSend
+	for _, change := range []struct {
+		eventType string
+		pageIDs   []string
+	}{
+		{"page.added", diff.Added},
+		{"page.deleted", diff.Deleted},
+		{"page.updated", diff.Updated},
+		{"page.entered_view", diff.EnteredView},
+		{"page.left_view", diff.LeftView},
+	} {
+		if !utils.StringInSlice(change.eventType, subscribed) {
+			continue
+		}
+		for _, id := range change.pageIDs {
+			events = append(events, models.EventsToSend{
+				Type:      change.eventType,
+				UserID:    userId,
+				WebhookID: webhookId,
+				Data: models.EventData{
+					ObjectID:   id,
+					ObjectType: "page",
+					CreatedAt:  createdAt.Unix(),
+				},
+			})
+		}
+	}
+
+	return events
+}
+
+// webhookQuery is the database query a webhook polls with.
+func webhookQuery(webhook models.Webhook) (notion.Query, error) {
+	query := notion.Query{}
+	if len(webhook.QueryFilter) > 0 {
+		query = query.Filter(notion.RawFilter(webhook.QueryFilter))
+	}
+	if len(webhook.QuerySorts) > 0 {
+		var sorts []notion.Sort
+		if err := json.Unmarshal(webhook.QuerySorts, &sorts); err != nil {
+			return notion.Query{}, fmt.Errorf("decoding query sorts: %w", err)
+		}
+		query = query.Sort(sorts...)
+	}
+
+	return query, nil
+}
+
+// splitEnteredView moves pages that started matching the webhook's filter
+// out of diff.Added. A page new to the snapshot was only added if it was
+// created since the previous poll. pages holds the batch the diff was made
+// from.
+func splitEnteredView(webhook models.Webhook, pages []notion.Page, diff *models.SnapshotDiff) {
+	since := previousPoll(webhook)
+
+	createdTimes := make(map[string]string, len(pages))
+	for _, page := range pages {
+		createdTimes[page.ID] = page.CreatedTime
+	}
+
+	var added []string
+	for _, id := range diff.Added {
+		created, err := time.Parse(time.RFC3339, createdTimes[id])
+		if err == nil && created.Before(since) {
+			diff.EnteredView = append(diff.EnteredView, id)
+			continue
+		}
+		added = append(added, id)
+	}
+	diff.Added = added
+}
+
+// splitLeftView moves pages that stopped matching the webhook's filter out
+// of diff.Deleted. A page missing from the query was only deleted if Notion
+// no longer has it.
+func splitLeftView(ctx context.Context, notionClient *notion.NotionClient, diff *models.SnapshotDiff) error {
+	var deleted []string
+	for _, id := range diff.Deleted {
+		page, err := notionClient.GetPage(ctx, id)
+		switch {
+		case errors.Is(err, notion.ErrNotFound), err == nil && page.Archived:
+			deleted = append(deleted, id)
+		case err != nil:
+			return fmt.Errorf("getting page %s: %w", id, err)
+		default:
+			diff.LeftView = append(diff.LeftView, id)
+		}
+	}
+	diff.Deleted = deleted
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package webhooktest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rabbitmq/amqp091-go"
+)
+
+// Broker is an in-memory stand-in for RabbitMQ. It implements the
+// publisher interfaces the scheduler and outbox relay use, and hands queued
+// messages to handlers with Deliver.
+type Broker struct {
+	mu     sync.Mutex
+	queues map[string][]queued
+	tag    uint64
+}
+
+type queued struct {
+	msg         amqp091.Publishing
+	redelivered bool
+}
+
+func NewBroker() *Broker {
+	return &Broker{queues: make(map[string][]queued)}
+}
+
+func (b *Broker) Publish(ctx context.Context, queue string, msg amqp091.Publishing) error {
+	b.enqueue(queue, queued{msg: msg})
+	return nil
+}
+
+func (b *Broker) enqueue(queue string, q queued) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.queues[queue] = append(b.queues[queue], q)
+}
+
+// PublishWithConfirm publishes msg, which the in-memory broker confirms
+// immediately.
+func (b *Broker) PublishWithConfirm(ctx context.Context, queue string, msg amqp091.Publishing) error {
+	return b.Publish(ctx, queue, msg)
+}
+
+// Len returns how many messages are waiting in queue.
+func (b *Broker) Len(queue string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.queues[queue])
+}
+
+// Deliver hands each message waiting in queue to handler, one at a time,
+// and returns how many were acknowledged. Like RabbitMQ, a message the
+// handler nacks with requeue goes back on the queue, marked as
+// redelivered; messages requeued during the call are not delivered again
+// until the next one. Consumers take one message at a time, so a message
+// the handler leaves unacknowledged holds the only prefetch slot: it and
+// every message behind it stay queued, and later calls stop at it again.
+func (b *Broker) Deliver(ctx context.Context, queue string, handler func(ctx context.Context, msg amqp091.Delivery)) int {
+	b.mu.Lock()
+	pending := b.queues[queue]
+	b.queues[queue] = nil
+	b.mu.Unlock()
+
+	acked := 0
+	for i, q := range pending {
+		msg := q.msg
+		ack := &acknowledger{}
+		b.mu.Lock()
+		b.tag++
+		tag := b.tag
+		b.mu.Unlock()
+
+		handler(ctx, amqp091.Delivery{
+			Acknowledger:  ack,
+			Headers:       msg.Headers,
+			ContentType:   msg.ContentType,
+			DeliveryMode:  msg.DeliveryMode,
+			MessageId:     msg.MessageId,
+			Timestamp:     msg.Timestamp,
+			DeliveryTag:   tag,
+			Redelivered:   q.redelivered,
+			RoutingKey:    queue,
+			Body:          msg.Body,
+			CorrelationId: msg.CorrelationId,
+		})
+
+		switch {
+		case ack.acked:
+			acked++
+		case ack.settled && !ack.requeue:
+			// Rejected without requeue: dropped, as there's no dead letter
+			// queue.
+		case ack.settled:
+			b.enqueue(queue, queued{msg: msg, redelivered: true})
+		default:
+			b.mu.Lock()
+			b.queues[queue] = append(append([]queued{q}, pending[i+1:]...), b.queues[queue]...)
+			b.mu.Unlock()
+			return acked
+		}
+	}
+
+	return acked
+}
+
+type acknowledger struct {
+	acked   bool
+	settled bool
+	requeue bool
+}
+
+func (a *acknowledger) Ack(tag uint64, multiple bool) error {
+	a.acked, a.settled = true, true
+	return nil
+}
+
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.settled, a.requeue = true, requeue
+	return nil
+}
+
+func (a *acknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
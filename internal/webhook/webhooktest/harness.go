@@ -0,0 +1,220 @@
+// Package webhooktest runs the whole polling pipeline in memory: a fake
+// Notion server, the scheduler, the processing, initial poll and events
+// handlers, and a recording receiver, all driven by a fake clock.
+package webhooktest
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/clock"
+	"github.com/gavsidhu/notion-hooks/internal/logging"
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/notion/notiontest"
+	"github.com/gavsidhu/notion-hooks/internal/store"
+	"github.com/gavsidhu/notion-hooks/internal/webhook"
+	"github.com/google/uuid"
+	"github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	processingQueue  = "proccessingQueue"
+	eventsQueue      = "eventsQueue"
+	initialPollQueue = "initalPollQueue"
+)
+
+// SignatureTolerance is how far a delivery's signature timestamp may be
+// from the clock for Verify to accept it.
+const SignatureTolerance = 5 * time.Minute
+
+// CommentInterval and CommentPagesPerPoll bound the comment checks of the
+// harness's polls. Pages are checked on every one minute poll.
+const (
+	CommentInterval     = time.Minute
+	CommentPagesPerPoll = 3
+)
+
+// HistoryRetention is how long the harness keeps replaced snapshot
+// versions.
+const HistoryRetention = 24 * time.Hour
+
+// Harness wires the pipeline together. Tests script Notion through the
+// Notion field and move time with Advance, which runs everything that
+// becomes due until the queues are empty.
+type Harness struct {
+	t testing.TB
+
+	Clock     *clock.Fake
+	Notion    *notiontest.Server
+	Store     *store.Memory
+	Broker    *Broker
+	Receiver  *Receiver
+	Processor *webhook.Processor
+	Scheduler *webhook.Scheduler
+	History   *webhook.History
+}
+
+// New starts a harness whose clock begins at a fixed time. Its servers are
+// closed when the test ends.
+func New(t testing.TB) *Harness {
+	t.Helper()
+
+	h := &Harness{
+		t:        t,
+		Clock:    clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		Notion:   notiontest.NewServer(),
+		Broker:   NewBroker(),
+		Receiver: NewReceiver(),
+	}
+	t.Cleanup(h.Notion.Close)
+	t.Cleanup(h.Receiver.Close)
+
+	h.Store = store.NewMemory(h.Clock)
+	h.Processor = webhook.NewProcessor(logging.Nop(), store.MemoryStores(h.Store), h.Clock, webhook.CommentOptions{
+		Interval:        CommentInterval,
+		MaxPagesPerPoll: CommentPagesPerPoll,
+	}, h.Notion.Options()...)
+	h.Scheduler = webhook.NewScheduler(nil, h.Store, h.Broker, webhook.SchedulerOptions{
+		Tick:             5 * time.Second,
+		PerUserLimit:     2,
+		MaxClaimsPerTick: 100,
+		Clock:            h.Clock,
+	}, logging.Nop())
+	h.History = webhook.NewHistory(h.Store, HistoryRetention, h.Clock)
+
+	return h
+}
+
+// AddWebhook creates a webhook on a Notion database and runs its initial
+// poll, as the API does when a webhook is created. Unset fields get
+// defaults: a generated ID, a user with a Notion integration, the
+// receiver's URL, a signing secret, every page event and a one minute
+// interval.
+func (h *Harness) AddWebhook(hook models.Webhook) models.Webhook {
+	h.t.Helper()
+
+	if hook.ID == "" {
+		hook.ID = uuid.New().String()
+	}
+	if hook.UserID == "" {
+		hook.UserID = "user-" + hook.ID
+	}
+	if hook.URL == "" {
+		hook.URL = h.Receiver.URL()
+	}
+	if hook.Secret == "" {
+		hook.Secret = "whsec_" + hook.ID
+	}
+	if hook.Events == nil {
+		hook.Events = []string{"page.added", "page.deleted", "page.updated"}
+	}
+	if hook.PollingInterval == 0 && hook.Schedule == "" {
+		hook.PollingInterval = 1
+	}
+	if hook.NotionObjectType == "" {
+		hook.NotionObjectType = "database"
+	}
+	hook.IsActive = true
+	hook.Status = models.WebhookStatusProcessing
+	hook.CreatedAt = h.Clock.Now()
+	hook.UpdatedAt = hook.CreatedAt
+
+	if _, err := h.Store.GetNotionAccessToken(context.Background(), hook.UserID); err != nil {
+		// Notion's rate limit is per token, so every user gets their own to
+		// keep tests from waiting on each other.
+		h.Store.AddIntegration(hook.UserID, "secret_"+uuid.New().String())
+	}
+	h.Store.AddWebhook(hook)
+
+	body, err := json.Marshal(models.InitialPollMessage{
+		WebhookID:        hook.ID,
+		UserID:           hook.UserID,
+		NotionObjectID:   hook.NotionObjectID,
+		NotionObjectType: hook.NotionObjectType,
+	})
+	if err != nil {
+		h.t.Fatalf("webhooktest: marshalling initial poll message: %v", err)
+	}
+	h.Broker.Publish(context.Background(), initialPollQueue, amqp091.Publishing{
+		ContentType: "application/json",
+		Timestamp:   h.Clock.Now(),
+		Body:        body,
+	})
+	h.Run()
+
+	return hook
+}
+
+// Advance moves the clock forward by d, lets the scheduler queue whatever
+// is due and runs the pipeline until it is idle.
+func (h *Harness) Advance(d time.Duration) {
+	h.t.Helper()
+
+	h.Clock.Advance(d)
+	if err := h.Scheduler.Tick(context.Background()); err != nil {
+		h.t.Fatalf("webhooktest: scheduler tick: %v", err)
+	}
+	h.Run()
+}
+
+// Run delivers queued messages and relays the outbox until nothing is left
+// or only messages the handlers keep refusing remain.
+func (h *Harness) Run() {
+	h.t.Helper()
+
+	ctx := context.Background()
+	handlers := []struct {
+		queue   string
+		handler func(context.Context, amqp091.Delivery)
+	}{
+		{initialPollQueue, h.Processor.HandleInitialPolling},
+		{processingQueue, h.Processor.ProccessWebhook},
+		{eventsQueue, h.Processor.SendEventsToUser},
+	}
+
+	for {
+		progress := h.relayOutbox()
+		for _, q := range handlers {
+			if h.Broker.Deliver(ctx, q.queue, q.handler) > 0 {
+				progress = true
+			}
+		}
+		if !progress {
+			return
+		}
+	}
+}
+
+// relayOutbox publishes the events the store queued for delivery, as the
+// outbox relay does, and reports whether there were any.
+func (h *Harness) relayOutbox() bool {
+	events := h.Store.DrainOutbox()
+	for _, event := range events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			h.t.Fatalf("webhooktest: marshalling event: %v", err)
+		}
+		h.Broker.PublishWithConfirm(context.Background(), eventsQueue, amqp091.Publishing{
+			ContentType:  "application/json",
+			DeliveryMode: amqp091.Persistent,
+			MessageId:    event.ID,
+			Timestamp:    h.Clock.Now(),
+			Body:         body,
+		})
+	}
+
+	return len(events) > 0
+}
+
+// Verify checks the delivery's signature against its webhook's secret at
+// the current time.
+func (h *Harness) Verify(d Delivery) error {
+	hook, err := h.Store.GetWebhook(context.Background(), d.Event.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	return webhook.VerifySignature(hook.Secret, d.Header.Get(webhook.SignatureHeader), d.Body, SignatureTolerance, h.Clock.Now())
+}
@@ -0,0 +1,375 @@
+package webhooktest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/notion"
+	"github.com/gavsidhu/notion-hooks/internal/webhook"
+	"github.com/gavsidhu/notion-hooks/internal/webhook/webhooktest"
+)
+
+func TestPageAddedDeliveredOnceWithValidSignature(t *testing.T) {
+	h := webhooktest.New(t)
+
+	database := h.Notion.AddDatabase(notion.Database{})
+	h.AddWebhook(models.Webhook{NotionObjectID: database.ID, Events: []string{"page.added"}})
+
+	page := h.Notion.AddPage(database.ID, notion.Page{})
+
+	// Not due yet: the webhook polls once a minute.
+	h.Advance(30 * time.Second)
+	if got := h.Receiver.Deliveries(); len(got) != 0 {
+		t.Fatalf("got %d deliveries before the webhook was due", len(got))
+	}
+
+	h.Advance(30 * time.Second)
+	h.Advance(time.Minute)
+
+	deliveries := h.Receiver.Deliveries("page.added")
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d page.added deliveries, want 1", len(deliveries))
+	}
+	if deliveries[0].Event.Data.ObjectID != page.ID {
+		t.Errorf("got page.added for %s, want %s", deliveries[0].Event.Data.ObjectID, page.ID)
+	}
+	if err := h.Verify(deliveries[0]); err != nil {
+		t.Errorf("invalid signature: %v", err)
+	}
+}
+
+func TestPageUpdatedAndDeleted(t *testing.T) {
+	h := webhooktest.New(t)
+
+	database := h.Notion.AddDatabase(notion.Database{})
+	edited := h.Notion.AddPage(database.ID, notion.Page{})
+	archived := h.Notion.AddPage(database.ID, notion.Page{})
+	h.AddWebhook(models.Webhook{NotionObjectID: database.ID})
+
+	h.Notion.EditPage(edited.ID, func(page *notion.Page) {})
+	h.Notion.ArchivePage(archived.ID)
+	h.Advance(time.Minute)
+
+	updated := h.Receiver.Deliveries("page.updated")
+	if len(updated) != 1 || updated[0].Event.Data.ObjectID != edited.ID {
+		t.Errorf("got page.updated deliveries %+v, want one for %s", updated, edited.ID)
+	}
+	deleted := h.Receiver.Deliveries("page.deleted")
+	if len(deleted) != 1 || deleted[0].Event.Data.ObjectID != archived.ID {
+		t.Errorf("got page.deleted deliveries %+v, want one for %s", deleted, archived.ID)
+	}
+	for _, d := range h.Receiver.Deliveries() {
+		if err := h.Verify(d); err != nil {
+			t.Errorf("%s: invalid signature: %v", d.Event.Type, err)
+		}
+	}
+}
+
+func TestPausedWebhookIsNotPolled(t *testing.T) {
+	h := webhooktest.New(t)
+
+	database := h.Notion.AddDatabase(notion.Database{})
+	hook := h.AddWebhook(models.Webhook{NotionObjectID: database.ID})
+
+	if err := h.Store.Pause(context.Background(), hook.ID); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	h.Notion.AddPage(database.ID, notion.Page{})
+	h.Advance(5 * time.Minute)
+
+	if got := h.Notion.Requests("databases.query"); got != 1 {
+		t.Errorf("got %d queries, want only the initial poll", got)
+	}
+	if got := h.Receiver.Deliveries(); len(got) != 0 {
+		t.Errorf("got %d deliveries for a paused webhook", len(got))
+	}
+}
+
+func TestHistoryDiff(t *testing.T) {
+	h := webhooktest.New(t)
+	ctx := context.Background()
+
+	database := h.Notion.AddDatabase(notion.Database{})
+	edited := h.Notion.AddPage(database.ID, notion.Page{})
+	hook := h.AddWebhook(models.Webhook{NotionObjectID: database.ID})
+	created := h.Clock.Now()
+
+	added := h.Notion.AddPage(database.ID, notion.Page{})
+	h.Notion.EditPage(edited.ID, func(page *notion.Page) {})
+	h.Advance(time.Minute)
+	firstPoll := h.Clock.Now()
+
+	h.Notion.ArchivePage(added.ID)
+	h.Advance(time.Minute)
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{"first poll", created, firstPoll, []string{"page.added " + added.ID, "page.updated " + edited.ID}},
+		{"between polls", created, firstPoll.Add(30 * time.Second), []string{"page.added " + added.ID, "page.updated " + edited.ID}},
+		{"added then deleted", created, h.Clock.Now(), []string{"page.updated " + edited.ID}},
+		{"second poll", firstPoll, h.Clock.Now(), []string{"page.deleted " + added.ID}},
+		{"nothing", firstPoll, firstPoll, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := h.History.Diff(ctx, hook, tt.from, tt.to)
+			if err != nil {
+				t.Fatalf("Diff: %v", err)
+			}
+
+			var got []string
+			for _, event := range diff.Events {
+				got = append(got, event.Type+" "+event.Data.ObjectID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got events %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := h.History.Diff(ctx, hook, created.Add(-time.Minute), created); !errors.Is(err, webhook.ErrHistoryUnavailable) {
+		t.Errorf("got %v for a diff from before the webhook existed, want ErrHistoryUnavailable", err)
+	}
+
+	// Versions replaced more than the retention period ago are pruned, so
+	// that far back can't be rebuilt any more.
+	h.Advance(webhooktest.HistoryRetention)
+	if _, err := h.History.Prune(ctx); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if _, err := h.History.Diff(ctx, hook, created, firstPoll); !errors.Is(err, webhook.ErrHistoryUnavailable) {
+		t.Errorf("got %v for a diff from before retention, want ErrHistoryUnavailable", err)
+	}
+	if _, err := h.History.Diff(ctx, hook, h.Clock.Now().Add(-time.Hour), h.Clock.Now()); err != nil {
+		t.Errorf("Diff within retention: %v", err)
+	}
+}
+
+func TestFilteredWebhookReportsViewChanges(t *testing.T) {
+	h := webhooktest.New(t)
+
+	team := func(name string) map[string]notion.PageProperty {
+		return map[string]notion.PageProperty{
+			"Team": {ID: "team", Type: notion.PropertySelect, Select: &notion.PageSelectProperty{Name: name}},
+		}
+	}
+	setTeam := func(name string) func(page *notion.Page) {
+		return func(page *notion.Page) { page.Properties = team(name) }
+	}
+
+	database := h.Notion.AddDatabase(notion.Database{})
+	h.Notion.AddPage(database.ID, notion.Page{Properties: team("Platform")})
+	leaves := h.Notion.AddPage(database.ID, notion.Page{Properties: team("Platform")})
+	archived := h.Notion.AddPage(database.ID, notion.Page{Properties: team("Platform")})
+	enters := h.Notion.AddPage(database.ID, notion.Page{CreatedTime: "2023-12-01T09:00:00.000Z", Properties: team("Growth")})
+	h.AddWebhook(models.Webhook{
+		NotionObjectID: database.ID,
+		Events:         []string{"page.added", "page.deleted", "page.updated", "page.entered_view", "page.left_view"},
+		QueryFilter:    json.RawMessage(`{"property": "Team", "select": {"equals": "Platform"}}`),
+	})
+
+	h.Notion.EditPage(leaves.ID, setTeam("Growth"))
+	h.Notion.EditPage(enters.ID, setTeam("Platform"))
+	h.Notion.ArchivePage(archived.ID)
+	added := h.Notion.AddPage(database.ID, notion.Page{Properties: team("Platform")})
+	h.Notion.AddPage(database.ID, notion.Page{Properties: team("Growth")})
+	h.Advance(time.Minute)
+
+	got := make(map[string]string)
+	for _, d := range h.Receiver.Deliveries() {
+		got[d.Event.Data.ObjectID] = d.Event.Type
+	}
+	want := map[string]string{
+		leaves.ID:   "page.left_view",
+		enters.ID:   "page.entered_view",
+		archived.ID: "page.deleted",
+		added.ID:    "page.added",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+}
+
+func TestCommentsAddedAndResolved(t *testing.T) {
+	h := webhooktest.New(t)
+
+	text := func(content string) []notion.RichText {
+		return []notion.RichText{{Type: "text", Text: &notion.Text{Content: content}, PlainText: content}}
+	}
+
+	database := h.Notion.AddDatabase(notion.Database{})
+	page := h.Notion.AddPage(database.ID, notion.Page{})
+	other := h.Notion.AddPage(database.ID, notion.Page{})
+	question := h.Notion.AddComment(page.ID, notion.Comment{RichText: text("Ready for review?")})
+	done := h.Notion.AddComment(page.ID, notion.Comment{RichText: text("Fixed the typo")})
+	h.AddWebhook(models.Webhook{
+		NotionObjectID: database.ID,
+		Events:         []string{"page.added", "comment.added", "comment.resolved"},
+	})
+
+	reply := h.Notion.AddComment(page.ID, notion.Comment{
+		DiscussionID: question.DiscussionID,
+		CreatedBy:    notion.PartialUser{Object: "user", ID: "reviewer"},
+		RichText:     text("Looks good"),
+	})
+	h.Notion.ResolveDiscussion(done.DiscussionID)
+	first := h.Notion.AddComment(other.ID, notion.Comment{RichText: text("Who owns this?")})
+	h.Advance(time.Minute)
+
+	added := h.Receiver.Deliveries("comment.added")
+	if len(added) != 2 {
+		t.Fatalf("got %d comment.added deliveries, want 2", len(added))
+	}
+	// Pages due for a comment check are checked in page ID order.
+	if added[0].Event.Data.PageID != page.ID {
+		added[0], added[1] = added[1], added[0]
+	}
+	got := added[0].Event.Data
+	if got.ObjectID != reply.ID || got.PageID != page.ID || got.DiscussionID != question.DiscussionID || got.AuthorID != "reviewer" {
+		t.Errorf("got comment.added %+v, want the reply to %s on %s by reviewer", got, question.DiscussionID, page.ID)
+	}
+	var content []notion.RichText
+	if err := json.Unmarshal(got.Content, &content); err != nil || len(content) != 1 || content[0].PlainText != "Looks good" {
+		t.Errorf("got content %s, want the reply's rich text", got.Content)
+	}
+	if got := added[1].Event.Data; got.ObjectID != first.ID || got.PageID != other.ID {
+		t.Errorf("got comment.added %+v, want %s on %s", got, first.ID, other.ID)
+	}
+
+	resolved := h.Receiver.Deliveries("comment.resolved")
+	if len(resolved) != 1 || resolved[0].Event.Data.DiscussionID != done.DiscussionID || resolved[0].Event.Data.PageID != page.ID {
+		t.Errorf("got comment.resolved deliveries %+v, want one for %s", resolved, done.DiscussionID)
+	}
+
+	// Nothing changed since, so the next poll reports nothing new.
+	h.Advance(time.Minute)
+	if got := h.Receiver.Deliveries(); len(got) != 3 {
+		t.Errorf("got %d deliveries after an idle poll, want 3", len(got))
+	}
+}
+
+func TestPageWebhookReportsComments(t *testing.T) {
+	h := webhooktest.New(t)
+
+	database := h.Notion.AddDatabase(notion.Database{})
+	page := h.Notion.AddPage(database.ID, notion.Page{})
+	h.Notion.AddComment(page.ID, notion.Comment{})
+	h.AddWebhook(models.Webhook{
+		NotionObjectID:   page.ID,
+		NotionObjectType: "page",
+		Events:           []string{"comment.added"},
+	})
+
+	comment := h.Notion.AddComment(page.ID, notion.Comment{})
+	h.Advance(time.Minute)
+
+	added := h.Receiver.Deliveries("comment.added")
+	if len(added) != 1 || added[0].Event.Data.ObjectID != comment.ID {
+		t.Errorf("got comment.added deliveries %+v, want one for %s", added, comment.ID)
+	}
+	if h.Notion.Requests("databases.query") != 0 {
+		t.Errorf("a page webhook queried a database")
+	}
+}
+
+func TestCommentChecksAreCappedPerPoll(t *testing.T) {
+	h := webhooktest.New(t)
+
+	database := h.Notion.AddDatabase(notion.Database{})
+	// Pages are checked in ID order, so the initial poll checks all but the
+	// last two.
+	var pages []notion.Page
+	for i := 0; i < webhooktest.CommentPagesPerPoll+2; i++ {
+		pages = append(pages, h.Notion.AddPage(database.ID, notion.Page{ID: fmt.Sprintf("page-%d", i)}))
+	}
+	h.AddWebhook(models.Webhook{
+		NotionObjectID: database.ID,
+		Events:         []string{"comment.added"},
+	})
+	if got := h.Notion.Requests("comments.list"); got != webhooktest.CommentPagesPerPoll {
+		t.Fatalf("the initial poll listed comments %d times, want %d", got, webhooktest.CommentPagesPerPoll)
+	}
+
+	// The pages the initial poll didn't get to go first.
+	last := pages[len(pages)-1]
+	comment := h.Notion.AddComment(last.ID, notion.Comment{})
+	h.Advance(time.Minute)
+
+	if got := h.Notion.Requests("comments.list"); got != 2*webhooktest.CommentPagesPerPoll {
+		t.Errorf("listed comments %d times after two polls, want %d", got, 2*webhooktest.CommentPagesPerPoll)
+	}
+	added := h.Receiver.Deliveries("comment.added")
+	if len(added) != 1 || added[0].Event.Data.ObjectID != comment.ID {
+		t.Errorf("got comment.added deliveries %+v, want one for %s", added, comment.ID)
+	}
+}
+
+func TestFailingCommentPageIsSkipped(t *testing.T) {
+	h := webhooktest.New(t)
+
+	database := h.Notion.AddDatabase(notion.Database{})
+	broken := h.Notion.AddPage(database.ID, notion.Page{})
+	working := h.Notion.AddPage(database.ID, notion.Page{})
+	h.AddWebhook(models.Webhook{
+		NotionObjectID: database.ID,
+		Events:         []string{"page.updated", "comment.added"},
+	})
+
+	h.Notion.SetCommentsFailing(broken.ID, true)
+	late := h.Notion.AddComment(broken.ID, notion.Comment{})
+	first := h.Notion.AddComment(working.ID, notion.Comment{})
+	h.Notion.EditPage(working.ID, func(page *notion.Page) {})
+	h.Advance(time.Minute)
+
+	if got := h.Receiver.Deliveries("page.updated"); len(got) != 1 {
+		t.Errorf("got %d page.updated deliveries, want the poll to go on without the broken page", len(got))
+	}
+	added := h.Receiver.Deliveries("comment.added")
+	if len(added) != 1 || added[0].Event.Data.ObjectID != first.ID {
+		t.Fatalf("got comment.added deliveries %+v, want one for %s", added, first.ID)
+	}
+
+	// The broken page stays due, so its comment is reported once it loads.
+	h.Notion.SetCommentsFailing(broken.ID, false)
+	h.Advance(time.Minute)
+
+	added = h.Receiver.Deliveries("comment.added")
+	if len(added) != 2 || added[1].Event.Data.ObjectID != late.ID {
+		t.Errorf("got comment.added deliveries %+v, want %s reported after the page recovered", added, late.ID)
+	}
+}
+
+func TestUnreachableEndpointDoesNotHoldUpOthers(t *testing.T) {
+	h := webhooktest.New(t)
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	unreachable := h.Notion.AddDatabase(notion.Database{})
+	working := h.Notion.AddDatabase(notion.Database{})
+	h.AddWebhook(models.Webhook{NotionObjectID: unreachable.ID, URL: closed.URL})
+	h.AddWebhook(models.Webhook{NotionObjectID: working.ID})
+
+	// The unreachable endpoint's event is queued first.
+	h.Notion.AddPage(unreachable.ID, notion.Page{})
+	h.Advance(time.Minute)
+	page := h.Notion.AddPage(working.ID, notion.Page{})
+	h.Advance(time.Minute)
+
+	added := h.Receiver.Deliveries("page.added")
+	if len(added) != 1 || added[0].Event.Data.ObjectID != page.ID {
+		t.Errorf("got page.added deliveries %+v, want one for %s", added, page.ID)
+	}
+}
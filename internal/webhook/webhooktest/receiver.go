@@ -0,0 +1,88 @@
+package webhooktest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/gavsidhu/notion-hooks/internal/models"
+	"github.com/gavsidhu/notion-hooks/internal/utils"
+)
+
+// Delivery is one request the receiver got.
+type Delivery struct {
+	Event  models.Event
+	Header http.Header
+	Body   []byte
+}
+
+// Receiver is a user's endpoint that records every delivery and answers
+// with a configurable status.
+type Receiver struct {
+	server *httptest.Server
+
+	mu         sync.Mutex
+	status     int
+	deliveries []Delivery
+}
+
+func NewReceiver() *Receiver {
+	r := &Receiver{status: http.StatusOK}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+
+	return r
+}
+
+func (r *Receiver) URL() string {
+	return r.server.URL
+}
+
+func (r *Receiver) Close() {
+	r.server.Close()
+}
+
+// RespondWith sets the status code returned for later deliveries.
+func (r *Receiver) RespondWith(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status = status
+}
+
+// Deliveries returns what was delivered so far, optionally only events of
+// the given types.
+func (r *Receiver) Deliveries(types ...string) []Delivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deliveries []Delivery
+	for _, d := range r.deliveries {
+		if len(types) == 0 || utils.StringInSlice(d.Event.Type, types) {
+			deliveries = append(deliveries, d)
+		}
+	}
+
+	return deliveries
+}
+
+func (r *Receiver) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	d := Delivery{Header: req.Header.Clone(), Body: body}
+	if err := json.Unmarshal(body, &d.Event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.deliveries = append(r.deliveries, d)
+	w.WriteHeader(r.status)
+}
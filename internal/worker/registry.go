@@ -0,0 +1,213 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateStarting     State = "starting"
+	StateConsuming    State = "consuming"
+	StateReconnecting State = "reconnecting"
+	StatePaused       State = "paused"
+	StateStopped      State = "stopped"
+)
+
+type Status struct {
+	ID            string     `json:"id"`
+	Queue         string     `json:"queue"`
+	State         State      `json:"state"`
+	Handled       int64      `json:"handled"`
+	LastMessageAt *time.Time `json:"last_message_at,omitempty"`
+}
+
+// Registry tracks the workers running in this process and lets consumption
+// of a queue be paused and resumed at runtime.
+type Registry struct {
+	mu      sync.Mutex
+	workers map[string]*Status
+	queues  map[string]*queueControl
+	nextID  int
+}
+
+// queueControl holds the pause state of a queue. paused is closed when the
+// queue is paused and resumed is closed when it is resumed; the closed one
+// is replaced on every transition so waiters can select on the other.
+type queueControl struct {
+	isPaused bool
+	paused   chan struct{}
+	resumed  chan struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		workers: make(map[string]*Status),
+		queues:  make(map[string]*queueControl),
+	}
+}
+
+// queue returns the control for name. Callers must hold r.mu.
+func (r *Registry) queue(name string) *queueControl {
+	q, ok := r.queues[name]
+	if !ok {
+		q = &queueControl{paused: make(chan struct{}), resumed: make(chan struct{})}
+		close(q.resumed)
+		r.queues[name] = q
+	}
+
+	return q
+}
+
+func (r *Registry) register(queue string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	id := fmt.Sprintf("%s-%d", queue, r.nextID)
+	r.workers[id] = &Status{ID: id, Queue: queue, State: StateStarting}
+	r.queue(queue)
+
+	return id
+}
+
+func (r *Registry) setState(id string, state State) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if w, ok := r.workers[id]; ok {
+		w.State = state
+	}
+}
+
+func (r *Registry) recordMessage(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if w, ok := r.workers[id]; ok {
+		now := time.Now()
+		w.Handled++
+		w.LastMessageAt = &now
+	}
+}
+
+// pausedSignal returns a channel that is closed when queue is paused.
+func (r *Registry) pausedSignal(queue string) <-chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.queue(queue).paused
+}
+
+// waitUntilResumed blocks while queue is paused.
+func (r *Registry) waitUntilResumed(ctx context.Context, queue string) error {
+	r.mu.Lock()
+	resumed := r.queue(queue).resumed
+	r.mu.Unlock()
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Registry) IsPaused(queue string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.queue(queue).isPaused
+}
+
+// Pause stops every worker on queue from consuming until Resume is called.
+// Messages being handled are allowed to finish.
+func (r *Registry) Pause(queue string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, ok := r.queues[queue]
+	if !ok {
+		return fmt.Errorf("no workers for queue %q", queue)
+	}
+	if q.isPaused {
+		return nil
+	}
+
+	q.isPaused = true
+	close(q.paused)
+	q.resumed = make(chan struct{})
+
+	return nil
+}
+
+func (r *Registry) Resume(queue string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q, ok := r.queues[queue]
+	if !ok {
+		return fmt.Errorf("no workers for queue %q", queue)
+	}
+	if !q.isPaused {
+		return nil
+	}
+
+	q.isPaused = false
+	close(q.resumed)
+	q.paused = make(chan struct{})
+
+	return nil
+}
+
+// Statuses returns a snapshot of every registered worker ordered by ID.
+func (r *Registry) Statuses() []Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := make([]Status, 0, len(r.workers))
+	for _, w := range r.workers {
+		statuses = append(statuses, *w)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+
+	return statuses
+}
+
+// Queues returns the names of the queues that have workers in this process.
+func (r *Registry) Queues() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queues := make([]string, 0, len(r.queues))
+	for name := range r.queues {
+		queues = append(queues, name)
+	}
+	sort.Strings(queues)
+
+	return queues
+}
+
+// Ready reports whether every worker on a queue that isn't paused is
+// consuming.
+func (r *Registry) Ready() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, w := range r.workers {
+		if r.queue(w.Queue).isPaused {
+			continue
+		}
+		if w.State != StateConsuming {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,40 +1,152 @@
 package worker
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"time"
 
 	"github.com/gavsidhu/notion-hooks/internal/config"
-	"github.com/gavsidhu/notion-hooks/internal/logging"
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/gavsidhu/notion-hooks/internal/metrics"
+	"github.com/gavsidhu/notion-hooks/internal/tracing"
+	"github.com/google/uuid"
 	"github.com/rabbitmq/amqp091-go"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type handlerFunc func(msg amqp091.Delivery, ch *amqp091.Channel, pool *pgxpool.Pool)
+type handlerFunc func(ctx context.Context, msg amqp091.Delivery)
 
-func StartWorker(rabbitMQ *config.RabbitMQConnection, queueName string, pool *pgxpool.Pool, handler handlerFunc) {
-	logging.Logger.Info(fmt.Sprintf("Starting worker for queue: %s", queueName))
+// restartDelay keeps a consumer that fails while the broker is reachable
+// from spinning.
+const restartDelay = time.Second
 
-	ch, err := rabbitMQ.Conn.Channel()
+// prefetchCount is how many unacknowledged messages the broker hands each
+// consumer. A consumer handles one message at a time, so anything more
+// would only sit in its buffer while other consumers are idle.
+const prefetchCount = 1
+
+// StartWorker consumes queueName until ctx is cancelled, then cancels the
+// consumer and returns once the message being handled has finished.
+// Handlers run with handlerCtx, which outlives ctx so in-flight work can
+// complete during shutdown. If the broker connection drops the worker waits
+// for it to be re-established and starts consuming again, and while the
+// queue is paused in the registry it holds no consumer at all.
+func StartWorker(ctx context.Context, handlerCtx context.Context, log logrus.FieldLogger, registry *Registry, rabbitMQ *config.RabbitMQConnection, queueName string, handler handlerFunc) {
+	log.Info(fmt.Sprintf("Starting worker for queue: %s", queueName))
+
+	id := registry.register(queueName)
+	defer registry.setState(id, StateStopped)
+
+	for {
+		if registry.IsPaused(queueName) {
+			registry.setState(id, StatePaused)
+			log.Info(fmt.Sprintf("Worker paused for queue: %s", queueName))
+			if err := registry.waitUntilResumed(ctx, queueName); err != nil {
+				return
+			}
+			log.Info(fmt.Sprintf("Worker resumed for queue: %s", queueName))
+		}
+
+		err := consume(ctx, handlerCtx, log, registry, id, rabbitMQ, queueName, handler)
+		if ctx.Err() != nil {
+			log.Info(fmt.Sprintf("Stopped worker for queue: %s", queueName))
+			return
+		}
+		if registry.IsPaused(queueName) {
+			continue
+		}
+
+		registry.setState(id, StateReconnecting)
+		log.WithFields(logrus.Fields{
+			"error": err,
+			"queue": queueName,
+		}).Warn("Consumer stopped, restarting once the broker is available")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(restartDelay):
+		}
+
+		if err := rabbitMQ.WaitForConnection(ctx); err != nil {
+			return
+		}
+	}
+}
+
+// consume runs a single consumer until its delivery channel closes, either
+// because ctx was cancelled, the queue was paused or the channel or
+// connection died.
+func consume(ctx context.Context, handlerCtx context.Context, log logrus.FieldLogger, registry *Registry, id string, rabbitMQ *config.RabbitMQConnection, queueName string, handler handlerFunc) error {
+	paused := registry.pausedSignal(queueName)
+
+	ch, err := rabbitMQ.Channel()
 	if err != nil {
-		log.Fatalf("Failed to open a channel: %v", err)
+		return fmt.Errorf("failed to open a channel: %w", err)
 	}
 	defer ch.Close()
 
+	if err := ch.Qos(prefetchCount, 0, false); err != nil {
+		return fmt.Errorf("failed to set the prefetch count: %w", err)
+	}
+
+	consumerTag := fmt.Sprintf("%s-%s", queueName, uuid.New().String())
+
 	msgs, err := ch.Consume(
 		queueName,
-		"",    // consumer
-		false, // auto-ack
-		false, // exclusive
-		false, // no-local
-		false, // no-wait
-		nil,   // args
+		consumerTag, // consumer
+		false,       // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
 	)
 	if err != nil {
-		logging.Logger.Fatalf("Failed to register a consumer: %v", err)
+		return fmt.Errorf("failed to register a consumer: %w", err)
 	}
 
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			log.Info(fmt.Sprintf("Stopping worker for queue: %s", queueName))
+			ch.Cancel(consumerTag, false)
+		case <-paused:
+			ch.Cancel(consumerTag, false)
+		case <-done:
+		}
+	}()
+
+	registry.setState(id, StateConsuming)
+
 	for msg := range msgs {
-		handler(msg, ch, pool)
+		if ctx.Err() != nil || registry.IsPaused(queueName) {
+			// Buffered before the cancel reached the broker; hand it back.
+			msg.Nack(false, true)
+			continue
+		}
+		if !msg.Timestamp.IsZero() {
+			metrics.ConsumerLag.WithLabelValues(queueName).Observe(time.Since(msg.Timestamp).Seconds())
+		}
+		handle(handlerCtx, queueName, msg, handler)
+		registry.recordMessage(id)
 	}
+
+	return amqp091.ErrClosed
+}
+
+// handle runs handler for msg inside a consumer span that continues the
+// trace carried in the message headers.
+func handle(ctx context.Context, queueName string, msg amqp091.Delivery, handler handlerFunc) {
+	ctx = tracing.ExtractAMQP(ctx, msg.Headers)
+	ctx, span := tracing.Tracer.Start(ctx, queueName+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attribute.String("messaging.source.name", queueName)),
+	)
+	defer span.End()
+
+	handler(ctx, msg)
 }